@@ -7,7 +7,9 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/spf13/viper"
@@ -23,6 +25,8 @@ type OrderRequest struct {
 		Quantity  int     `json:"quantity"`
 		Price     float64 `json:"price"`
 	} `json:"items"`
+	// DeliverySlotReservationID is the delivery slot reserved at checkout with /api/cart/slots/reserve
+	DeliverySlotReservationID string `json:"delivery_slot_reservation_id,omitempty"`
 }
 
 type OrderResponse struct {
@@ -37,6 +41,8 @@ func main() {
 
 	http.HandleFunc("/api/cart/status", getStatus)
 	http.HandleFunc("/api/cart", createOrder)
+	http.HandleFunc("/api/cart/slots", getSlots)
+	http.HandleFunc("/api/cart/slots/reserve", reserveSlot)
 
 	host := viper.GetString("server.host")
 	port := viper.GetInt("server.port")
@@ -179,11 +185,13 @@ func createOrder(w http.ResponseWriter, r *http.Request) {
 			Quantity  int     `json:"quantity"`
 			Price     float64 `json:"price"`
 		} `json:"items"`
-		CreatedAt string `json:"created_at"`
+		DeliverySlotReservationID string `json:"delivery_slot_reservation_id,omitempty"`
+		CreatedAt                 string `json:"created_at"`
 	}{
-		CustomerId: "customer456",
-		Items:      orderRequest.Items,
-		CreatedAt:  time.Now().Format(time.RFC3339),
+		CustomerId:                "customer456",
+		Items:                     orderRequest.Items,
+		DeliverySlotReservationID: orderRequest.DeliverySlotReservationID,
+		CreatedAt:                 time.Now().Format(time.RFC3339),
 	}
 
 	omsCoreHost := viper.GetString("external.services.oms-core.host")
@@ -199,7 +207,19 @@ func createOrder(w http.ResponseWriter, r *http.Request) {
 
 	omsCreateOrderUrl := fmt.Sprintf("http://%s/api/orders", omsCoreAddress)
 	log.Printf("[debug] Make call to %s", omsCreateOrderUrl)
-	resp, err := http.Post(omsCreateOrderUrl, "application/json", bytes.NewBuffer(omsBodyRequest))
+	omsRequest, err := http.NewRequestWithContext(r.Context(), http.MethodPost, omsCreateOrderUrl, bytes.NewBuffer(omsBodyRequest))
+	if err != nil {
+		log.Printf("[error] Error creating request to OMS Core: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	omsRequest.Header.Set("Content-Type", "application/json")
+	// Ключ идемпотентности клиента пробрасываем как есть, чтобы повтор не создал второй заказ
+	if key := r.Header.Get("Idempotency-Key"); key != "" {
+		omsRequest.Header.Set("Idempotency-Key", key)
+	}
+
+	resp, err := http.DefaultClient.Do(omsRequest)
 	if err != nil {
 		log.Printf("[error] Error making request to OMG Core: %v", err)
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
@@ -213,6 +233,12 @@ func createOrder(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 		return
 	}
+	// Например, бронь окна доставки истекла: покупатель должен выбрать окно заново
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("[warn] OMS Core rejected the order with status code %d: %s", resp.StatusCode, respBody)
+		http.Error(w, strings.TrimSpace(string(respBody)), resp.StatusCode)
+		return
+	}
 
 	var orderResponse OrderResponse
 	err = json.Unmarshal(respBody, &orderResponse)
@@ -232,3 +258,89 @@ func createOrder(w http.ResponseWriter, r *http.Request) {
 	}
 	log.Println("[info] Request processed successfully")
 }
+
+// getSlots returns the delivery slots of the zone for the day: GET /api/cart/slots?zone=<zone>&date=<YYYY-MM-DD>.
+func getSlots(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[info] Request received %s", r.URL.Path)
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := url.Values{}
+	query.Set("zone", r.URL.Query().Get("zone"))
+	query.Set("date", r.URL.Query().Get("date"))
+
+	callOmsCore(w, r, http.MethodGet, "/api/delivery/slots?"+query.Encode())
+}
+
+// reserveSlot holds a place in the delivery slot chosen at checkout. The reservation expires unless
+// the order is created with it: POST /api/cart/slots/reserve {"slot_id": "..."}.
+func reserveSlot(w http.ResponseWriter, r *http.Request) {
+	log.Printf("[info] Request received %s", r.URL.Path)
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Access-Control-Allow-Headers", "*")
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
+
+	if r.Method == http.MethodOptions {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var request struct {
+		SlotId string `json:"slot_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil || request.SlotId == "" {
+		log.Printf("[error] Invalid reserve slot request: %v", err)
+		http.Error(w, "Bad Request", http.StatusBadRequest)
+		return
+	}
+
+	callOmsCore(w, r, http.MethodPost, "/api/delivery/slots/"+url.PathEscape(request.SlotId)+"/reserve")
+}
+
+// callOmsCore makes a request without a body to OMS Core and passes its response to the client as is.
+func callOmsCore(w http.ResponseWriter, r *http.Request, method, path string) {
+	omsCoreHost := viper.GetString("external.services.oms-core.host")
+	omsCorePort := viper.GetInt("external.services.oms-core.port")
+	omsCoreAddress := fmt.Sprintf("%s:%d", omsCoreHost, omsCorePort)
+
+	omsUrl := fmt.Sprintf("http://%s%s", omsCoreAddress, path)
+	log.Printf("[debug] Make call to %s", omsUrl)
+	omsRequest, err := http.NewRequestWithContext(r.Context(), method, omsUrl, nil)
+	if err != nil {
+		log.Printf("[error] Error creating request to OMS Core: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	resp, err := http.DefaultClient.Do(omsRequest)
+	if err != nil {
+		log.Printf("[error] Error making request to OMS Core: %v", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	defer resp.Body.Close()
+
+	w.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+	w.WriteHeader(resp.StatusCode)
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		log.Printf("[error] Error copying response body: %v", err)
+		return
+	}
+	log.Println("[info] Request processed successfully")
+}
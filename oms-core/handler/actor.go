@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+)
+
+// ActorHeader identifies who performs the request: a support operator, a picker or a service name.
+const ActorHeader = "X-Actor"
+
+// maxActorLength is the size of the order_status_history.actor column.
+const maxActorLength = 255
+
+// WithActor puts the request actor into the context, so status changes made
+// while handling the request are attributed to it in the order history.
+// An actor longer than the history column is truncated.
+func WithActor(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		actor := []rune(r.Header.Get(ActorHeader))
+		if len(actor) > maxActorLength {
+			actor = actor[:maxActorLength]
+		}
+		ctx := repository.WithStatusChange(r.Context(), repository.StatusChange{
+			Actor: string(actor),
+		})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// withReason adds the reason of a status change to the request context.
+func withReason(r *http.Request, reason string) *http.Request {
+	change := repository.StatusChangeFromContext(r.Context())
+	change.Reason = reason
+	return r.WithContext(repository.WithStatusChange(r.Context(), change))
+}
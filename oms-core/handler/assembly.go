@@ -4,8 +4,10 @@ import (
 	"encoding/json"
 	"log"
 	"net/http"
+	"time"
 
 	"github.com/milovidov983/oms-temporal-demo/oms-core/service"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
 )
 
 type AssemblyApplicationHandler struct {
@@ -23,23 +25,33 @@ func (h *AssemblyApplicationHandler) CreateApplication(w http.ResponseWriter, r
 		return
 	}
 
+	// warehouse_id - необязательный явный выбор склада, иначе склад выбирают правила маршрутизации;
+	// order_type - тип заказа для SLA сборки, по умолчанию ASSEMBLY
 	var request struct {
-		OrderID string `json:"order_id"`
+		OrderID     string           `json:"order_id"`
+		WarehouseID string           `json:"warehouse_id"`
+		OrderType   models.OrderType `json:"order_type"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
 		log.Printf("[error] Failed to decode request body: %v", err)
 		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
-	application, err := h.service.CreateAssemblyApplication(r.Context(), request.OrderID)
+	application, err := h.service.CreateAssemblyApplication(r.Context(), request.OrderID, request.WarehouseID, request.OrderType)
 	if err != nil {
 		log.Printf("[error] Failed to create assembly application: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"application_id": application.ID})
+	json.NewEncoder(w).Encode(struct {
+		ApplicationID string     `json:"application_id"`
+		DueAt         *time.Time `json:"due_at,omitempty"`
+	}{
+		ApplicationID: application.ID,
+		DueAt:         application.DueAt,
+	})
 	log.Printf("[info] Assembly application created: %s", application.ID)
 }
 
@@ -50,8 +62,15 @@ func (h *AssemblyApplicationHandler) CompleteApplication(w http.ResponseWriter,
 		return
 	}
 
+	// collected - фактически собранные позиции; если не передан, считаем что собрано все;
+	// packages - посылки, в которые упакован заказ, штрихкод необязателен
 	var request struct {
 		ApplicationID string `json:"application_id"`
+		Collected     []struct {
+			ProductID string `json:"product_id"`
+			Quantity  int    `json:"quantity"`
+		} `json:"collected"`
+		Packages []models.Package `json:"packages"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -60,9 +79,24 @@ func (h *AssemblyApplicationHandler) CompleteApplication(w http.ResponseWriter,
 		return
 	}
 
-	if err := h.service.CompleteAssembly(r.Context(), request.ApplicationID); err != nil {
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	var collected []models.AssemblyItem
+	if request.Collected != nil {
+		collected = make([]models.AssemblyItem, len(request.Collected))
+		for i, line := range request.Collected {
+			collected[i] = models.AssemblyItem{ProductID: line.ProductID, Collected: line.Quantity}
+		}
+	}
+
+	if err := h.service.CompleteAssembly(r.Context(), request.ApplicationID, expectedVersion, collected, request.Packages); err != nil {
 		log.Printf("[error] Failed to complete assembly application: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -71,6 +105,36 @@ func (h *AssemblyApplicationHandler) CompleteApplication(w http.ResponseWriter,
 	log.Printf("[info] Assembly application completed: %s", request.ApplicationID)
 }
 
+func (h *AssemblyApplicationHandler) ChangeComment(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	var request struct {
+		Comment string `json:"comment"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	application, err := h.service.ChangeComment(r.Context(), applicationID, request.Comment, expectedVersion)
+	if err != nil {
+		log.Printf("[error] Failed to change assembly application comment: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeApplication(w, application)
+	log.Printf("[info] Assembly application comment changed: %s", applicationID)
+}
+
 func (h *AssemblyApplicationHandler) CancelApplication(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		log.Printf("[warn] Method not allowed: %s", r.Method)
@@ -80,6 +144,7 @@ func (h *AssemblyApplicationHandler) CancelApplication(w http.ResponseWriter, r
 
 	var request struct {
 		ApplicationID string `json:"application_id"`
+		Reason        string `json:"reason"`
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
@@ -88,13 +153,20 @@ func (h *AssemblyApplicationHandler) CancelApplication(w http.ResponseWriter, r
 		return
 	}
 
-	if err := h.service.CancelAssembly(r.Context(), request.ApplicationID); err != nil {
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	if err := h.service.CancelAssembly(r.Context(), request.ApplicationID, expectedVersion, request.Reason); err != nil {
 		log.Printf("[error] Failed to cancel assembly application: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"status": "cancelled"})
-	log.Printf("[info] Assembly application cancelled: %s", request.ApplicationID)
+	log.Printf("[info] Assembly application cancelled: %s, reason: %s", request.ApplicationID, request.Reason)
 }
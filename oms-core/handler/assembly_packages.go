@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/label"
+)
+
+// GetLabel returns the shipping labels of the packages, ?format=pdf (default) or ?format=zpl.
+func (h *AssemblyApplicationHandler) GetLabel(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	format := label.Format(r.URL.Query().Get("format"))
+	if format == "" {
+		format = label.FormatPDF
+	}
+
+	document, err := h.service.Label(r.Context(), applicationID, format)
+	if err != nil {
+		log.Printf("[error] Failed to render assembly labels: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", fmt.Sprintf("inline; filename=\"label-%s.%s\"", applicationID, format))
+	w.Write(document)
+	log.Printf("[info] Labels of assembly application %s rendered as %s", applicationID, format)
+}
@@ -0,0 +1,140 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// pickerRequest is the body of the queue endpoints that act on behalf of a picker.
+type pickerRequest struct {
+	PickerID string `json:"picker_id"`
+}
+
+func (h *AssemblyApplicationHandler) ListQueue(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+
+	applications, err := h.service.ListQueue(r.Context(), r.URL.Query().Get("warehouse_id"), limit)
+	if err != nil {
+		log.Printf("[error] Failed to list assembly queue: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applications)
+	log.Printf("[info] Assembly queue listed: %d", len(applications))
+}
+
+func (h *AssemblyApplicationHandler) ListPickerApplications(w http.ResponseWriter, r *http.Request) {
+	pickerID := r.PathValue("picker_id")
+
+	applications, err := h.service.ListPickerApplications(r.Context(), pickerID)
+	if err != nil {
+		log.Printf("[error] Failed to list picker applications: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applications)
+	log.Printf("[info] Applications of picker %s listed: %d", pickerID, len(applications))
+}
+
+func (h *AssemblyApplicationHandler) ClaimNext(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		pickerRequest
+		WarehouseID string `json:"warehouse_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	application, err := h.service.ClaimNext(r.Context(), request.PickerID, request.WarehouseID)
+	if err != nil {
+		log.Printf("[error] Failed to claim assembly application: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeApplication(w, application)
+	log.Printf("[info] Assembly application %s claimed by picker %s", application.ID, request.PickerID)
+}
+
+func (h *AssemblyApplicationHandler) Claim(w http.ResponseWriter, r *http.Request) {
+	h.pickerAction(w, r, "claim", h.service.Claim)
+}
+
+func (h *AssemblyApplicationHandler) StartApplication(w http.ResponseWriter, r *http.Request) {
+	h.pickerAction(w, r, "start", h.service.StartAssembly)
+}
+
+func (h *AssemblyApplicationHandler) Release(w http.ResponseWriter, r *http.Request) {
+	h.pickerAction(w, r, "release", h.service.Release)
+}
+
+// pickerAction handles POST /api/assembly/{id}/<action> with the picker in the body and an optional If-Match.
+func (h *AssemblyApplicationHandler) pickerAction(
+	w http.ResponseWriter,
+	r *http.Request,
+	action string,
+	do func(ctx context.Context, applicationID, pickerID string, expectedVersion int) (*models.AssemblyApplication, error),
+) {
+	applicationID := r.PathValue("id")
+
+	var request pickerRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	application, err := do(r.Context(), applicationID, request.PickerID, expectedVersion)
+	if err != nil {
+		log.Printf("[error] Failed to %s assembly application: %v", action, err)
+		writeError(w, err)
+		return
+	}
+
+	writeApplication(w, application)
+	log.Printf("[info] Assembly application %s: %s by picker %s", applicationID, action, request.PickerID)
+}
+
+// queryLimit parses the optional ?limit parameter, zero means the default limit.
+// An invalid value is answered with 400 and ok is false.
+func queryLimit(w http.ResponseWriter, r *http.Request) (limit int, ok bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return 0, true
+	}
+
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 {
+		log.Printf("[warn] Invalid limit: %s", value)
+		http.Error(w, "limit must be a positive integer", http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+func writeApplication(w http.ResponseWriter, application *models.AssemblyApplication) {
+	setETag(w, application.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(application)
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// RejectApplication is called by the quality check when a complete assembly has to be done again.
+// It responds with the next attempt.
+func (h *AssemblyApplicationHandler) RejectApplication(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	next, err := h.service.RejectAssembly(r.Context(), applicationID, expectedVersion, request.Reason)
+	if err != nil {
+		log.Printf("[error] Failed to reject assembly application: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeApplication(w, next)
+	log.Printf("[info] Assembly application rejected: %s, next attempt: %s, reason: %s", applicationID, next.ID, request.Reason)
+}
+
+// AcceptApplication is called by the order workflow when it moves past the assembly,
+// a later rejection by the quality check gets 409.
+func (h *AssemblyApplicationHandler) AcceptApplication(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	application, err := h.service.AcceptAssembly(r.Context(), applicationID)
+	if err != nil {
+		log.Printf("[error] Failed to accept assembly application: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeApplication(w, application)
+	log.Printf("[info] Assembly application accepted: %s", applicationID)
+}
+
+// ListAttempts returns the history of assembly attempts of the order.
+func (h *AssemblyApplicationHandler) ListAttempts(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+
+	applications, err := h.service.ListAttempts(r.Context(), orderID)
+	if err != nil {
+		log.Printf("[error] Failed to list assembly attempts: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applications)
+	log.Printf("[info] Assembly attempts of order %s listed: %d", orderID, len(applications))
+}
@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// MarkOverdue is called by the order workflow when the assembly missed its SLA.
+func (h *AssemblyApplicationHandler) MarkOverdue(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	application, err := h.service.MarkOverdue(r.Context(), applicationID)
+	if err != nil {
+		log.Printf("[error] Failed to mark assembly application overdue: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeApplication(w, application)
+	log.Printf("[info] Assembly application overdue: %s", applicationID)
+}
+
+// ListOverdue is the report of open applications past their SLA.
+func (h *AssemblyApplicationHandler) ListOverdue(w http.ResponseWriter, r *http.Request) {
+	limit, ok := queryLimit(w, r)
+	if !ok {
+		return
+	}
+
+	applications, err := h.service.ListOverdue(r.Context(), r.URL.Query().Get("warehouse_id"), limit)
+	if err != nil {
+		log.Printf("[error] Failed to list overdue assembly applications: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applications)
+	log.Printf("[info] Overdue assembly applications listed: %d", len(applications))
+}
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+func (h *AssemblyApplicationHandler) ProposeSubstitution(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	// product_id - ненайденный товар, quantity его единиц заменяется тем же количеством substitute_product_id
+	var request struct {
+		ProductID           string       `json:"product_id"`
+		SubstituteProductID string       `json:"substitute_product_id"`
+		Quantity            int          `json:"quantity"`
+		Price               models.Money `json:"price"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	substitution := models.Substitution{
+		ProductID:           request.ProductID,
+		SubstituteProductID: request.SubstituteProductID,
+		Quantity:            request.Quantity,
+		Price:               request.Price,
+	}
+	application, err := h.service.ProposeSubstitution(r.Context(), applicationID, substitution, expectedVersion)
+	if err != nil {
+		log.Printf("[error] Failed to propose substitution: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeApplication(w, application)
+	log.Printf("[info] Substitution of product %s proposed for assembly application %s", request.ProductID, applicationID)
+}
+
+func (h *AssemblyApplicationHandler) ApproveSubstitution(w http.ResponseWriter, r *http.Request) {
+	h.substitutionDecision(w, r, "approve", h.service.ApproveSubstitution)
+}
+
+func (h *AssemblyApplicationHandler) RejectSubstitution(w http.ResponseWriter, r *http.Request) {
+	h.substitutionDecision(w, r, "reject", h.service.RejectSubstitution)
+}
+
+// substitutionDecision handles POST /api/assembly/{id}/substitutions/{substitution_id}/<decision>
+// with an optional If-Match.
+func (h *AssemblyApplicationHandler) substitutionDecision(
+	w http.ResponseWriter,
+	r *http.Request,
+	decision string,
+	decide func(ctx context.Context, applicationID, substitutionID string, expectedVersion int) (*models.AssemblyApplication, error),
+) {
+	applicationID := r.PathValue("id")
+	substitutionID := r.PathValue("substitution_id")
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	application, err := decide(r.Context(), applicationID, substitutionID, expectedVersion)
+	if err != nil {
+		log.Printf("[error] Failed to %s substitution: %v", decision, err)
+		writeError(w, err)
+		return
+	}
+
+	writeApplication(w, application)
+	log.Printf("[info] Substitution %s of assembly application %s: %s", substitutionID, applicationID, decision)
+}
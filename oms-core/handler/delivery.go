@@ -0,0 +1,234 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/service"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+type DeliveryApplicationHandler struct {
+	service *service.DeliveryApplicationService
+}
+
+func NewDeliveryApplicationHandler(service *service.DeliveryApplicationService) *DeliveryApplicationHandler {
+	return &DeliveryApplicationHandler{service: service}
+}
+
+// courierRequest is the body of the endpoints a courier app calls.
+type courierRequest struct {
+	CourierID string `json:"courier_id"`
+}
+
+func (h *DeliveryApplicationHandler) CreateApplication(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		OrderID string `json:"order_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	application, err := h.service.CreateDeliveryApplication(r.Context(), request.OrderID)
+	if err != nil {
+		log.Printf("[error] Failed to create delivery application: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeDeliveryApplication(w, application)
+	log.Printf("[info] Delivery application created: %s", application.ID)
+}
+
+func (h *DeliveryApplicationHandler) GetApplication(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	application, err := h.service.Get(r.Context(), applicationID)
+	if err != nil {
+		log.Printf("[error] Failed to get delivery application: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeDeliveryApplication(w, application)
+	log.Printf("[info] Delivery application retrieved: %s", applicationID)
+}
+
+func (h *DeliveryApplicationHandler) ListByOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+
+	applications, err := h.service.ListByOrder(r.Context(), orderID)
+	if err != nil {
+		log.Printf("[error] Failed to list delivery applications: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applications)
+	log.Printf("[info] Delivery applications of order %s listed: %d", orderID, len(applications))
+}
+
+func (h *DeliveryApplicationHandler) ListCourierApplications(w http.ResponseWriter, r *http.Request) {
+	courierID := r.PathValue("courier_id")
+
+	applications, err := h.service.ListCourierApplications(r.Context(), courierID)
+	if err != nil {
+		log.Printf("[error] Failed to list courier applications: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(applications)
+	log.Printf("[info] Applications of courier %s listed: %d", courierID, len(applications))
+}
+
+func (h *DeliveryApplicationHandler) Assign(w http.ResponseWriter, r *http.Request) {
+	h.courierAction(w, r, "assign", h.service.Assign)
+}
+
+func (h *DeliveryApplicationHandler) Release(w http.ResponseWriter, r *http.Request) {
+	h.courierAction(w, r, "release", h.service.Release)
+}
+
+func (h *DeliveryApplicationHandler) PickUp(w http.ResponseWriter, r *http.Request) {
+	h.courierAction(w, r, "pick up", h.service.PickUp)
+}
+
+func (h *DeliveryApplicationHandler) Deliver(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	// proof_of_delivery - ссылки на фото и подпись, загруженные приложением курьера в свое хранилище
+	var request struct {
+		courierRequest
+		ProofOfDelivery models.ProofOfDelivery `json:"proof_of_delivery"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	application, err := h.service.Deliver(r.Context(), applicationID, request.CourierID, request.ProofOfDelivery, expectedVersion)
+	if err != nil {
+		log.Printf("[error] Failed to deliver delivery application: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeDeliveryApplication(w, application)
+	log.Printf("[info] Delivery application %s delivered by courier %s", applicationID, request.CourierID)
+}
+
+func (h *DeliveryApplicationHandler) Fail(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	var request struct {
+		courierRequest
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	application, err := h.service.Fail(r.Context(), applicationID, request.CourierID, request.Reason, expectedVersion)
+	if err != nil {
+		log.Printf("[error] Failed to record delivery failure: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeDeliveryApplication(w, application)
+	log.Printf("[info] Delivery application %s failed by courier %s, reason: %s", applicationID, request.CourierID, request.Reason)
+}
+
+func (h *DeliveryApplicationHandler) CancelApplication(w http.ResponseWriter, r *http.Request) {
+	applicationID := r.PathValue("id")
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	application, err := h.service.CancelDelivery(r.Context(), applicationID, expectedVersion, request.Reason)
+	if err != nil {
+		log.Printf("[error] Failed to cancel delivery application: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writeDeliveryApplication(w, application)
+	log.Printf("[info] Delivery application cancelled: %s, reason: %s", applicationID, request.Reason)
+}
+
+// courierAction handles POST /api/delivery/{id}/<action> with the courier in the body and an optional If-Match.
+func (h *DeliveryApplicationHandler) courierAction(
+	w http.ResponseWriter,
+	r *http.Request,
+	action string,
+	do func(ctx context.Context, applicationID, courierID string, expectedVersion int) (*models.DeliveryApplication, error),
+) {
+	applicationID := r.PathValue("id")
+
+	var request courierRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	application, err := do(r.Context(), applicationID, request.CourierID, expectedVersion)
+	if err != nil {
+		log.Printf("[error] Failed to %s delivery application: %v", action, err)
+		writeError(w, err)
+		return
+	}
+
+	writeDeliveryApplication(w, application)
+	log.Printf("[info] Delivery application %s: %s by courier %s", applicationID, action, request.CourierID)
+}
+
+func writeDeliveryApplication(w http.ResponseWriter, application *models.DeliveryApplication) {
+	setETag(w, application.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(application)
+}
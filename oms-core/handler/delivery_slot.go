@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/service"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// slotDateLayout is the format of the date query parameter, the day is taken in the local time of oms-core.
+const slotDateLayout = "2006-01-02"
+
+type DeliverySlotHandler struct {
+	service *service.DeliverySlotService
+}
+
+func NewDeliverySlotHandler(service *service.DeliverySlotService) *DeliverySlotHandler {
+	return &DeliverySlotHandler{service: service}
+}
+
+func (h *DeliverySlotHandler) CreateSlot(w http.ResponseWriter, r *http.Request) {
+	var slot models.DeliverySlot
+	if err := json.NewDecoder(r.Body).Decode(&slot); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.service.CreateSlot(r.Context(), &slot); err != nil {
+		log.Printf("[error] Failed to create delivery slot: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slot)
+	log.Printf("[info] Delivery slot created: %s", slot.ID)
+}
+
+// ListSlots handles GET /api/delivery/slots?zone=<zone>&date=<YYYY-MM-DD>.
+func (h *DeliverySlotHandler) ListSlots(w http.ResponseWriter, r *http.Request) {
+	zone := r.URL.Query().Get("zone")
+	if zone == "" {
+		log.Printf("[warn] Zone not provided")
+		http.Error(w, "zone is required", http.StatusBadRequest)
+		return
+	}
+
+	day, err := time.ParseInLocation(slotDateLayout, r.URL.Query().Get("date"), time.Local)
+	if err != nil {
+		log.Printf("[warn] Invalid slot date: %v", err)
+		http.Error(w, "date must be a date such as 2024-12-12", http.StatusBadRequest)
+		return
+	}
+
+	slots, err := h.service.ListSlots(r.Context(), zone, day)
+	if err != nil {
+		log.Printf("[error] Failed to list delivery slots: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(slots)
+	log.Printf("[info] Delivery slots of zone %s on %s listed: %d", zone, day.Format(slotDateLayout), len(slots))
+}
+
+func (h *DeliverySlotHandler) Reserve(w http.ResponseWriter, r *http.Request) {
+	slotID := r.PathValue("id")
+
+	reservation, err := h.service.Reserve(r.Context(), slotID)
+	if err != nil {
+		log.Printf("[error] Failed to reserve delivery slot: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reservation)
+	log.Printf("[info] Delivery slot %s reserved: %s", slotID, reservation.ID)
+}
+
+func (h *DeliverySlotHandler) GetReservation(w http.ResponseWriter, r *http.Request) {
+	reservationID := r.PathValue("id")
+
+	reservation, err := h.service.GetReservation(r.Context(), reservationID)
+	if err != nil {
+		log.Printf("[error] Failed to get slot reservation: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reservation)
+	log.Printf("[info] Slot reservation retrieved: %s", reservationID)
+}
+
+func (h *DeliverySlotHandler) ReleaseReservation(w http.ResponseWriter, r *http.Request) {
+	reservationID := r.PathValue("id")
+
+	reservation, err := h.service.Release(r.Context(), reservationID)
+	if err != nil {
+		log.Printf("[error] Failed to release slot reservation: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reservation)
+	log.Printf("[info] Slot reservation released: %s", reservationID)
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+	"github.com/milovidov983/oms-temporal-demo/oms-core/service"
+)
+
+// errorStatus maps service and repository errors to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrOrderNotFound),
+		errors.Is(err, repository.ErrAssemblyApplicationNotFound),
+		errors.Is(err, repository.ErrDeliveryApplicationNotFound),
+		errors.Is(err, repository.ErrDeliverySlotNotFound),
+		errors.Is(err, repository.ErrSlotReservationNotFound),
+		errors.Is(err, repository.ErrPaymentNotFound),
+		errors.Is(err, repository.ErrOrderItemNotFound),
+		errors.Is(err, repository.ErrAssemblyQueueEmpty),
+		errors.Is(err, repository.ErrSubstitutionNotFound),
+		errors.Is(err, repository.ErrDeadLetterNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrInvalidInput):
+		return http.StatusBadRequest
+	case errors.Is(err, service.ErrValidation):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, service.ErrPreconditionFailed):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, service.ErrPaymentDeclined):
+		return http.StatusPaymentRequired
+	case errors.Is(err, repository.ErrInvalidTransition),
+		errors.Is(err, repository.ErrVersionConflict),
+		errors.Is(err, repository.ErrAssemblyApplicationClosed),
+		errors.Is(err, repository.ErrAssemblyApplicationClaimed),
+		errors.Is(err, repository.ErrAssemblyApplicationAccepted),
+		errors.Is(err, repository.ErrDeliveryApplicationAssigned),
+		errors.Is(err, repository.ErrDeliverySlotExists),
+		errors.Is(err, repository.ErrDeliverySlotUnavailable),
+		errors.Is(err, repository.ErrSlotReservationClosed),
+		errors.Is(err, repository.ErrSubstitutionPending),
+		errors.Is(err, repository.ErrIdempotencyKeyReused),
+		errors.Is(err, service.ErrOrderNotAmendable),
+		errors.Is(err, service.ErrOrderNotCancelable),
+		errors.Is(err, service.ErrNoPackages),
+		errors.Is(err, service.ErrCaptureExceedsAuthorization),
+		errors.Is(err, service.ErrNoWarehouseAvailable):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// writeError writes err with the status from errorStatus. Validation errors are rendered
+// as JSON with the list of invalid fields, other errors as plain text.
+func writeError(w http.ResponseWriter, err error) {
+	var verr *service.ValidationError
+	if errors.As(err, &verr) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"error":  service.ErrValidation.Error(),
+			"fields": verr.Fields,
+		})
+		return
+	}
+
+	http.Error(w, err.Error(), errorStatus(err))
+}
@@ -2,13 +2,21 @@ package handler
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"net/http"
+	"net/url"
+	"strconv"
+	"time"
 
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
 	"github.com/milovidov983/oms-temporal-demo/oms-core/service"
 	"github.com/milovidov983/oms-temporal-demo/shared/models"
 )
 
+// IdempotencyKeyHeader lets clients retry order creation without creating duplicates.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
 type OrderHandler struct {
 	service *service.OrderService
 }
@@ -31,9 +39,9 @@ func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.CreateOrder(r.Context(), &order); err != nil {
+	if err := h.service.CreateOrder(r.Context(), &order, r.Header.Get(IdempotencyKeyHeader)); err != nil {
 		log.Printf("[error] Failed to create order: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -42,6 +50,127 @@ func (h *OrderHandler) CreateOrder(w http.ResponseWriter, r *http.Request) {
 	log.Printf("[info] Order created: %s", order.ID)
 }
 
+func (h *OrderHandler) GetOrder(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Printf("[warn] Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orderID := r.PathValue("id")
+	if orderID == "" {
+		log.Printf("[warn] Order ID not provided")
+		http.Error(w, "Order ID not provided", http.StatusBadRequest)
+		return
+	}
+
+	order, err := h.service.GetOrder(r.Context(), orderID)
+	if err != nil {
+		log.Printf("[error] Failed to get order: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	setETag(w, order.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+	log.Printf("[info] Order retrieved: %s", order.ID)
+}
+
+func (h *OrderHandler) ListOrders(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Printf("[warn] Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	filter, err := parseOrderFilter(r.URL.Query())
+	if err != nil {
+		log.Printf("[warn] Invalid order filter: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := h.service.ListOrders(r.Context(), filter)
+	if err != nil {
+		log.Printf("[error] Failed to list orders: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+	log.Printf("[info] Orders listed: %d", len(page.Orders))
+}
+
+func parseOrderFilter(query url.Values) (repository.OrderFilter, error) {
+	filter := repository.OrderFilter{
+		CustomerID:     query.Get("customer_id"),
+		Status:         models.OrderStatus(query.Get("status")),
+		AssemblyStatus: models.AssemblyStatus(query.Get("assembly_status")),
+		Cursor:         query.Get("cursor"),
+	}
+
+	for param, target := range map[string]**time.Time{
+		"created_from": &filter.CreatedFrom,
+		"created_to":   &filter.CreatedTo,
+	} {
+		value := query.Get(param)
+		if value == "" {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return filter, fmt.Errorf("%s must be an RFC 3339 timestamp", param)
+		}
+		*target = &t
+	}
+
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return filter, fmt.Errorf("limit must be a positive integer")
+		}
+		filter.Limit = limit
+	}
+
+	switch query.Get("sort") {
+	case "", "created_at":
+	case "-created_at":
+		filter.Descending = true
+	default:
+		return filter, fmt.Errorf("sort must be created_at or -created_at")
+	}
+
+	return filter, nil
+}
+
+func (h *OrderHandler) GetHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		log.Printf("[warn] Method not allowed: %s", r.Method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	orderID := r.PathValue("id")
+	if orderID == "" {
+		log.Printf("[warn] Order ID not provided")
+		http.Error(w, "Order ID not provided", http.StatusBadRequest)
+		return
+	}
+
+	history, err := h.service.GetOrderHistory(r.Context(), orderID)
+	if err != nil {
+		log.Printf("[error] Failed to get order history: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"order_id": orderID, "history": history})
+	log.Printf("[info] Order history retrieved: %s", orderID)
+}
+
 func (h *OrderHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		log.Printf("[warn] Method not allowed: %s", r.Method)
@@ -59,7 +188,7 @@ func (h *OrderHandler) GetStatus(w http.ResponseWriter, r *http.Request) {
 	status, err := h.service.GetOrderStatus(r.Context(), orderID)
 	if err != nil {
 		log.Printf("[error] Failed to get order status: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -82,9 +211,18 @@ func (h *OrderHandler) CancelOrder(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.service.CancelOrder(r.Context(), orderID); err != nil {
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	r = withReason(r, r.URL.Query().Get("reason"))
+
+	if err := h.service.CancelOrder(r.Context(), orderID, expectedVersion); err != nil {
 		log.Printf("[error] Failed to cancel order: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(w, err)
 		return
 	}
 
@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+func (h *OrderHandler) AddItem(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+
+	var item models.OrderItem
+	if err := json.NewDecoder(r.Body).Decode(&item); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	order, err := h.service.AddOrderItem(r.Context(), orderID, expectedVersion, item)
+	if err != nil {
+		log.Printf("[error] Failed to add order item: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	setETag(w, order.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+	log.Printf("[info] Item %s added to order %s", item.ProductID, orderID)
+}
+
+func (h *OrderHandler) ChangeItem(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+	productID := r.PathValue("product_id")
+
+	var request struct {
+		Quantity int `json:"quantity"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	order, err := h.service.ChangeOrderItemQuantity(r.Context(), orderID, expectedVersion, productID, request.Quantity)
+	if err != nil {
+		log.Printf("[error] Failed to change order item: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	setETag(w, order.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+	log.Printf("[info] Item %s of order %s changed to quantity %d", productID, orderID, request.Quantity)
+}
+
+func (h *OrderHandler) RemoveItem(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+	productID := r.PathValue("product_id")
+
+	expectedVersion, err := ifMatchVersion(r)
+	if err != nil {
+		log.Printf("[warn] %v", err)
+		writeError(w, err)
+		return
+	}
+
+	order, err := h.service.RemoveOrderItem(r.Context(), orderID, expectedVersion, productID)
+	if err != nil {
+		log.Printf("[error] Failed to remove order item: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	setETag(w, order.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(order)
+	log.Printf("[info] Item %s removed from order %s", productID, orderID)
+}
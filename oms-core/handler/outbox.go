@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/service"
+)
+
+type OutboxHandler struct {
+	relay *service.OutboxRelay
+}
+
+func NewOutboxHandler(relay *service.OutboxRelay) *OutboxHandler {
+	return &OutboxHandler{relay: relay}
+}
+
+// Requeue handles POST /api/outbox/{id}/requeue: the dead message is published again.
+func (h *OutboxHandler) Requeue(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseInt(r.PathValue("id"), 10, 64)
+	if err != nil {
+		log.Printf("[warn] Invalid outbox message ID: %v", err)
+		http.Error(w, "id must be an integer", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.relay.Requeue(r.Context(), id); err != nil {
+		log.Printf("[error] Failed to requeue outbox message: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	log.Printf("[info] Outbox message requeued: %d", id)
+}
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/service"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+type PaymentHandler struct {
+	service *service.PaymentService
+}
+
+func NewPaymentHandler(service *service.PaymentService) *PaymentHandler {
+	return &PaymentHandler{service: service}
+}
+
+// Authorize handles POST /api/payments. A declined authorization is returned with 200 and the DECLINED status.
+func (h *PaymentHandler) Authorize(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		OrderID string `json:"order_id"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := h.service.Authorize(r.Context(), request.OrderID)
+	if err != nil {
+		log.Printf("[error] Failed to authorize payment: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writePayment(w, payment)
+	log.Printf("[info] Payment %s of order %s: %s", payment.ID, request.OrderID, payment.Status)
+}
+
+func (h *PaymentHandler) GetPayment(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("id")
+
+	payment, err := h.service.Get(r.Context(), paymentID)
+	if err != nil {
+		log.Printf("[error] Failed to get payment: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writePayment(w, payment)
+	log.Printf("[info] Payment retrieved: %s", paymentID)
+}
+
+func (h *PaymentHandler) ListByOrder(w http.ResponseWriter, r *http.Request) {
+	orderID := r.PathValue("id")
+
+	payments, err := h.service.ListByOrder(r.Context(), orderID)
+	if err != nil {
+		log.Printf("[error] Failed to list payments: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payments)
+	log.Printf("[info] Payments of order %s listed: %d", orderID, len(payments))
+}
+
+// Reauthorize handles POST /api/payments/{id}/reauthorize: the authorization is raised to the total of the order.
+// A refusal of the gateway returns 402 and leaves the payment as it was.
+func (h *PaymentHandler) Reauthorize(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("id")
+
+	payment, err := h.service.Reauthorize(r.Context(), paymentID)
+	if err != nil {
+		log.Printf("[error] Failed to reauthorize payment: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writePayment(w, payment)
+	log.Printf("[info] Payment %s authorized for %s", paymentID, payment.Amount)
+}
+
+func (h *PaymentHandler) Capture(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("id")
+
+	var request struct {
+		Amount models.Money `json:"amount"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := h.service.Capture(r.Context(), paymentID, request.Amount)
+	if err != nil {
+		log.Printf("[error] Failed to capture payment: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writePayment(w, payment)
+	log.Printf("[info] Payment %s captured for %s", paymentID, request.Amount)
+}
+
+// Cancel handles POST /api/payments/{id}/cancel: the authorization is voided or the captured amount refunded.
+func (h *PaymentHandler) Cancel(w http.ResponseWriter, r *http.Request) {
+	paymentID := r.PathValue("id")
+
+	var request struct {
+		Reason string `json:"reason"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		log.Printf("[error] Failed to decode request body: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	payment, err := h.service.Cancel(r.Context(), paymentID, request.Reason)
+	if err != nil {
+		log.Printf("[error] Failed to cancel payment: %v", err)
+		writeError(w, err)
+		return
+	}
+
+	writePayment(w, payment)
+	log.Printf("[info] Payment %s canceled as %s, reason: %s", paymentID, payment.Status, request.Reason)
+}
+
+func writePayment(w http.ResponseWriter, payment *models.Payment) {
+	setETag(w, payment.Version)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(payment)
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+)
+
+// IfMatchHeader carries the version from the ETag the client has seen.
+// The change is applied only if the order or application still has that version.
+const IfMatchHeader = "If-Match"
+
+// ifMatchVersion parses the If-Match header. A missing header or "*" means any version and returns 0.
+func ifMatchVersion(r *http.Request) (int, error) {
+	value := strings.TrimSpace(r.Header.Get(IfMatchHeader))
+	if value == "" || value == "*" {
+		return 0, nil
+	}
+
+	tag := strings.Trim(strings.TrimPrefix(value, "W/"), `"`)
+	version, err := strconv.Atoi(tag)
+	if err != nil || version <= 0 {
+		return 0, fmt.Errorf("%w: invalid %s header %q", repository.ErrInvalidInput, IfMatchHeader, value)
+	}
+
+	return version, nil
+}
+
+// setETag exposes the current version so the client can send it back in If-Match.
+func setETag(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
@@ -0,0 +1,83 @@
+// Package label renders shipping labels for the packages of an assembled order.
+package label
+
+import (
+	"fmt"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+type Format string
+
+const (
+	// FormatPDF is a 4x6 inch page per package
+	FormatPDF Format = "pdf"
+	// FormatZPL is ZPL II text for thermal printers at 203 dpi, a label per package
+	FormatZPL Format = "zpl"
+)
+
+// ContentType is the MIME type of the rendered document.
+func (f Format) ContentType() string {
+	if f == FormatZPL {
+		return "text/plain; charset=utf-8"
+	}
+	return "application/pdf"
+}
+
+func (f Format) IsValid() bool {
+	return f == FormatPDF || f == FormatZPL
+}
+
+// Label is what is printed on a package: where it comes from, its place among the packages
+// of the order, its size and the barcode.
+type Label struct {
+	OrderID               string
+	AssemblyApplicationID string
+	WarehouseID           string
+	Package               models.Package
+	// Count is the number of packages of the order
+	Count int
+}
+
+// Labels returns a label per package of the application.
+func Labels(application *models.AssemblyApplication) []Label {
+	labels := make([]Label, len(application.Packages))
+	for i, p := range application.Packages {
+		labels[i] = Label{
+			OrderID:               application.OrderID,
+			AssemblyApplicationID: application.ID,
+			WarehouseID:           application.WarehouseID,
+			Package:               p,
+			Count:                 len(application.Packages),
+		}
+	}
+	return labels
+}
+
+// Render renders the labels into one document of the format.
+func Render(format Format, labels []Label) ([]byte, error) {
+	switch format {
+	case FormatPDF:
+		return renderPDF(labels)
+	case FormatZPL:
+		return renderZPL(labels), nil
+	default:
+		return nil, fmt.Errorf("unsupported label format %q", format)
+	}
+}
+
+// lines is the text of the label, the same for every format.
+func (l Label) lines() []string {
+	p := l.Package
+	return []string{
+		fmt.Sprintf("Order %s", l.OrderID),
+		fmt.Sprintf("Assembly %s", l.AssemblyApplicationID),
+		fmt.Sprintf("Warehouse %s", l.WarehouseID),
+		fmt.Sprintf("Weight %d.%03d kg", p.WeightGrams/1000, p.WeightGrams%1000),
+		fmt.Sprintf("Size %d x %d x %d mm", p.LengthMM, p.WidthMM, p.HeightMM),
+	}
+}
+
+func (l Label) packageOf() string {
+	return fmt.Sprintf("Package %d of %d", l.Package.Number, l.Count)
+}
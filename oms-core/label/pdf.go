@@ -0,0 +1,123 @@
+package label
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Page size and layout of the PDF label in points, 4x6 inch.
+const (
+	pdfPageWidth  = 288
+	pdfPageHeight = 432
+	pdfMargin     = 18
+
+	pdfBarcodeHeight = 72
+	// pdfQuietZone is the blank space required on both sides of the barcode, in modules
+	pdfQuietZone = 10
+)
+
+// renderPDF writes a PDF document with a page per label. Text uses the standard Helvetica font,
+// the barcode is drawn as Code 128 bars.
+func renderPDF(labels []Label) ([]byte, error) {
+	if len(labels) == 0 {
+		return nil, fmt.Errorf("no labels to render")
+	}
+
+	// Объекты: 1 - каталог, 2 - дерево страниц, 3 - шрифт, далее по паре страница + содержимое
+	var objects []string
+	kids := make([]string, len(labels))
+	for i, l := range labels {
+		content, err := pdfContent(l)
+		if err != nil {
+			return nil, fmt.Errorf("package %d: %w", l.Package.Number, err)
+		}
+
+		pageID := 4 + 2*i
+		kids[i] = fmt.Sprintf("%d 0 R", pageID)
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, pageID+1),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", len(content), content),
+		)
+	}
+	objects = append([]string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(labels)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica /Encoding /WinAnsiEncoding >>",
+	}, objects...)
+
+	var b bytes.Buffer
+	b.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = b.Len()
+		fmt.Fprintf(&b, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&b, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&b, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return b.Bytes(), nil
+}
+
+// pdfContent is the content stream of a label page, y grows from the bottom of the page.
+func pdfContent(l Label) (string, error) {
+	widths, err := code128B(l.Package.Barcode)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	y := pdfPageHeight - pdfMargin - 12
+	for _, line := range l.lines() {
+		fmt.Fprintf(&b, "BT /F1 11 Tf %d %d Td (%s) Tj ET\n", pdfMargin, y, pdfEscape(line))
+		y -= 18
+	}
+	y -= 18
+	fmt.Fprintf(&b, "BT /F1 22 Tf %d %d Td (%s) Tj ET\n", pdfMargin, y, pdfEscape(l.packageOf()))
+
+	// Ширина модуля подбирается так, чтобы штрихкод с тихими зонами поместился по ширине страницы
+	modules := 2 * pdfQuietZone
+	for _, w := range widths {
+		modules += w
+	}
+	module := float64(pdfPageWidth-2*pdfMargin) / float64(modules)
+	if module > 1.5 {
+		module = 1.5
+	}
+
+	barcodeY := y - 36 - pdfBarcodeHeight
+	x := float64(pdfMargin) + pdfQuietZone*module
+	for i, w := range widths {
+		if i%2 == 0 {
+			fmt.Fprintf(&b, "%.2f %d %.2f %d re\n", x, barcodeY, float64(w)*module, pdfBarcodeHeight)
+		}
+		x += float64(w) * module
+	}
+	b.WriteString("f\n")
+	fmt.Fprintf(&b, "BT /F1 11 Tf %d %d Td (%s) Tj ET", pdfMargin, barcodeY-16, pdfEscape(l.Package.Barcode))
+
+	return b.String(), nil
+}
+
+// pdfEscape escapes a PDF string literal, characters outside of ASCII are replaced with '?'.
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteByte('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteByte('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,58 @@
+package label
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// renderZPL writes a 4x6 inch label per package with a Code 128 barcode printed by the printer.
+func renderZPL(labels []Label) []byte {
+	var b bytes.Buffer
+	for _, l := range labels {
+		b.WriteString("^XA\n^CI28\n^PW812\n^LL1218\n")
+
+		y := 50
+		for _, line := range l.lines() {
+			fmt.Fprintf(&b, "^FO50,%d^A0N,30,30^FH\\^FD%s^FS\n", y, zplEscape(line))
+			y += 50
+		}
+		fmt.Fprintf(&b, "^FO50,%d^A0N,60,60^FH\\^FD%s^FS\n", y+20, zplEscape(l.packageOf()))
+
+		fmt.Fprintf(&b, "^FO50,%d^BY%d^BCN,200,Y,N,N^FH\\^FD%s^FS\n", y+140, zplModuleWidth(l.Package.Barcode), zplEscape(l.Package.Barcode))
+		b.WriteString("^XZ\n")
+	}
+	return b.Bytes()
+}
+
+// zplModuleWidth is the widest bar module in dots, up to 3, that keeps the barcode within the label.
+func zplModuleWidth(barcode string) int {
+	widths, err := code128B(barcode)
+	if err != nil {
+		return 2
+	}
+	modules := 0
+	for _, w := range widths {
+		modules += w
+	}
+	// 812 точек ширины этикетки минус поля по 50 точек
+	for width := 3; width > 1; width-- {
+		if modules*width <= 712 {
+			return width
+		}
+	}
+	return 1
+}
+
+// zplEscape hex-encodes the characters that ZPL treats as commands inside ^FH fields.
+func zplEscape(s string) string {
+	var b bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '^', '~', '\\':
+			fmt.Fprintf(&b, "\\%02X", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
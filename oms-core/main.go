@@ -1,15 +1,19 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/IBM/sarama"
 	"github.com/milovidov983/oms-temporal-demo/oms-core/handler"
 	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
 	"github.com/milovidov983/oms-temporal-demo/oms-core/service"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
 	"github.com/spf13/viper"
 )
 
@@ -20,7 +24,7 @@ func main() {
 	dbConnectionString := viper.GetString("database.connectionString")
 	db, err := sql.Open("postgres", dbConnectionString)
 	if err != nil {
-		log.Fatalf("failed to connect to database: %w", err)
+		log.Fatalf("failed to connect to database: %v", err)
 	}
 	config := sarama.NewConfig()
 	config.Producer.Return.Successes = true
@@ -32,6 +36,26 @@ func main() {
 	}
 	defer producer.Close()
 
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Outbox
+	outboxRepo, err := repository.NewOutboxRepository(db)
+	if err != nil {
+		log.Fatalf("[fatal] Error creating outbox repository: %v", err)
+	}
+	outboxRelay := service.NewOutboxRelay(outboxRepo, producer, service.OutboxRelayConfig{
+		PollInterval:  viper.GetDuration("outbox.pollInterval"),
+		BatchSize:     viper.GetInt("outbox.batchSize"),
+		RetryDelay:    viper.GetDuration("outbox.retryDelay"),
+		MaxRetryDelay: viper.GetDuration("outbox.maxRetryDelay"),
+		ClaimTimeout:  viper.GetDuration("outbox.claimTimeout"),
+	})
+	go outboxRelay.Run(ctx)
+
+	outboxHandler := handler.NewOutboxHandler(outboxRelay)
+	http.HandleFunc("POST /api/outbox/{id}/requeue", outboxHandler.Requeue)
+
 	// Order
 	orderRepo, err := repository.NewOrderRepository(db)
 	if err != nil {
@@ -39,14 +63,31 @@ func main() {
 	}
 	log.Printf("[info] Order repository created")
 	orderTopic := viper.GetString("kafka.topics.order")
-	orderService := service.NewOrderService(orderRepo, producer, orderTopic)
+	orderService := service.NewOrderService(orderRepo, service.OrderServiceConfig{
+		Topic:           orderTopic,
+		DefaultCurrency: viper.GetString("orders.defaultCurrency"),
+		IdempotencyTTL:  viper.GetDuration("orders.idempotency.ttl"),
+	})
 
 	log.Printf("[info] Order service created with topic: %s", orderTopic)
 
+	idempotencyRepo, err := repository.NewIdempotencyRepository(db)
+	if err != nil {
+		log.Fatalf("[fatal] Error creating idempotency repository: %v", err)
+	}
+	idempotencyCleaner := service.NewIdempotencyKeyCleaner(idempotencyRepo, viper.GetDuration("orders.idempotency.cleanupInterval"))
+	go idempotencyCleaner.Run(ctx)
+
 	orderHandler := handler.NewOrderHandler(orderService)
-	http.HandleFunc("/api/orders", orderHandler.CreateOrder)
-	http.HandleFunc("/api/orders/status", orderHandler.GetStatus)
-	http.HandleFunc("/api/orders/cancel", orderHandler.CancelOrder)
+	http.HandleFunc("POST /api/orders", orderHandler.CreateOrder)
+	http.HandleFunc("GET /api/orders", orderHandler.ListOrders)
+	http.HandleFunc("GET /api/orders/{id}", orderHandler.GetOrder)
+	http.HandleFunc("GET /api/orders/{id}/history", orderHandler.GetHistory)
+	http.HandleFunc("POST /api/orders/{id}/items", orderHandler.AddItem)
+	http.HandleFunc("PATCH /api/orders/{id}/items/{product_id}", orderHandler.ChangeItem)
+	http.HandleFunc("DELETE /api/orders/{id}/items/{product_id}", orderHandler.RemoveItem)
+	http.HandleFunc("GET /api/orders/status", orderHandler.GetStatus)
+	http.HandleFunc("POST /api/orders/cancel", orderHandler.CancelOrder)
 
 	// Assembly
 	assRepo, err := repository.NewAssemblyApplicationRepository(db)
@@ -55,17 +96,125 @@ func main() {
 	}
 	log.Printf("[info] Assembly appliation repository created")
 
+	warehouseRepo, err := repository.NewWarehouseRepository(db)
+	if err != nil {
+		log.Fatalf("[fatal] Error creating warehouse repository: %v", err)
+	}
+
+	var routingRules []service.RoutingRule
+	for _, rule := range viper.GetStringSlice("assembly.routing.rules") {
+		routingRules = append(routingRules, service.RoutingRule(rule))
+	}
+	assemblyRouter := service.NewAssemblyRouter(warehouseRepo, service.AssemblyRoutingConfig{Rules: routingRules})
+
+	assemblySLA := service.NewAssemblySLA(warehouseRepo, service.AssemblySLAConfig{
+		Default: viper.GetDuration("assembly.sla.default"),
+	})
+
 	assemblyApplicationTopic := viper.GetString("kafka.topics.assemblyApplication")
-	assemblyApplicationService := service.NewAssemblyApplicationService(assRepo, producer, assemblyApplicationTopic)
+	assemblyApplicationService := service.NewAssemblyApplicationService(assRepo, assemblyRouter, assemblySLA, assemblyApplicationTopic)
 	assemblyHandler := handler.NewAssemblyApplicationHandler(assemblyApplicationService)
 	http.HandleFunc("/api/assembly", assemblyHandler.CreateApplication)
 	http.HandleFunc("/api/assembly/complete", assemblyHandler.CompleteApplication)
-	http.HandleFunc("/api/assembly/cancel", assemblyHandler.CompleteApplication)
+	http.HandleFunc("POST /api/assembly/{id}/comment", assemblyHandler.ChangeComment)
+	http.HandleFunc("GET /api/assembly/{id}/label", assemblyHandler.GetLabel)
+
+	// SLA
+	http.HandleFunc("POST /api/assembly/{id}/overdue", assemblyHandler.MarkOverdue)
+	http.HandleFunc("GET /api/assembly/overdue", assemblyHandler.ListOverdue)
+
+	// Re-assembly
+	http.HandleFunc("POST /api/assembly/{id}/reject", assemblyHandler.RejectApplication)
+	http.HandleFunc("POST /api/assembly/{id}/accept", assemblyHandler.AcceptApplication)
+	http.HandleFunc("GET /api/orders/{id}/assembly-attempts", assemblyHandler.ListAttempts)
+
+	// Substitutions
+	http.HandleFunc("POST /api/assembly/{id}/substitutions", assemblyHandler.ProposeSubstitution)
+	http.HandleFunc("POST /api/assembly/{id}/substitutions/{substitution_id}/approve", assemblyHandler.ApproveSubstitution)
+	http.HandleFunc("POST /api/assembly/{id}/substitutions/{substitution_id}/reject", assemblyHandler.RejectSubstitution)
+
+	// Picker queue
+	http.HandleFunc("GET /api/assembly/queue", assemblyHandler.ListQueue)
+	http.HandleFunc("POST /api/assembly/queue/claim", assemblyHandler.ClaimNext)
+	http.HandleFunc("POST /api/assembly/{id}/claim", assemblyHandler.Claim)
+	http.HandleFunc("POST /api/assembly/{id}/start", assemblyHandler.StartApplication)
+	http.HandleFunc("POST /api/assembly/{id}/release", assemblyHandler.Release)
+	http.HandleFunc("GET /api/assembly/pickers/{picker_id}/applications", assemblyHandler.ListPickerApplications)
+	http.HandleFunc("/api/assembly/cancel", assemblyHandler.CancelApplication)
+
+	// Delivery
+	deliveryRepo, err := repository.NewDeliveryApplicationRepository(db)
+	if err != nil {
+		log.Fatalf("[fatal] Error creating delivery repository: %v", err)
+	}
+	log.Printf("[info] Delivery application repository created")
+
+	deliveryApplicationTopic := viper.GetString("kafka.topics.deliveryApplication")
+	deliveryApplicationService := service.NewDeliveryApplicationService(deliveryRepo, deliveryApplicationTopic)
+	deliveryHandler := handler.NewDeliveryApplicationHandler(deliveryApplicationService)
+	http.HandleFunc("POST /api/delivery", deliveryHandler.CreateApplication)
+	http.HandleFunc("GET /api/delivery/{id}", deliveryHandler.GetApplication)
+	http.HandleFunc("GET /api/orders/{id}/deliveries", deliveryHandler.ListByOrder)
+	http.HandleFunc("GET /api/delivery/couriers/{courier_id}/applications", deliveryHandler.ListCourierApplications)
+	http.HandleFunc("POST /api/delivery/{id}/assign", deliveryHandler.Assign)
+	http.HandleFunc("POST /api/delivery/{id}/release", deliveryHandler.Release)
+	http.HandleFunc("POST /api/delivery/{id}/pickup", deliveryHandler.PickUp)
+	http.HandleFunc("POST /api/delivery/{id}/deliver", deliveryHandler.Deliver)
+	http.HandleFunc("POST /api/delivery/{id}/fail", deliveryHandler.Fail)
+	http.HandleFunc("POST /api/delivery/{id}/cancel", deliveryHandler.CancelApplication)
+
+	// Delivery slots
+	slotRepo, err := repository.NewDeliverySlotRepository(db)
+	if err != nil {
+		log.Fatalf("[fatal] Error creating delivery slot repository: %v", err)
+	}
+	slotService := service.NewDeliverySlotService(slotRepo, service.DeliverySlotConfig{
+		ReservationTTL: viper.GetDuration("delivery.slots.reservationTTL"),
+	})
+	slotExpirer := service.NewSlotReservationExpirer(slotRepo, viper.GetDuration("delivery.slots.expireInterval"))
+	go slotExpirer.Run(ctx)
+
+	slotHandler := handler.NewDeliverySlotHandler(slotService)
+	http.HandleFunc("POST /api/delivery/slots", slotHandler.CreateSlot)
+	http.HandleFunc("GET /api/delivery/slots", slotHandler.ListSlots)
+	http.HandleFunc("POST /api/delivery/slots/{id}/reserve", slotHandler.Reserve)
+	http.HandleFunc("GET /api/delivery/slots/reservations/{id}", slotHandler.GetReservation)
+	http.HandleFunc("POST /api/delivery/slots/reservations/{id}/release", slotHandler.ReleaseReservation)
+
+	// Payments
+	paymentRepo, err := repository.NewPaymentRepository(db)
+	if err != nil {
+		log.Fatalf("[fatal] Error creating payment repository: %v", err)
+	}
+	paymentService := service.NewPaymentService(paymentRepo, newPaymentGateway())
+	paymentHandler := handler.NewPaymentHandler(paymentService)
+	http.HandleFunc("POST /api/payments", paymentHandler.Authorize)
+	http.HandleFunc("GET /api/payments/{id}", paymentHandler.GetPayment)
+	http.HandleFunc("GET /api/orders/{id}/payments", paymentHandler.ListByOrder)
+	http.HandleFunc("POST /api/payments/{id}/reauthorize", paymentHandler.Reauthorize)
+	http.HandleFunc("POST /api/payments/{id}/capture", paymentHandler.Capture)
+	http.HandleFunc("POST /api/payments/{id}/cancel", paymentHandler.Cancel)
 
 	port := viper.GetString("server.address")
 	log.Printf("[info] Starting server on port %s", port)
 
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	log.Fatal(http.ListenAndServe(":"+port, handler.WithActor(http.DefaultServeMux)))
+}
+
+// newPaymentGateway creates the payment gateway selected by payments.gateway.
+func newPaymentGateway() service.PaymentGateway {
+	switch gateway := viper.GetString("payments.gateway"); gateway {
+	case "fake":
+		declineAbove, err := models.ParseMoney(viper.GetString("payments.fake.declineAbove"))
+		if err != nil {
+			log.Fatalf("[fatal] Invalid payments.fake.declineAbove: %v", err)
+		}
+		log.Printf("[info] Fake payment gateway is used, amounts above %s are declined", declineAbove)
+		return service.NewFakePaymentGateway(service.FakePaymentGatewayConfig{DeclineAbove: declineAbove})
+	default:
+		log.Fatalf("[fatal] Unknown payment gateway: %q", gateway)
+		return nil
+	}
 }
 
 func loadConfig() {
@@ -0,0 +1,30 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+ALTER TABLE orders
+    ADD COLUMN updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL;
+
+-- Колонку пишет updateAssemblyStatus, но в исходной миграции ее не было
+ALTER TABLE assembly_applications
+    ADD COLUMN completed_at TIMESTAMP WITH TIME ZONE;
+
+CREATE OR REPLACE FUNCTION set_updated_at() RETURNS TRIGGER AS $$
+BEGIN
+    NEW.updated_at = CURRENT_TIMESTAMP;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER orders_set_updated_at
+    BEFORE UPDATE ON orders
+    FOR EACH ROW EXECUTE FUNCTION set_updated_at();
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TRIGGER IF EXISTS orders_set_updated_at ON orders;
+DROP FUNCTION IF EXISTS set_updated_at();
+ALTER TABLE assembly_applications DROP COLUMN IF EXISTS completed_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS updated_at;
+-- +goose StatementEnd
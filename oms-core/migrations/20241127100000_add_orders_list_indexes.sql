@@ -0,0 +1,20 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Индексы под keyset-пагинацию списка заказов: сортировка по (created_at, id)
+CREATE INDEX idx_orders_created_at_id ON orders(created_at, id);
+CREATE INDEX idx_orders_customer_id_created_at_id ON orders(customer_id, created_at, id);
+CREATE INDEX idx_orders_status_created_at_id ON orders(status, created_at, id);
+CREATE INDEX idx_orders_assembly_application_id ON orders(assembly_application_id);
+CREATE INDEX idx_assembly_applications_status ON assembly_applications(status);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_assembly_applications_status;
+DROP INDEX IF EXISTS idx_orders_assembly_application_id;
+DROP INDEX IF EXISTS idx_orders_status_created_at_id;
+DROP INDEX IF EXISTS idx_orders_customer_id_created_at_id;
+DROP INDEX IF EXISTS idx_orders_created_at_id;
+-- +goose StatementEnd
@@ -0,0 +1,23 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+CREATE TABLE order_status_history (
+    id BIGSERIAL PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    entity VARCHAR(64) NOT NULL,
+    entity_id VARCHAR(64) NOT NULL,
+    old_status VARCHAR(64),
+    new_status VARCHAR(64) NOT NULL,
+    actor VARCHAR(255) NOT NULL,
+    reason TEXT,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_order_status_history_order_id ON order_status_history(order_id, id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS order_status_history;
+-- +goose StatementEnd
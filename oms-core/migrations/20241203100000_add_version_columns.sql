@@ -0,0 +1,17 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Версия растет на каждом изменении строки и используется для оптимистичной блокировки
+ALTER TABLE orders
+    ADD COLUMN version INT NOT NULL DEFAULT 1;
+
+ALTER TABLE assembly_applications
+    ADD COLUMN version INT NOT NULL DEFAULT 1;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE assembly_applications DROP COLUMN IF EXISTS version;
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
+-- +goose StatementEnd
@@ -0,0 +1,26 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Позиции заявки на сборку: сколько заказано и сколько фактически собрано.
+-- collected_quantity заполняется при завершении сборки
+CREATE TABLE assembly_items (
+    id SERIAL PRIMARY KEY,
+    assembly_application_id VARCHAR(64) NOT NULL REFERENCES assembly_applications(id) ON DELETE CASCADE,
+    product_id VARCHAR(64) NOT NULL,
+    requested_quantity INT NOT NULL,
+    collected_quantity INT,
+    UNIQUE (assembly_application_id, product_id)
+);
+
+-- Переносим позиции существующих заявок из заказов
+INSERT INTO assembly_items (assembly_application_id, product_id, requested_quantity, collected_quantity)
+SELECT a.id, oi.product_id, oi.quantity, CASE WHEN a.status = 'COMPLETE' THEN oi.quantity END
+FROM assembly_applications a
+JOIN order_items oi ON oi.order_id = a.order_id;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS assembly_items;
+-- +goose StatementEnd
@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Сборщик, взявший заявку из очереди, и время, когда он ее взял
+ALTER TABLE assembly_applications
+    ADD COLUMN picker_id VARCHAR(64),
+    ADD COLUMN started_at TIMESTAMP WITH TIME ZONE;
+
+-- Очередь: свободные заявки в статусе CREATED от старых к новым
+CREATE INDEX idx_assembly_applications_queue ON assembly_applications(created_at, id)
+    WHERE status = 'CREATED' AND picker_id IS NULL;
+
+CREATE INDEX idx_assembly_applications_picker_id ON assembly_applications(picker_id)
+    WHERE picker_id IS NOT NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_assembly_applications_picker_id;
+DROP INDEX IF EXISTS idx_assembly_applications_queue;
+ALTER TABLE assembly_applications
+    DROP COLUMN IF EXISTS started_at,
+    DROP COLUMN IF EXISTS picker_id;
+-- +goose StatementEnd
@@ -0,0 +1,53 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Склады (магазины), на которых собираются заказы.
+-- priority: чем меньше, тем предпочтительнее склад при прочих равных
+CREATE TABLE warehouses (
+    id VARCHAR(64) PRIMARY KEY,
+    name VARCHAR(255) NOT NULL,
+    region VARCHAR(64),
+    priority INT NOT NULL DEFAULT 100,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL
+);
+
+-- Остатки товаров на складах, используются правилом маршрутизации stock
+CREATE TABLE warehouse_stock (
+    warehouse_id VARCHAR(64) NOT NULL REFERENCES warehouses(id) ON DELETE CASCADE,
+    product_id VARCHAR(64) NOT NULL,
+    quantity INT NOT NULL DEFAULT 0,
+    PRIMARY KEY (warehouse_id, product_id)
+);
+
+-- Склад, на котором до сих пор собирались все заказы
+INSERT INTO warehouses (id, name, priority) VALUES ('main', 'Main warehouse', 0);
+
+ALTER TABLE orders
+    ADD COLUMN region VARCHAR(64);
+
+ALTER TABLE assembly_applications
+    ADD COLUMN warehouse_id VARCHAR(64) REFERENCES warehouses(id);
+
+UPDATE assembly_applications SET warehouse_id = 'main';
+
+ALTER TABLE assembly_applications
+    ALTER COLUMN warehouse_id SET NOT NULL;
+
+-- Очередь сборщиков теперь выбирается по складу
+DROP INDEX IF EXISTS idx_assembly_applications_queue;
+CREATE INDEX idx_assembly_applications_queue ON assembly_applications(warehouse_id, created_at, id)
+    WHERE status = 'CREATED' AND picker_id IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_assembly_applications_queue;
+CREATE INDEX idx_assembly_applications_queue ON assembly_applications(created_at, id)
+    WHERE status = 'CREATED' AND picker_id IS NULL;
+ALTER TABLE assembly_applications DROP COLUMN IF EXISTS warehouse_id;
+ALTER TABLE orders DROP COLUMN IF EXISTS region;
+DROP TABLE IF EXISTS warehouse_stock;
+DROP TABLE IF EXISTS warehouses;
+-- +goose StatementEnd
@@ -0,0 +1,29 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Замены товаров, предложенные сборщиком. quantity единиц product_id заменяются
+-- тем же количеством substitute_product_id по цене price, если покупатель согласен
+CREATE TABLE assembly_substitutions (
+    id VARCHAR(64) PRIMARY KEY,
+    assembly_application_id VARCHAR(64) NOT NULL REFERENCES assembly_applications(id) ON DELETE CASCADE,
+    product_id VARCHAR(64) NOT NULL,
+    substitute_product_id VARCHAR(64) NOT NULL,
+    quantity INT NOT NULL,
+    price NUMERIC(12, 2) NOT NULL,
+    status VARCHAR(64) NOT NULL,
+    proposed_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    decided_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX idx_assembly_substitutions_application_id ON assembly_substitutions(assembly_application_id);
+
+-- По позиции может ждать решения покупателя только одно предложение
+CREATE UNIQUE INDEX idx_assembly_substitutions_proposed ON assembly_substitutions(assembly_application_id, product_id)
+    WHERE status = 'PROPOSED';
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS assembly_substitutions;
+-- +goose StatementEnd
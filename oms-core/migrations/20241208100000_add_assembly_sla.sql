@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- SLA сборки по складу и типу заказа (ASSEMBLY - самовывоз, DELIVERY - доставка).
+-- Для складов без записи используется assembly.sla.default из конфигурации
+CREATE TABLE assembly_slas (
+    warehouse_id VARCHAR(64) NOT NULL REFERENCES warehouses(id) ON DELETE CASCADE,
+    order_type VARCHAR(64) NOT NULL,
+    duration_minutes INT NOT NULL CHECK (duration_minutes > 0),
+    PRIMARY KEY (warehouse_id, order_type)
+);
+
+-- due_at - срок сборки по SLA, overdue_at - когда сборка была признана просроченной
+ALTER TABLE assembly_applications
+    ADD COLUMN order_type VARCHAR(64),
+    ADD COLUMN due_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN overdue_at TIMESTAMP WITH TIME ZONE;
+
+ALTER TABLE orders ADD COLUMN assembly_overdue_at TIMESTAMP WITH TIME ZONE;
+
+-- Отчет о просроченных сборках: незакрытые заявки по сроку
+CREATE INDEX idx_assembly_applications_due_at ON assembly_applications(due_at)
+    WHERE status IN ('CREATED', 'SENT');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_assembly_applications_due_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS assembly_overdue_at;
+ALTER TABLE assembly_applications
+    DROP COLUMN IF EXISTS overdue_at,
+    DROP COLUMN IF EXISTS due_at,
+    DROP COLUMN IF EXISTS order_type;
+DROP TABLE IF EXISTS assembly_slas;
+-- +goose StatementEnd
@@ -0,0 +1,33 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Попытки сборки заказа: заявка, не прошедшая проверку качества, отклоняется (REJECTED)
+-- и заказ собирается заново по новой заявке, связанной с предыдущей
+ALTER TABLE assembly_applications
+    ADD COLUMN attempt INT NOT NULL DEFAULT 1,
+    ADD COLUMN previous_application_id VARCHAR(64) REFERENCES assembly_applications(id),
+    ADD COLUMN rejected_at TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN rejection_reason VARCHAR(255);
+
+-- Нумеруем существующие заявки заказа по времени создания
+UPDATE assembly_applications a
+SET attempt = numbered.attempt
+FROM (
+    SELECT id, ROW_NUMBER() OVER (PARTITION BY order_id ORDER BY created_at, id) AS attempt
+    FROM assembly_applications
+) numbered
+WHERE a.id = numbered.id;
+
+CREATE UNIQUE INDEX idx_assembly_applications_order_attempt ON assembly_applications(order_id, attempt);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_assembly_applications_order_attempt;
+ALTER TABLE assembly_applications
+    DROP COLUMN IF EXISTS rejection_reason,
+    DROP COLUMN IF EXISTS rejected_at,
+    DROP COLUMN IF EXISTS previous_application_id,
+    DROP COLUMN IF EXISTS attempt;
+-- +goose StatementEnd
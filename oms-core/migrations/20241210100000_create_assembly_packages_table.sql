@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Посылки, в которые упакован собранный заказ. Вес в граммах, габариты в миллиметрах
+CREATE TABLE assembly_packages (
+    assembly_application_id VARCHAR(64) NOT NULL REFERENCES assembly_applications(id) ON DELETE CASCADE,
+    number INT NOT NULL CHECK (number > 0),
+    barcode VARCHAR(64) NOT NULL,
+    weight_grams INT NOT NULL CHECK (weight_grams > 0),
+    length_mm INT NOT NULL CHECK (length_mm > 0),
+    width_mm INT NOT NULL CHECK (width_mm > 0),
+    height_mm INT NOT NULL CHECK (height_mm > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    PRIMARY KEY (assembly_application_id, number)
+);
+
+-- Штрихкод однозначно определяет посылку при передаче в доставку
+CREATE UNIQUE INDEX idx_assembly_packages_barcode ON assembly_packages(barcode);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS assembly_packages;
+-- +goose StatementEnd
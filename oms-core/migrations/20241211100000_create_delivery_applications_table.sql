@@ -0,0 +1,36 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Доставка посылок собранного заказа покупателю. Фото и подпись хранит приложение курьера,
+-- здесь только ссылки на них
+CREATE TABLE delivery_applications (
+    id VARCHAR(64) PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    assembly_application_id VARCHAR(64) NOT NULL REFERENCES assembly_applications(id),
+    status VARCHAR(64) NOT NULL,
+    version INT NOT NULL DEFAULT 1,
+    courier_id VARCHAR(64),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    assigned_at TIMESTAMP WITH TIME ZONE,
+    picked_up_at TIMESTAMP WITH TIME ZONE,
+    delivered_at TIMESTAMP WITH TIME ZONE,
+    closed_at TIMESTAMP WITH TIME ZONE,
+    failure_reason VARCHAR(255),
+    proof_photo_ref VARCHAR(1024),
+    proof_signature_ref VARCHAR(1024)
+);
+
+CREATE INDEX idx_delivery_applications_order_id ON delivery_applications(order_id);
+-- Заявки курьера, которые он еще не закрыл
+CREATE INDEX idx_delivery_applications_courier_id ON delivery_applications(courier_id)
+    WHERE status IN ('ASSIGNED', 'PICKED_UP');
+-- Заказ доставляется не более чем одной заявкой одновременно
+CREATE UNIQUE INDEX idx_delivery_applications_open_order ON delivery_applications(order_id)
+    WHERE status IN ('CREATED', 'ASSIGNED', 'PICKED_UP');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS delivery_applications;
+-- +goose StatementEnd
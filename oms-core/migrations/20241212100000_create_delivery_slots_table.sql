@@ -0,0 +1,46 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Окна доставки по зонам. capacity - сколько заказов зона может доставить в это окно
+CREATE TABLE delivery_slots (
+    id VARCHAR(64) PRIMARY KEY,
+    zone VARCHAR(64) NOT NULL,
+    starts_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    ends_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    capacity INT NOT NULL CHECK (capacity > 0),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    CHECK (ends_at > starts_at),
+    UNIQUE (zone, starts_at)
+);
+
+-- Бронь окна при оформлении корзины. RESERVED держит место до expires_at, CONFIRMED - место заказа.
+-- EXPIRED и RELEASED места не занимают
+CREATE TABLE delivery_slot_reservations (
+    id VARCHAR(64) PRIMARY KEY,
+    slot_id VARCHAR(64) NOT NULL REFERENCES delivery_slots(id) ON DELETE CASCADE,
+    status VARCHAR(64) NOT NULL,
+    order_id VARCHAR(64) REFERENCES orders(id) ON DELETE CASCADE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    confirmed_at TIMESTAMP WITH TIME ZONE,
+    closed_at TIMESTAMP WITH TIME ZONE
+);
+
+-- Занятые места окна
+CREATE INDEX idx_delivery_slot_reservations_slot_id ON delivery_slot_reservations(slot_id)
+    WHERE status IN ('RESERVED', 'CONFIRMED');
+-- Брони, которые истекают
+CREATE INDEX idx_delivery_slot_reservations_expires_at ON delivery_slot_reservations(expires_at)
+    WHERE status = 'RESERVED';
+CREATE INDEX idx_delivery_slot_reservations_order_id ON delivery_slot_reservations(order_id);
+
+ALTER TABLE orders ADD COLUMN delivery_slot_id VARCHAR(64) REFERENCES delivery_slots(id);
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE orders DROP COLUMN IF EXISTS delivery_slot_id;
+DROP TABLE IF EXISTS delivery_slot_reservations;
+DROP TABLE IF EXISTS delivery_slots;
+-- +goose StatementEnd
@@ -0,0 +1,31 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Оплата заказа: сумма авторизуется при создании заказа, списывается за собранное,
+-- при отмене авторизация снимается или списанное возвращается
+CREATE TABLE payments (
+    id VARCHAR(64) PRIMARY KEY,
+    order_id VARCHAR(64) NOT NULL REFERENCES orders(id) ON DELETE CASCADE,
+    status VARCHAR(64) NOT NULL,
+    amount NUMERIC(12, 2) NOT NULL,
+    captured_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    refunded_amount NUMERIC(12, 2) NOT NULL DEFAULT 0,
+    currency CHAR(3) NOT NULL,
+    gateway_reference VARCHAR(255),
+    decline_reason VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP NOT NULL,
+    version INT NOT NULL DEFAULT 1
+);
+
+CREATE INDEX idx_payments_order_id ON payments(order_id);
+-- У заказа не больше одной действующей оплаты, после отказа можно авторизовать снова
+CREATE UNIQUE INDEX idx_payments_active_order ON payments(order_id)
+    WHERE status IN ('PENDING', 'AUTHORIZED', 'CAPTURED');
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP TABLE IF EXISTS payments;
+-- +goose StatementEnd
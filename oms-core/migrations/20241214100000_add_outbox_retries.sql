@@ -0,0 +1,25 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Релей занимает сообщения на время отправки (locked_until), после ошибки откладывает
+-- следующую попытку, а сообщение, которое Kafka не примет никогда, помечает мертвым (dead_at)
+ALTER TABLE outbox
+    ADD COLUMN locked_until TIMESTAMP WITH TIME ZONE,
+    ADD COLUMN dead_at TIMESTAMP WITH TIME ZONE;
+
+DROP INDEX IF EXISTS idx_outbox_pending;
+CREATE INDEX idx_outbox_pending ON outbox(id) WHERE sent_at IS NULL AND dead_at IS NULL;
+-- Порядок соблюдается внутри ключа: сообщение ждет только более ранние сообщения своего ключа
+CREATE INDEX idx_outbox_pending_key ON outbox(message_key, id) WHERE sent_at IS NULL AND dead_at IS NULL;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+DROP INDEX IF EXISTS idx_outbox_pending_key;
+DROP INDEX IF EXISTS idx_outbox_pending;
+ALTER TABLE outbox
+    DROP COLUMN IF EXISTS dead_at,
+    DROP COLUMN IF EXISTS locked_until;
+CREATE INDEX idx_outbox_pending ON outbox(id) WHERE sent_at IS NULL;
+-- +goose StatementEnd
@@ -0,0 +1,15 @@
+-- +goose Up
+-- +goose StatementBegin
+SELECT 'up SQL query';
+-- Время, когда обработка заказа приняла собранную заявку и пошла дальше; после этого
+-- заявку нельзя отклонить по проверке качества
+ALTER TABLE assembly_applications
+    ADD COLUMN accepted_at TIMESTAMP WITH TIME ZONE;
+-- +goose StatementEnd
+
+-- +goose Down
+-- +goose StatementBegin
+SELECT 'down SQL query';
+ALTER TABLE assembly_applications
+    DROP COLUMN IF EXISTS accepted_at;
+-- +goose StatementEnd
@@ -10,10 +10,87 @@ import (
 	"github.com/milovidov983/oms-temporal-demo/shared/models"
 )
 
+// AssemblyEventFunc builds the outbox message for an assembly application
+// inside the transaction that changed it.
+type AssemblyEventFunc func(application *models.AssemblyApplication) (*OutboxMessage, error)
+
+// NewAssemblyApplication describes an application to create: the order is passed to the warehouse
+// chosen by the assembly routing and has to be assembled within SLA.
+type NewAssemblyApplication struct {
+	OrderID     string
+	WarehouseID string
+	OrderType   models.OrderType
+	SLA         time.Duration
+}
+
 type AssemblyApplicationRepository interface {
-	Create(ctx context.Context, orderID string) (*models.AssemblyApplication, error)
-	Complete(ctx context.Context, assemblyApplicationID string) (*models.AssemblyApplication, error)
-	Cancel(ctx context.Context, assemblyApplicationID string) error
+	Create(ctx context.Context, application NewAssemblyApplication, event AssemblyEventFunc) (*models.AssemblyApplication, error)
+	// Complete and Cancel fail with ErrVersionConflict when expectedVersion is not zero
+	// and differs from the current version of the application.
+	// Complete records the collected quantities, nil collected means everything was collected,
+	// and the packages the order was packed into.
+	// It fails with ErrSubstitutionPending while a substitution waits for the customer.
+	Complete(
+		ctx context.Context,
+		assemblyApplicationID string,
+		expectedVersion int,
+		collected []models.AssemblyItem,
+		packages []models.Package,
+		event AssemblyEventFunc,
+	) (*models.AssemblyApplication, error)
+	// Cancel returns the order to CREATED unless it was already canceled, so it can be routed again.
+	Cancel(ctx context.Context, assemblyApplicationID string, expectedVersion int, event AssemblyEventFunc) error
+	ChangeComment(
+		ctx context.Context,
+		assemblyApplicationID string,
+		comment string,
+		expectedVersion int,
+		event AssemblyEventFunc,
+	) (*models.AssemblyApplication, error)
+
+	// Packages, see assembly_packages.go
+	Get(ctx context.Context, assemblyApplicationID string) (*models.AssemblyApplication, error)
+
+	// SLA, see assembly_sla.go
+	MarkOverdue(ctx context.Context, assemblyApplicationID string, event AssemblyEventFunc) (*models.AssemblyApplication, error)
+	ListOverdue(ctx context.Context, warehouseID string, limit int) ([]models.AssemblyApplication, error)
+
+	// Re-assembly, see assembly_reassembly.go
+	Reject(
+		ctx context.Context,
+		assemblyApplicationID string,
+		expectedVersion int,
+		reason string,
+		sla AssemblySLAFunc,
+		event ReassemblyEventFunc,
+	) (*models.AssemblyApplication, error)
+	Accept(ctx context.Context, assemblyApplicationID string) (*models.AssemblyApplication, error)
+	ListAttempts(ctx context.Context, orderID string) ([]models.AssemblyApplication, error)
+
+	// Substitutions, see assembly_substitution.go
+	ProposeSubstitution(
+		ctx context.Context,
+		assemblyApplicationID string,
+		substitution models.Substitution,
+		expectedVersion int,
+		event AssemblyEventFunc,
+	) (*models.AssemblyApplication, error)
+	DecideSubstitution(
+		ctx context.Context,
+		assemblyApplicationID string,
+		substitutionID string,
+		approve bool,
+		expectedVersion int,
+		event AssemblyEventFunc,
+	) (*models.AssemblyApplication, error)
+
+	// Picker queue, see assembly_queue.go
+	ListQueue(ctx context.Context, warehouseID string, limit int) ([]models.AssemblyApplication, error)
+	ListPickerApplications(ctx context.Context, pickerID string) ([]models.AssemblyApplication, error)
+	ClaimNext(ctx context.Context, pickerID, warehouseID string) (*models.AssemblyApplication, error)
+	Claim(ctx context.Context, assemblyApplicationID, pickerID string, expectedVersion int) (*models.AssemblyApplication, error)
+	Start(ctx context.Context, assemblyApplicationID, pickerID string, expectedVersion int) (*models.AssemblyApplication, error)
+	Release(ctx context.Context, assemblyApplicationID, pickerID string, expectedVersion int) (*models.AssemblyApplication, error)
 }
 
 type assRepository struct {
@@ -32,10 +109,11 @@ func NewAssemblyApplicationRepository(db *sql.DB) (AssemblyApplicationRepository
 	return &assRepository{db: db}, nil
 }
 
-func (r *assRepository) Create(ctx context.Context, orderID string) (*models.AssemblyApplication, error) {
-	if orderID == "" {
-		return nil, fmt.Errorf("%w: order ID is required", ErrInvalidInput)
+func (r *assRepository) Create(ctx context.Context, draft NewAssemblyApplication, event AssemblyEventFunc) (*models.AssemblyApplication, error) {
+	if draft.OrderID == "" || draft.WarehouseID == "" {
+		return nil, fmt.Errorf("%w: order ID and warehouse ID are required", ErrInvalidInput)
 	}
+	orderID := draft.OrderID
 
 	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
 	if err != nil {
@@ -43,15 +121,11 @@ func (r *assRepository) Create(ctx context.Context, orderID string) (*models.Ass
 	}
 	defer r.rollbackOnError(tx, &err)
 
-	exists, err := r.checkOrderExists(ctx, tx, orderID)
-	if err != nil {
+	if _, err = changeOrderStatus(ctx, tx, orderID, models.OrderStatusPassedToAssembly, 0); err != nil {
 		return nil, err
 	}
-	if !exists {
-		return nil, fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
-	}
 
-	application, err := r.createAssemblyApplication(ctx, tx, orderID)
+	application, err := r.createAssemblyApplication(ctx, tx, draft)
 	if err != nil {
 		return nil, err
 	}
@@ -64,7 +138,18 @@ func (r *assRepository) Create(ctx context.Context, orderID string) (*models.Ass
 	if err != nil {
 		return nil, err
 	}
-	application.Items = items
+	if err = insertAssemblyItems(ctx, tx, application.ID, items); err != nil {
+		return nil, err
+	}
+
+	application.Items, err = fetchAssemblyItems(ctx, tx, application.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
+		return nil, err
+	}
 
 	if err = tx.Commit(); err != nil {
 		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
@@ -73,7 +158,14 @@ func (r *assRepository) Create(ctx context.Context, orderID string) (*models.Ass
 	return application, nil
 }
 
-func (r *assRepository) Complete(ctx context.Context, assemblyApplicationID string) (*models.AssemblyApplication, error) {
+func (r *assRepository) Complete(
+	ctx context.Context,
+	assemblyApplicationID string,
+	expectedVersion int,
+	collected []models.AssemblyItem,
+	packages []models.Package,
+	event AssemblyEventFunc,
+) (*models.AssemblyApplication, error) {
 	if assemblyApplicationID == "" {
 		return nil, fmt.Errorf("%w: assembly application ID is required", ErrInvalidInput)
 	}
@@ -84,19 +176,33 @@ func (r *assRepository) Complete(ctx context.Context, assemblyApplicationID stri
 	}
 	defer r.rollbackOnError(tx, &err)
 
-	exists, err := r.checkAssemblyApplicationExists(ctx, tx, assemblyApplicationID)
+	orderID, err := r.fetchApplicationOrderID(ctx, tx, assemblyApplicationID)
 	if err != nil {
 		return nil, err
 	}
-	if !exists {
-		return nil, fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
+
+	// Сначала блокируем заказ, затем заявку - тот же порядок, что и в Create
+	if _, err = changeOrderStatus(ctx, tx, orderID, models.OrderStatusAssembled, 0); err != nil {
+		return nil, err
+	}
+
+	if _, err = changeAssemblyStatus(ctx, tx, assemblyApplicationID, models.AssemblyStatusComplete, expectedVersion); err != nil {
+		return nil, err
+	}
+
+	if err = checkNoPendingSubstitutions(ctx, tx, assemblyApplicationID); err != nil {
+		return nil, err
+	}
+
+	if err = saveCollectedQuantities(ctx, tx, assemblyApplicationID, collected); err != nil {
+		return nil, err
 	}
 
-	if err = r.updateAssemblyStatus(ctx, tx, assemblyApplicationID, models.AssemblyStatusComplete); err != nil {
+	if err = savePackages(ctx, tx, assemblyApplicationID, packages); err != nil {
 		return nil, err
 	}
 
-	if err = r.updateRelatedOrderStatus(ctx, tx, assemblyApplicationID); err != nil {
+	if err = consumeStock(ctx, tx, assemblyApplicationID); err != nil {
 		return nil, err
 	}
 
@@ -105,6 +211,10 @@ func (r *assRepository) Complete(ctx context.Context, assemblyApplicationID stri
 		return nil, err
 	}
 
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
+		return nil, err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
 	}
@@ -112,7 +222,7 @@ func (r *assRepository) Complete(ctx context.Context, assemblyApplicationID stri
 	return application, nil
 }
 
-func (r *assRepository) Cancel(ctx context.Context, assemblyApplicationID string) error {
+func (r *assRepository) Cancel(ctx context.Context, assemblyApplicationID string, expectedVersion int, event AssemblyEventFunc) error {
 	if assemblyApplicationID == "" {
 		return fmt.Errorf("%w: assembly application ID is required", ErrInvalidInput)
 	}
@@ -123,15 +233,41 @@ func (r *assRepository) Cancel(ctx context.Context, assemblyApplicationID string
 	}
 	defer r.rollbackOnError(tx, &err)
 
-	exists, err := r.checkAssemblyApplicationExists(ctx, tx, assemblyApplicationID)
+	orderID, err := r.fetchApplicationOrderID(ctx, tx, assemblyApplicationID)
 	if err != nil {
 		return err
 	}
-	if !exists {
-		return fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
+
+	// Сначала блокируем заказ, затем заявку - тот же порядок, что и в Create
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return err
+	}
+
+	from, err := changeAssemblyStatus(ctx, tx, assemblyApplicationID, models.AssemblyStatusCanceled, expectedVersion)
+	if err != nil {
+		return err
+	}
+
+	if from == models.AssemblyStatusComplete {
+		if err = r.cancelCompleteApplication(ctx, tx, orderID, assemblyApplicationID); err != nil {
+			return err
+		}
 	}
 
-	if err = r.updateAssemblyStatus(ctx, tx, assemblyApplicationID, models.AssemblyStatusCanceled); err != nil {
+	if err = r.returnOrderToRouting(ctx, tx, orderID); err != nil {
+		return err
+	}
+
+	if err = rejectPendingSubstitutions(ctx, tx, assemblyApplicationID); err != nil {
+		return err
+	}
+
+	application, err := r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return err
+	}
+
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
 		return err
 	}
 
@@ -152,55 +288,116 @@ func (r *assRepository) rollbackOnError(tx *sql.Tx, err *error) {
 	}
 }
 
-func (r *assRepository) checkOrderExists(ctx context.Context, tx *sql.Tx, orderID string) (bool, error) {
-	var exists bool
-	err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1)`, orderID).Scan(&exists)
+func (r *assRepository) writeEvent(ctx context.Context, tx *sql.Tx, application *models.AssemblyApplication, event AssemblyEventFunc) error {
+	if event == nil {
+		return nil
+	}
+
+	message, err := event(application)
 	if err != nil {
-		return false, fmt.Errorf("%w: failed to check order existence: %v", ErrDatabaseOperation, err)
+		return fmt.Errorf("%w: failed to build assembly event: %v", ErrInvalidInput, err)
 	}
-	return exists, nil
+
+	return insertOutboxMessages(ctx, tx, message)
 }
 
-func (r *assRepository) createAssemblyApplication(ctx context.Context, tx *sql.Tx, orderID string) (*models.AssemblyApplication, error) {
+// createAssemblyApplication inserts the next attempt to assemble the order, linked to the previous one.
+// The order must be locked by the caller, so attempts of the order are numbered without gaps.
+func (r *assRepository) createAssemblyApplication(ctx context.Context, tx *sql.Tx, draft NewAssemblyApplication) (*models.AssemblyApplication, error) {
+	createdAt := time.Now()
+	dueAt := createdAt.Add(draft.SLA)
 	application := &models.AssemblyApplication{
-		ID:        uuid.New().String(),
-		OrderID:   orderID,
-		Status:    models.AssemblyStatus(models.AssemblyStatusCreated),
-		CreatedAt: time.Now(),
+		ID:          uuid.New().String(),
+		OrderID:     draft.OrderID,
+		WarehouseID: draft.WarehouseID,
+		Status:      models.AssemblyStatus(models.AssemblyStatusCreated),
+		CreatedAt:   createdAt,
+		Version:     1,
+		OrderType:   draft.OrderType,
+		DueAt:       &dueAt,
+		Attempt:     1,
 	}
 
-	_, err := tx.ExecContext(ctx, `
-        INSERT INTO assembly_applications (id, order_id, status, created_at)
-        VALUES ($1, $2, $3, $4)
-    `, application.ID, application.OrderID, application.Status, application.CreatedAt)
+	var previous struct {
+		id      string
+		attempt int
+	}
+	err := tx.QueryRowContext(ctx, `
+        SELECT id, attempt
+        FROM assembly_applications
+        WHERE order_id = $1
+        ORDER BY attempt DESC
+        LIMIT 1
+    `, draft.OrderID).Scan(&previous.id, &previous.attempt)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: failed to fetch previous assembly attempt: %v", ErrDatabaseOperation, err)
+	}
+	if err == nil {
+		application.Attempt = previous.attempt + 1
+		application.PreviousApplicationID = previous.id
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO assembly_applications
+            (id, order_id, warehouse_id, status, created_at, version, order_type, due_at, attempt, previous_application_id)
+        VALUES ($1, $2, $3, $4, $5, 1, $6, $7, $8, NULLIF($9, ''))
+    `, application.ID, application.OrderID, application.WarehouseID, application.Status, application.CreatedAt,
+		application.OrderType, application.DueAt, application.Attempt, application.PreviousApplicationID)
 
 	if err != nil {
 		return nil, fmt.Errorf("%w: failed to create assembly application: %v", ErrDatabaseOperation, err)
 	}
 
+	err = insertStatusHistory(ctx, tx, application.OrderID, HistoryEntityAssemblyApplication, application.ID, "", string(application.Status))
+	if err != nil {
+		return nil, err
+	}
+
 	return application, nil
 }
 
-func (r *assRepository) updateOrder(ctx context.Context, tx *sql.Tx, orderID, assemblyApplicationID string) error {
-	result, err := tx.ExecContext(ctx, `
-        UPDATE orders
-        SET assembly_application_id = $1, status = $2
-        WHERE id = $3
-    `, assemblyApplicationID, models.OrderStatusPassedToAssembly, orderID)
-
+// cancelCompleteApplication undoes a complete assembly of a canceled order: the collected items go back
+// to the warehouse stock. While the order is not canceled its complete assembly can only be rejected.
+func (r *assRepository) cancelCompleteApplication(ctx context.Context, tx *sql.Tx, orderID, assemblyApplicationID string) error {
+	var status models.OrderStatus
+	err := tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1`, orderID).Scan(&status)
 	if err != nil {
-		return fmt.Errorf("%w: failed to update order: %v", ErrDatabaseOperation, err)
+		return fmt.Errorf("%w: failed to fetch order status: %v", ErrDatabaseOperation, err)
+	}
+	if status != models.OrderStatusCanceled {
+		return &InvalidTransitionError{
+			Entity: "assembly application",
+			ID:     assemblyApplicationID,
+			From:   string(models.AssemblyStatusComplete),
+			To:     string(models.AssemblyStatusCanceled),
+		}
 	}
 
-	rows, err := result.RowsAffected()
+	return restoreStock(ctx, tx, assemblyApplicationID)
+}
+
+// returnOrderToRouting moves the order of a canceled application back to CREATED and unlinks the application,
+// so the order can be routed to another warehouse. An order that is no longer passed to assembly,
+// e.g. canceled by the customer, is left as it is.
+func (r *assRepository) returnOrderToRouting(ctx context.Context, tx *sql.Tx, orderID string) error {
+	var status models.OrderStatus
+	err := tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1`, orderID).Scan(&status)
 	if err != nil {
-		return fmt.Errorf("%w: failed to get rows affected: %v", ErrDatabaseOperation, err)
+		return fmt.Errorf("%w: failed to fetch order status: %v", ErrDatabaseOperation, err)
 	}
-	if rows == 0 {
-		return fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+	if status != models.OrderStatusPassedToAssembly {
+		return nil
 	}
 
-	return nil
+	if _, err = changeOrderStatus(ctx, tx, orderID, models.OrderStatusCreated, 0); err != nil {
+		return err
+	}
+
+	return updateLockedRow(ctx, tx, "orders", "order", orderID, `assembly_application_id = NULL`)
+}
+
+func (r *assRepository) updateOrder(ctx context.Context, tx *sql.Tx, orderID, assemblyApplicationID string) error {
+	return updateLockedRow(ctx, tx, "orders", "order", orderID, `assembly_application_id = $3`, assemblyApplicationID)
 }
 
 func (r *assRepository) fetchOrderItems(ctx context.Context, tx *sql.Tx, orderID string) ([]models.AssemblyItem, error) {
@@ -230,73 +427,59 @@ func (r *assRepository) fetchOrderItems(ctx context.Context, tx *sql.Tx, orderID
 	return items, nil
 }
 
-func (r *assRepository) checkAssemblyApplicationExists(ctx context.Context, tx *sql.Tx, assemblyID string) (bool, error) {
-	var exists bool
-	err := tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM assembly_applications WHERE id = $1)`, assemblyID).Scan(&exists)
-	if err != nil {
-		return false, fmt.Errorf("%w: failed to check assembly application existence: %v", ErrDatabaseOperation, err)
-	}
-	return exists, nil
-}
-
-func (r *assRepository) updateAssemblyStatus(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, status models.AssemblyStatus) error {
-	result, err := tx.ExecContext(ctx, `
-        UPDATE assembly_applications
-        SET status = $1, completed_at = $2
-        WHERE id = $3
-    `, status, time.Now(), assemblyApplicationID)
-
-	if err != nil {
-		return fmt.Errorf("%w: failed to update assembly status: %v", ErrDatabaseOperation, err)
+func (r *assRepository) fetchApplicationOrderID(ctx context.Context, tx *sql.Tx, assemblyApplicationID string) (string, error) {
+	var orderID string
+	err := tx.QueryRowContext(ctx, `SELECT order_id FROM assembly_applications WHERE id = $1`, assemblyApplicationID).Scan(&orderID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
 	}
-
-	rows, err := result.RowsAffected()
 	if err != nil {
-		return fmt.Errorf("%w: failed to get rows affected: %v", ErrDatabaseOperation, err)
+		return "", fmt.Errorf("%w: failed to fetch assembly application: %v", ErrDatabaseOperation, err)
 	}
-	if rows == 0 {
-		return fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
-	}
-
-	return nil
+	return orderID, nil
 }
 
-func (r *assRepository) updateRelatedOrderStatus(ctx context.Context, tx *sql.Tx, assemblyApplicationID string) error {
-	result, err := tx.ExecContext(ctx, `
-        UPDATE orders
-        SET status = $1
-        WHERE assembly_application_id = $2
-    `, models.OrderStatusAssembled, assemblyApplicationID)
-
-	if err != nil {
-		return fmt.Errorf("%w: failed to update order status: %v", ErrDatabaseOperation, err)
-	}
-
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("%w: failed to get rows affected: %v", ErrDatabaseOperation, err)
-	}
-	if rows == 0 {
-		return fmt.Errorf("%w: no order found for assembly application ID %s", ErrOrderNotFound, assemblyApplicationID)
-	}
+// assemblyApplicationColumns are the columns read by scanAssemblyApplication.
+const assemblyApplicationColumns = `
+    id, order_id, warehouse_id, status, created_at, completed_at, COALESCE(comment, ''), version,
+    COALESCE(picker_id, ''), started_at, COALESCE(order_type, ''), due_at, overdue_at,
+    attempt, COALESCE(previous_application_id, ''), rejected_at, COALESCE(rejection_reason, ''), accepted_at`
 
-	return nil
+type rowScanner interface {
+	Scan(dest ...interface{}) error
 }
 
-func (r *assRepository) fetchAssemblyApplication(ctx context.Context, tx *sql.Tx, assemblyApplicationID string) (*models.AssemblyApplication, error) {
+func scanAssemblyApplication(row rowScanner) (*models.AssemblyApplication, error) {
 	application := &models.AssemblyApplication{}
-
-	err := tx.QueryRowContext(ctx, `
-        SELECT id, order_id, status, created_at, comment
-        FROM assembly_applications
-        WHERE id = $1
-    `, assemblyApplicationID).Scan(
+	err := row.Scan(
 		&application.ID,
 		&application.OrderID,
+		&application.WarehouseID,
 		&application.Status,
 		&application.CreatedAt,
+		&application.CompletedAt,
 		&application.Comment,
+		&application.Version,
+		&application.PickerID,
+		&application.StartedAt,
+		&application.OrderType,
+		&application.DueAt,
+		&application.OverdueAt,
+		&application.Attempt,
+		&application.PreviousApplicationID,
+		&application.RejectedAt,
+		&application.RejectionReason,
+		&application.AcceptedAt,
 	)
+	return application, err
+}
+
+func (r *assRepository) fetchAssemblyApplication(ctx context.Context, q querier, assemblyApplicationID string) (*models.AssemblyApplication, error) {
+	application, err := scanAssemblyApplication(q.QueryRowContext(ctx, `
+        SELECT `+assemblyApplicationColumns+`
+        FROM assembly_applications
+        WHERE id = $1
+    `, assemblyApplicationID))
 
 	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
@@ -305,11 +488,20 @@ func (r *assRepository) fetchAssemblyApplication(ctx context.Context, tx *sql.Tx
 		return nil, fmt.Errorf("%w: failed to fetch assembly application: %v", ErrDatabaseOperation, err)
 	}
 
-	items, err := r.fetchOrderItems(ctx, tx, application.OrderID)
+	application.Items, err = fetchAssemblyItems(ctx, q, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	application.Substitutions, err = fetchSubstitutions(ctx, q, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	application.Packages, err = fetchPackages(ctx, q, assemblyApplicationID)
 	if err != nil {
 		return nil, err
 	}
-	application.Items = items
 
 	return application, nil
 }
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// ChangeComment stores the comment of an assembly application and writes the event in the same transaction.
+// Comments of complete or canceled applications cannot be changed.
+func (r *assRepository) ChangeComment(
+	ctx context.Context,
+	assemblyApplicationID string,
+	comment string,
+	expectedVersion int,
+	event AssemblyEventFunc,
+) (application *models.AssemblyApplication, err error) {
+	if assemblyApplicationID == "" {
+		return nil, fmt.Errorf("%w: assembly application ID is required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	version, err := lockOpenApplication(ctx, tx, assemblyApplicationID, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE assembly_applications
+        SET comment = $1, version = version + 1
+        WHERE id = $2 AND version = $3
+    `, comment, assemblyApplicationID, version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to update assembly comment: %v", ErrDatabaseOperation, err)
+	}
+	if err = checkRowUpdated(result, "assembly application", assemblyApplicationID, version); err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
@@ -0,0 +1,108 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// insertAssemblyItems stores the lines of an assembly application as requested, nothing is collected yet.
+func insertAssemblyItems(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, items []models.AssemblyItem) error {
+	for _, item := range items {
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO assembly_items (assembly_application_id, product_id, requested_quantity)
+            VALUES ($1, $2, $3)
+        `, assemblyApplicationID, item.ProductID, item.Quantity)
+		if err != nil {
+			return fmt.Errorf("%w: failed to insert assembly item: %v", ErrDatabaseOperation, err)
+		}
+	}
+	return nil
+}
+
+// replaceAssemblyItems replaces the requested lines of an application that is not picked yet,
+// e.g. after the order was amended.
+func replaceAssemblyItems(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, items []models.AssemblyItem) error {
+	_, err := tx.ExecContext(ctx, `DELETE FROM assembly_items WHERE assembly_application_id = $1`, assemblyApplicationID)
+	if err != nil {
+		return fmt.Errorf("%w: failed to delete assembly items: %v", ErrDatabaseOperation, err)
+	}
+	return insertAssemblyItems(ctx, tx, assemblyApplicationID, items)
+}
+
+// fetchAssemblyItems returns the lines of an assembly application with prices of the order lines.
+func fetchAssemblyItems(ctx context.Context, q querier, assemblyApplicationID string) ([]models.AssemblyItem, error) {
+	rows, err := q.QueryContext(ctx, `
+        SELECT ai.product_id, ai.requested_quantity, COALESCE(ai.collected_quantity, 0), COALESCE(oi.price, 0)
+        FROM assembly_items ai
+        JOIN assembly_applications a ON a.id = ai.assembly_application_id
+        LEFT JOIN order_items oi ON oi.order_id = a.order_id AND oi.product_id = ai.product_id
+        WHERE ai.assembly_application_id = $1
+        ORDER BY ai.id
+    `, assemblyApplicationID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch assembly items: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	items := []models.AssemblyItem{}
+	for rows.Next() {
+		var item models.AssemblyItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity, &item.Collected, &item.Price); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan assembly item: %v", ErrDatabaseOperation, err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return items, nil
+}
+
+// saveCollectedQuantities records what was picked. A nil collected means every line was collected in full,
+// otherwise lines missing from collected are recorded as not collected at all.
+// Unknown products and quantities above the requested ones fail with ErrInvalidInput.
+func saveCollectedQuantities(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, collected []models.AssemblyItem) error {
+	items, err := fetchAssemblyItems(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return err
+	}
+
+	requested := make(map[string]int, len(items))
+	quantities := make(map[string]int, len(items))
+	for _, item := range items {
+		requested[item.ProductID] = item.Quantity
+		quantities[item.ProductID] = 0
+		if collected == nil {
+			quantities[item.ProductID] = item.Quantity
+		}
+	}
+
+	for _, item := range collected {
+		quantity, ok := requested[item.ProductID]
+		if !ok {
+			return fmt.Errorf("%w: product %s is not in assembly application %s", ErrInvalidInput, item.ProductID, assemblyApplicationID)
+		}
+		if item.Collected > quantity {
+			return fmt.Errorf("%w: collected %d of product %s, only %d requested", ErrInvalidInput, item.Collected, item.ProductID, quantity)
+		}
+		quantities[item.ProductID] = item.Collected
+	}
+
+	for productID, quantity := range quantities {
+		_, err := tx.ExecContext(ctx, `
+            UPDATE assembly_items
+            SET collected_quantity = $1
+            WHERE assembly_application_id = $2 AND product_id = $3
+        `, quantity, assemblyApplicationID, productID)
+		if err != nil {
+			return fmt.Errorf("%w: failed to update assembly item: %v", ErrDatabaseOperation, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,82 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// Get returns the application with its lines, substitutions and packages.
+func (r *assRepository) Get(ctx context.Context, assemblyApplicationID string) (*models.AssemblyApplication, error) {
+	if assemblyApplicationID == "" {
+		return nil, fmt.Errorf("%w: assembly application ID is required", ErrInvalidInput)
+	}
+
+	return r.fetchAssemblyApplication(ctx, r.db, assemblyApplicationID)
+}
+
+// savePackages stores the packages of a complete application, numbering them from 1.
+// A package without a barcode gets one generated from the application ID and the package number.
+func savePackages(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, packages []models.Package) error {
+	for i, p := range packages {
+		p.Number = i + 1
+		if p.Barcode == "" {
+			p.Barcode = packageBarcode(assemblyApplicationID, p.Number)
+		}
+
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO assembly_packages
+                (assembly_application_id, number, barcode, weight_grams, length_mm, width_mm, height_mm)
+            VALUES ($1, $2, $3, $4, $5, $6, $7)
+        `, assemblyApplicationID, p.Number, p.Barcode, p.WeightGrams, p.LengthMM, p.WidthMM, p.HeightMM)
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+			return fmt.Errorf("%w: barcode %s is already used by another package", ErrInvalidInput, p.Barcode)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: failed to save package: %v", ErrDatabaseOperation, err)
+		}
+	}
+
+	return nil
+}
+
+// packageBarcode is PKG, the first 12 hex digits of the application ID and the two-digit package number.
+func packageBarcode(assemblyApplicationID string, number int) string {
+	id := strings.ToUpper(strings.ReplaceAll(assemblyApplicationID, "-", ""))
+	if len(id) > 12 {
+		id = id[:12]
+	}
+	return fmt.Sprintf("PKG%s%02d", id, number)
+}
+
+func fetchPackages(ctx context.Context, q querier, assemblyApplicationID string) ([]models.Package, error) {
+	rows, err := q.QueryContext(ctx, `
+        SELECT number, barcode, weight_grams, length_mm, width_mm, height_mm
+        FROM assembly_packages
+        WHERE assembly_application_id = $1
+        ORDER BY number
+    `, assemblyApplicationID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch packages: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	var packages []models.Package
+	for rows.Next() {
+		var p models.Package
+		if err := rows.Scan(&p.Number, &p.Barcode, &p.WeightGrams, &p.LengthMM, &p.WidthMM, &p.HeightMM); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan package: %v", ErrDatabaseOperation, err)
+		}
+		packages = append(packages, p)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return packages, nil
+}
@@ -0,0 +1,348 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+const (
+	DefaultAssemblyQueueLimit = 50
+	MaxAssemblyQueueLimit     = 500
+)
+
+// ListQueue returns unclaimed applications in status CREATED, the oldest first.
+// An empty warehouseID lists the queues of all warehouses.
+func (r *assRepository) ListQueue(ctx context.Context, warehouseID string, limit int) ([]models.AssemblyApplication, error) {
+	if limit <= 0 {
+		limit = DefaultAssemblyQueueLimit
+	}
+	if limit > MaxAssemblyQueueLimit {
+		limit = MaxAssemblyQueueLimit
+	}
+
+	return r.listApplications(ctx, `
+        SELECT `+assemblyApplicationColumns+`
+        FROM assembly_applications
+        WHERE status = $1 AND picker_id IS NULL AND ($2 = '' OR warehouse_id = $2)
+        ORDER BY created_at, id
+        LIMIT $3
+    `, models.AssemblyStatusCreated, warehouseID, limit)
+}
+
+// ListPickerApplications returns the applications the picker has claimed and not finished yet.
+func (r *assRepository) ListPickerApplications(ctx context.Context, pickerID string) ([]models.AssemblyApplication, error) {
+	if pickerID == "" {
+		return nil, fmt.Errorf("%w: picker ID is required", ErrInvalidInput)
+	}
+
+	return r.listApplications(ctx, `
+        SELECT `+assemblyApplicationColumns+`
+        FROM assembly_applications
+        WHERE picker_id = $1 AND status IN ($2, $3)
+        ORDER BY started_at, id
+    `, pickerID, models.AssemblyStatusCreated, models.AssemblyStatusSent)
+}
+
+// ClaimNext gives the oldest unclaimed application of the warehouse to the picker. Rows locked by concurrent
+// claims are skipped, so two pickers never get the same application. It fails with ErrAssemblyQueueEmpty
+// when there is nothing to claim.
+func (r *assRepository) ClaimNext(ctx context.Context, pickerID, warehouseID string) (application *models.AssemblyApplication, err error) {
+	if pickerID == "" {
+		return nil, fmt.Errorf("%w: picker ID is required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	var id string
+	err = tx.QueryRowContext(ctx, `
+        SELECT id
+        FROM assembly_applications
+        WHERE status = $1 AND picker_id IS NULL AND ($2 = '' OR warehouse_id = $2)
+        ORDER BY created_at, id
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED
+    `, models.AssemblyStatusCreated, warehouseID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return nil, ErrAssemblyQueueEmpty
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to claim assembly application: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = r.setPicker(ctx, tx, id, pickerID); err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// Claim gives the application to the picker. Claiming an application the picker already holds is a no-op,
+// an application held by another picker fails with ErrAssemblyApplicationClaimed.
+func (r *assRepository) Claim(
+	ctx context.Context,
+	assemblyApplicationID string,
+	pickerID string,
+	expectedVersion int,
+) (application *models.AssemblyApplication, err error) {
+	if assemblyApplicationID == "" || pickerID == "" {
+		return nil, fmt.Errorf("%w: assembly application ID and picker ID are required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	claim, err := lockAssemblyClaim(ctx, tx, assemblyApplicationID, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case claim.status.IsClosed():
+		return nil, fmt.Errorf("%w: assembly application %s is %s", ErrAssemblyApplicationClosed, assemblyApplicationID, claim.status)
+	case claim.pickerID == pickerID:
+		// Повторный захват той же заявки тем же сборщиком ничего не меняет
+	case claim.pickerID != "":
+		return nil, fmt.Errorf("%w: assembly application %s is claimed by %s", ErrAssemblyApplicationClaimed, assemblyApplicationID, claim.pickerID)
+	default:
+		if err = r.setPicker(ctx, tx, assemblyApplicationID, pickerID); err != nil {
+			return nil, err
+		}
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// Start moves the application claimed by the picker to SENT: picking has begun.
+func (r *assRepository) Start(
+	ctx context.Context,
+	assemblyApplicationID string,
+	pickerID string,
+	expectedVersion int,
+) (application *models.AssemblyApplication, err error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	claim, err := lockAssemblyClaim(ctx, tx, assemblyApplicationID, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err = claim.checkPicker(assemblyApplicationID, pickerID); err != nil {
+		return nil, err
+	}
+
+	if _, err = changeAssemblyStatus(ctx, tx, assemblyApplicationID, models.AssemblyStatusSent, 0); err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// Release returns the application claimed by the picker to the queue. A started application goes back to CREATED.
+func (r *assRepository) Release(
+	ctx context.Context,
+	assemblyApplicationID string,
+	pickerID string,
+	expectedVersion int,
+) (application *models.AssemblyApplication, err error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	claim, err := lockAssemblyClaim(ctx, tx, assemblyApplicationID, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+	if err = claim.checkPicker(assemblyApplicationID, pickerID); err != nil {
+		return nil, err
+	}
+
+	if claim.status == models.AssemblyStatusSent {
+		if _, err = changeAssemblyStatus(ctx, tx, assemblyApplicationID, models.AssemblyStatusCreated, 0); err != nil {
+			return nil, err
+		}
+	}
+
+	err = updateLockedRow(ctx, tx, "assembly_applications", "assembly application", assemblyApplicationID,
+		`picker_id = NULL, started_at = NULL`)
+	if err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// assemblyClaim is the queue state of a locked assembly application.
+type assemblyClaim struct {
+	status   models.AssemblyStatus
+	pickerID string
+}
+
+// checkPicker makes sure the application is claimed by the picker and is still open.
+func (c assemblyClaim) checkPicker(assemblyApplicationID, pickerID string) error {
+	if c.status.IsClosed() {
+		return fmt.Errorf("%w: assembly application %s is %s", ErrAssemblyApplicationClosed, assemblyApplicationID, c.status)
+	}
+	if pickerID == "" || c.pickerID != pickerID {
+		return fmt.Errorf("%w: assembly application %s is not claimed by picker %q", ErrAssemblyApplicationClaimed, assemblyApplicationID, pickerID)
+	}
+	return nil
+}
+
+// lockAssemblyClaim locks the application row until the end of tx and checks the expected version.
+func lockAssemblyClaim(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, expectedVersion int) (*assemblyClaim, error) {
+	var (
+		claim   assemblyClaim
+		version int
+	)
+	err := tx.QueryRowContext(ctx, `
+        SELECT status, COALESCE(picker_id, ''), version
+        FROM assembly_applications
+        WHERE id = $1
+        FOR UPDATE
+    `, assemblyApplicationID).Scan(&claim.status, &claim.pickerID, &version)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to lock assembly application: %v", ErrDatabaseOperation, err)
+	}
+
+	if err := checkVersion("assembly application", assemblyApplicationID, expectedVersion, version); err != nil {
+		return nil, err
+	}
+
+	return &claim, nil
+}
+
+func (r *assRepository) setPicker(ctx context.Context, tx *sql.Tx, assemblyApplicationID, pickerID string) error {
+	return updateLockedRow(ctx, tx, "assembly_applications", "assembly application", assemblyApplicationID,
+		`picker_id = $3, started_at = $4`, pickerID, time.Now())
+}
+
+// listApplications runs a query selecting assemblyApplicationColumns and loads the lines of the found applications.
+func (r *assRepository) listApplications(ctx context.Context, query string, args ...interface{}) ([]models.AssemblyApplication, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list assembly applications: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	applications := []models.AssemblyApplication{}
+	for rows.Next() {
+		application, err := scanAssemblyApplication(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to scan assembly application: %v", ErrDatabaseOperation, err)
+		}
+		applications = append(applications, *application)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	if err := r.fillAssemblyItems(ctx, applications); err != nil {
+		return nil, err
+	}
+
+	return applications, nil
+}
+
+// fillAssemblyItems loads the lines of all applications with a single query.
+func (r *assRepository) fillAssemblyItems(ctx context.Context, applications []models.AssemblyApplication) error {
+	if len(applications) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(applications))
+	index := make(map[string]int, len(applications))
+	for i := range applications {
+		ids[i] = applications[i].ID
+		index[applications[i].ID] = i
+		applications[i].Items = []models.AssemblyItem{}
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT ai.assembly_application_id, ai.product_id, ai.requested_quantity,
+               COALESCE(ai.collected_quantity, 0), COALESCE(oi.price, 0)
+        FROM assembly_items ai
+        JOIN assembly_applications a ON a.id = ai.assembly_application_id
+        LEFT JOIN order_items oi ON oi.order_id = a.order_id AND oi.product_id = ai.product_id
+        WHERE ai.assembly_application_id = ANY($1)
+        ORDER BY ai.id
+    `, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("%w: failed to fetch assembly items: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			applicationID string
+			item          models.AssemblyItem
+		)
+		if err := rows.Scan(&applicationID, &item.ProductID, &item.Quantity, &item.Collected, &item.Price); err != nil {
+			return fmt.Errorf("%w: failed to scan assembly item: %v", ErrDatabaseOperation, err)
+		}
+		i := index[applicationID]
+		applications[i].Items = append(applications[i].Items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,232 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// AssemblySLAFunc tells how long the warehouse has to assemble an order of the type.
+type AssemblySLAFunc func(ctx context.Context, warehouseID string, orderType models.OrderType) (time.Duration, error)
+
+// ReassemblyEventFunc builds the outbox messages about the rejected application and the attempt
+// created to assemble the order again, inside the transaction that changed them.
+type ReassemblyEventFunc func(rejected, next *models.AssemblyApplication) ([]*OutboxMessage, error)
+
+// Reject rejects a complete application that failed the quality check. In the same transaction the collected
+// items return to the warehouse stock, the order goes back from ASSEMBLED to PASSED_TO_ASSEMBLY and the next
+// attempt is created in the same warehouse, due by sla from now. It returns the next attempt.
+// An application accepted by the order processing cannot be rejected.
+func (r *assRepository) Reject(
+	ctx context.Context,
+	assemblyApplicationID string,
+	expectedVersion int,
+	reason string,
+	sla AssemblySLAFunc,
+	event ReassemblyEventFunc,
+) (next *models.AssemblyApplication, err error) {
+	if assemblyApplicationID == "" {
+		return nil, fmt.Errorf("%w: assembly application ID is required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	orderID, err := r.fetchApplicationOrderID(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Сначала блокируем заказ, затем заявку - тот же порядок, что и в Create
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	var accepted bool
+	err = tx.QueryRowContext(ctx, `SELECT accepted_at IS NOT NULL FROM assembly_applications WHERE id = $1`, assemblyApplicationID).Scan(&accepted)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch assembly application: %v", ErrDatabaseOperation, err)
+	}
+	if accepted {
+		return nil, fmt.Errorf("%w: order processing has moved past assembly application %s", ErrAssemblyApplicationAccepted, assemblyApplicationID)
+	}
+
+	if _, err = changeAssemblyStatus(ctx, tx, assemblyApplicationID, models.AssemblyStatusRejected, expectedVersion); err != nil {
+		return nil, err
+	}
+
+	if _, err = changeOrderStatus(ctx, tx, orderID, models.OrderStatusPassedToAssembly, 0); err != nil {
+		return nil, err
+	}
+
+	err = updateLockedRow(ctx, tx, "assembly_applications", "assembly application", assemblyApplicationID,
+		`rejected_at = $3, rejection_reason = $4`, time.Now(), reason)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = restoreStock(ctx, tx, assemblyApplicationID); err != nil {
+		return nil, err
+	}
+
+	rejected, err := r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Заявки, созданные до SLA, не знают тип заказа
+	orderType := rejected.OrderType
+	if orderType == "" {
+		orderType = models.OrderTypeAssembly
+	}
+	duration, err := sla(ctx, rejected.WarehouseID, orderType)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err = r.createAssemblyApplication(ctx, tx, NewAssemblyApplication{
+		OrderID:     orderID,
+		WarehouseID: rejected.WarehouseID,
+		OrderType:   orderType,
+		SLA:         duration,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.updateOrder(ctx, tx, orderID, next.ID); err != nil {
+		return nil, err
+	}
+
+	items, err := r.fetchOrderItems(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	if err = insertAssemblyItems(ctx, tx, next.ID, items); err != nil {
+		return nil, err
+	}
+
+	next.Items, err = fetchAssemblyItems(ctx, tx, next.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if event != nil {
+		var messages []*OutboxMessage
+		messages, err = event(rejected, next)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to build assembly event: %v", ErrInvalidInput, err)
+		}
+		if err = insertOutboxMessages(ctx, tx, messages...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return next, nil
+}
+
+// Accept marks the complete application of an ASSEMBLED order accepted when the order processing moves past
+// the assembly; from then on the quality check cannot reject it. An application that was rejected or whose order
+// was canceled in the meantime returns ErrAssemblyApplicationClosed. Accepting it again returns it as it is.
+func (r *assRepository) Accept(ctx context.Context, assemblyApplicationID string) (application *models.AssemblyApplication, err error) {
+	if assemblyApplicationID == "" {
+		return nil, fmt.Errorf("%w: assembly application ID is required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	orderID, err := r.fetchApplicationOrderID(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Тот же порядок блокировок, что и в Reject: отклонение и принятие не проходят одновременно
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+	if application.AcceptedAt != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+		}
+		return application, nil
+	}
+	if application.Status != models.AssemblyStatusComplete {
+		return nil, fmt.Errorf("%w: assembly application %s is %s", ErrAssemblyApplicationClosed, assemblyApplicationID, application.Status)
+	}
+
+	var orderStatus models.OrderStatus
+	err = tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1`, orderID).Scan(&orderStatus)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch order: %v", ErrDatabaseOperation, err)
+	}
+	if orderStatus != models.OrderStatusAssembled {
+		return nil, fmt.Errorf("%w: order %s of assembly application %s is %s", ErrAssemblyApplicationClosed, orderID, assemblyApplicationID, orderStatus)
+	}
+
+	err = updateLockedRow(ctx, tx, "assembly_applications", "assembly application", assemblyApplicationID,
+		`accepted_at = $3`, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// ListAttempts returns every assembly application of the order, the first attempt first.
+func (r *assRepository) ListAttempts(ctx context.Context, orderID string) ([]models.AssemblyApplication, error) {
+	if orderID == "" {
+		return nil, fmt.Errorf("%w: order ID is required", ErrInvalidInput)
+	}
+
+	applications, err := r.listApplications(ctx, `
+        SELECT `+assemblyApplicationColumns+`
+        FROM assembly_applications
+        WHERE order_id = $1
+        ORDER BY attempt
+    `, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Пустой список - либо заказ еще не передавали в сборку, либо его нет
+	if len(applications) == 0 {
+		var exists bool
+		err = r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, orderID).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to fetch order: %v", ErrDatabaseOperation, err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+		}
+	}
+
+	return applications, nil
+}
@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// MarkOverdue escalates an open application that missed its SLA: the application and its order are marked
+// overdue and the event is written in the same transaction. An application that is already marked is returned
+// as it is without a new event, so a repeated call is safe. Complete and canceled applications fail
+// with ErrAssemblyApplicationClosed.
+func (r *assRepository) MarkOverdue(
+	ctx context.Context,
+	assemblyApplicationID string,
+	event AssemblyEventFunc,
+) (application *models.AssemblyApplication, err error) {
+	if assemblyApplicationID == "" {
+		return nil, fmt.Errorf("%w: assembly application ID is required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	orderID, err := r.fetchApplicationOrderID(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Сначала блокируем заказ, затем заявку - тот же порядок, что и в Create
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	version, err := lockOpenApplication(ctx, tx, assemblyApplicationID, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+	if application.OverdueAt != nil {
+		if err = tx.Commit(); err != nil {
+			return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+		}
+		return application, nil
+	}
+
+	now := time.Now()
+	result, err := tx.ExecContext(ctx, `
+        UPDATE assembly_applications
+        SET overdue_at = $1, version = version + 1
+        WHERE id = $2 AND version = $3
+    `, now, assemblyApplicationID, version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to mark assembly application overdue: %v", ErrDatabaseOperation, err)
+	}
+	if err = checkRowUpdated(result, "assembly application", assemblyApplicationID, version); err != nil {
+		return nil, err
+	}
+
+	if err = updateLockedRow(ctx, tx, "orders", "order", orderID, `assembly_overdue_at = $3`, now); err != nil {
+		return nil, err
+	}
+
+	application.OverdueAt = &now
+	application.Version++
+
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// ListOverdue returns open applications past their SLA, the most overdue first.
+// An empty warehouseID lists all warehouses.
+func (r *assRepository) ListOverdue(ctx context.Context, warehouseID string, limit int) ([]models.AssemblyApplication, error) {
+	if limit <= 0 {
+		limit = DefaultAssemblyQueueLimit
+	}
+	if limit > MaxAssemblyQueueLimit {
+		limit = MaxAssemblyQueueLimit
+	}
+
+	return r.listApplications(ctx, `
+        SELECT `+assemblyApplicationColumns+`
+        FROM assembly_applications
+        WHERE status IN ($1, $2) AND due_at < $3 AND ($4 = '' OR warehouse_id = $4)
+        ORDER BY due_at, id
+        LIMIT $5
+    `, models.AssemblyStatusCreated, models.AssemblyStatusSent, time.Now(), warehouseID, limit)
+}
@@ -0,0 +1,368 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// ProposeSubstitution records a substitute for a line of an open application. The line must have at least
+// substitution.Quantity units requested and the substitute must not be in the order yet. A line can have only
+// one proposal waiting for the customer, another one fails with ErrSubstitutionPending.
+// It returns the application with the new substitution.
+func (r *assRepository) ProposeSubstitution(
+	ctx context.Context,
+	assemblyApplicationID string,
+	substitution models.Substitution,
+	expectedVersion int,
+	event AssemblyEventFunc,
+) (application *models.AssemblyApplication, err error) {
+	if assemblyApplicationID == "" {
+		return nil, fmt.Errorf("%w: assembly application ID is required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	version, err := lockOpenApplication(ctx, tx, assemblyApplicationID, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkSubstitutable(ctx, tx, assemblyApplicationID, substitution); err != nil {
+		return nil, err
+	}
+
+	var pending bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT EXISTS (
+            SELECT 1 FROM assembly_substitutions
+            WHERE assembly_application_id = $1 AND product_id = $2 AND status = $3
+        )
+    `, assemblyApplicationID, substitution.ProductID, models.SubstitutionStatusProposed).Scan(&pending)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to check pending substitutions: %v", ErrDatabaseOperation, err)
+	}
+	if pending {
+		return nil, fmt.Errorf("%w: product %s of assembly application %s", ErrSubstitutionPending, substitution.ProductID, assemblyApplicationID)
+	}
+
+	substitution.ID = uuid.New().String()
+	substitution.Status = models.SubstitutionStatusProposed
+	substitution.ProposedAt = time.Now()
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO assembly_substitutions (
+            id, assembly_application_id, product_id, substitute_product_id, quantity, price, status, proposed_at
+        )
+        VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+    `, substitution.ID, assemblyApplicationID, substitution.ProductID, substitution.SubstituteProductID,
+		substitution.Quantity, substitution.Price, substitution.Status, substitution.ProposedAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to insert substitution: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = bumpApplicationVersion(ctx, tx, assemblyApplicationID, version); err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// DecideSubstitution approves or rejects a proposed substitution. An approved substitute replaces the units
+// of the line in the order and in the application, and the order total is recomputed in the same transaction.
+// A substitution that was already decided fails with ErrInvalidTransition.
+func (r *assRepository) DecideSubstitution(
+	ctx context.Context,
+	assemblyApplicationID string,
+	substitutionID string,
+	approve bool,
+	expectedVersion int,
+	event AssemblyEventFunc,
+) (application *models.AssemblyApplication, err error) {
+	if assemblyApplicationID == "" || substitutionID == "" {
+		return nil, fmt.Errorf("%w: assembly application ID and substitution ID are required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	orderID, err := r.fetchApplicationOrderID(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Сначала блокируем заказ, затем заявку - тот же порядок, что и в Create и Complete
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	version, err := lockOpenApplication(ctx, tx, assemblyApplicationID, expectedVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	var substitution models.Substitution
+	err = tx.QueryRowContext(ctx, `
+        SELECT product_id, substitute_product_id, quantity, price, status
+        FROM assembly_substitutions
+        WHERE id = $1 AND assembly_application_id = $2
+        FOR UPDATE
+    `, substitutionID, assemblyApplicationID).Scan(
+		&substitution.ProductID,
+		&substitution.SubstituteProductID,
+		&substitution.Quantity,
+		&substitution.Price,
+		&substitution.Status,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: ID %s", ErrSubstitutionNotFound, substitutionID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to lock substitution: %v", ErrDatabaseOperation, err)
+	}
+
+	status := models.SubstitutionStatusRejected
+	if approve {
+		status = models.SubstitutionStatusApproved
+	}
+	if substitution.Status != models.SubstitutionStatusProposed {
+		return nil, &InvalidTransitionError{
+			Entity: "substitution",
+			ID:     substitutionID,
+			From:   string(substitution.Status),
+			To:     string(status),
+		}
+	}
+
+	if approve {
+		if err = applySubstitution(ctx, tx, orderID, assemblyApplicationID, substitution); err != nil {
+			return nil, err
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        UPDATE assembly_substitutions
+        SET status = $1, decided_at = $2
+        WHERE id = $3
+    `, status, time.Now(), substitutionID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to update substitution: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = bumpApplicationVersion(ctx, tx, assemblyApplicationID, version); err != nil {
+		return nil, err
+	}
+
+	application, err = r.fetchAssemblyApplication(ctx, tx, assemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// lockOpenApplication locks the application row and returns its version.
+// Complete and canceled applications fail with ErrAssemblyApplicationClosed.
+func lockOpenApplication(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, expectedVersion int) (int, error) {
+	var (
+		status  models.AssemblyStatus
+		version int
+	)
+	err := tx.QueryRowContext(ctx, `
+        SELECT status, version
+        FROM assembly_applications
+        WHERE id = $1
+        FOR UPDATE
+    `, assemblyApplicationID).Scan(&status, &version)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to lock assembly application: %v", ErrDatabaseOperation, err)
+	}
+
+	if err := checkVersion("assembly application", assemblyApplicationID, expectedVersion, version); err != nil {
+		return 0, err
+	}
+
+	if status.IsClosed() {
+		return 0, fmt.Errorf("%w: assembly application %s is %s", ErrAssemblyApplicationClosed, assemblyApplicationID, status)
+	}
+
+	return version, nil
+}
+
+func bumpApplicationVersion(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, version int) error {
+	result, err := tx.ExecContext(ctx, `
+        UPDATE assembly_applications
+        SET version = version + 1
+        WHERE id = $1 AND version = $2
+    `, assemblyApplicationID, version)
+	if err != nil {
+		return fmt.Errorf("%w: failed to update assembly application: %v", ErrDatabaseOperation, err)
+	}
+	return checkRowUpdated(result, "assembly application", assemblyApplicationID, version)
+}
+
+// checkSubstitutable checks that the application requests enough units of the replaced product
+// and does not have the substitute among its lines yet.
+func checkSubstitutable(ctx context.Context, tx *sql.Tx, assemblyApplicationID string, substitution models.Substitution) error {
+	var requested, substituteLines int
+	err := tx.QueryRowContext(ctx, `
+        SELECT
+            COALESCE(SUM(requested_quantity) FILTER (WHERE product_id = $2), 0),
+            COUNT(*) FILTER (WHERE product_id = $3)
+        FROM assembly_items
+        WHERE assembly_application_id = $1
+    `, assemblyApplicationID, substitution.ProductID, substitution.SubstituteProductID).Scan(&requested, &substituteLines)
+	if err != nil {
+		return fmt.Errorf("%w: failed to fetch assembly items: %v", ErrDatabaseOperation, err)
+	}
+
+	if requested < substitution.Quantity {
+		return fmt.Errorf("%w: %d of product %s requested in assembly application %s, cannot substitute %d",
+			ErrInvalidInput, requested, substitution.ProductID, assemblyApplicationID, substitution.Quantity)
+	}
+	if substituteLines > 0 {
+		return fmt.Errorf("%w: product %s is already in assembly application %s",
+			ErrInvalidInput, substitution.SubstituteProductID, assemblyApplicationID)
+	}
+
+	return nil
+}
+
+// applySubstitution moves the substituted units from the replaced line to a new substitute line
+// in the order and in the application and recomputes the order total.
+func applySubstitution(ctx context.Context, tx *sql.Tx, orderID, assemblyApplicationID string, substitution models.Substitution) error {
+	// Позиции могли измениться с момента предложения, проверяем еще раз
+	if err := checkSubstitutable(ctx, tx, assemblyApplicationID, substitution); err != nil {
+		return err
+	}
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		{`UPDATE order_items SET quantity = quantity - $1 WHERE order_id = $2 AND product_id = $3`,
+			[]interface{}{substitution.Quantity, orderID, substitution.ProductID}},
+		{`DELETE FROM order_items WHERE order_id = $1 AND quantity <= 0`,
+			[]interface{}{orderID}},
+		{`INSERT INTO order_items (order_id, product_id, quantity, price) VALUES ($1, $2, $3, $4)`,
+			[]interface{}{orderID, substitution.SubstituteProductID, substitution.Quantity, substitution.Price}},
+		{`UPDATE assembly_items SET requested_quantity = requested_quantity - $1 WHERE assembly_application_id = $2 AND product_id = $3`,
+			[]interface{}{substitution.Quantity, assemblyApplicationID, substitution.ProductID}},
+		{`DELETE FROM assembly_items WHERE assembly_application_id = $1 AND requested_quantity <= 0`,
+			[]interface{}{assemblyApplicationID}},
+		{`INSERT INTO assembly_items (assembly_application_id, product_id, requested_quantity) VALUES ($1, $2, $3)`,
+			[]interface{}{assemblyApplicationID, substitution.SubstituteProductID, substitution.Quantity}},
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.ExecContext(ctx, statement.query, statement.args...); err != nil {
+			return fmt.Errorf("%w: failed to apply substitution: %v", ErrDatabaseOperation, err)
+		}
+	}
+
+	return updateLockedRow(ctx, tx, "orders", "order", orderID,
+		`total_amount = (SELECT COALESCE(SUM(price * quantity), 0) FROM order_items WHERE order_id = $1)`)
+}
+
+// checkNoPendingSubstitutions fails with ErrSubstitutionPending while the customer has not decided on a proposal.
+func checkNoPendingSubstitutions(ctx context.Context, tx *sql.Tx, assemblyApplicationID string) error {
+	var pending int
+	err := tx.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM assembly_substitutions
+        WHERE assembly_application_id = $1 AND status = $2
+    `, assemblyApplicationID, models.SubstitutionStatusProposed).Scan(&pending)
+	if err != nil {
+		return fmt.Errorf("%w: failed to check pending substitutions: %v", ErrDatabaseOperation, err)
+	}
+	if pending > 0 {
+		return fmt.Errorf("%w: %d substitutions of assembly application %s wait for the customer",
+			ErrSubstitutionPending, pending, assemblyApplicationID)
+	}
+	return nil
+}
+
+// rejectPendingSubstitutions rejects the proposals left when the application is canceled.
+func rejectPendingSubstitutions(ctx context.Context, tx *sql.Tx, assemblyApplicationID string) error {
+	_, err := tx.ExecContext(ctx, `
+        UPDATE assembly_substitutions
+        SET status = $1, decided_at = $2
+        WHERE assembly_application_id = $3 AND status = $4
+    `, models.SubstitutionStatusRejected, time.Now(), assemblyApplicationID, models.SubstitutionStatusProposed)
+	if err != nil {
+		return fmt.Errorf("%w: failed to reject pending substitutions: %v", ErrDatabaseOperation, err)
+	}
+	return nil
+}
+
+// fetchSubstitutions returns the substitutions of an application in the order they were proposed.
+func fetchSubstitutions(ctx context.Context, q querier, assemblyApplicationID string) ([]models.Substitution, error) {
+	rows, err := q.QueryContext(ctx, `
+        SELECT id, product_id, substitute_product_id, quantity, price, status, proposed_at, decided_at
+        FROM assembly_substitutions
+        WHERE assembly_application_id = $1
+        ORDER BY proposed_at, id
+    `, assemblyApplicationID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch substitutions: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	var substitutions []models.Substitution
+	for rows.Next() {
+		var substitution models.Substitution
+		err := rows.Scan(
+			&substitution.ID,
+			&substitution.ProductID,
+			&substitution.SubstituteProductID,
+			&substitution.Quantity,
+			&substitution.Price,
+			&substitution.Status,
+			&substitution.ProposedAt,
+			&substitution.DecidedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to scan substitution: %v", ErrDatabaseOperation, err)
+		}
+		substitutions = append(substitutions, substitution)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return substitutions, nil
+}
@@ -0,0 +1,557 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// DeliveryEventFunc builds the outbox message for a delivery application
+// inside the transaction that changed it.
+type DeliveryEventFunc func(application *models.DeliveryApplication) (*OutboxMessage, error)
+
+// DeliveryApplicationRepository stores the deliveries of assembled orders. Changes made by a courier
+// fail with ErrDeliveryApplicationAssigned when the application is assigned to another courier.
+// A non-zero expectedVersion that differs from the current version fails with ErrVersionConflict.
+type DeliveryApplicationRepository interface {
+	// Create passes the assembled order to delivery with the packages of its assembly application.
+	// If the order already has an open delivery application, it is returned and no event is written.
+	Create(ctx context.Context, orderID string, event DeliveryEventFunc) (*models.DeliveryApplication, error)
+	Get(ctx context.Context, deliveryApplicationID string) (*models.DeliveryApplication, error)
+	ListByOrder(ctx context.Context, orderID string) ([]models.DeliveryApplication, error)
+	ListCourierApplications(ctx context.Context, courierID string) ([]models.DeliveryApplication, error)
+
+	Assign(ctx context.Context, deliveryApplicationID, courierID string, expectedVersion int, event DeliveryEventFunc) (*models.DeliveryApplication, error)
+	Release(ctx context.Context, deliveryApplicationID, courierID string, expectedVersion int, event DeliveryEventFunc) (*models.DeliveryApplication, error)
+	PickUp(ctx context.Context, deliveryApplicationID, courierID string, expectedVersion int, event DeliveryEventFunc) (*models.DeliveryApplication, error)
+	// Deliver closes the application with the proof of delivery and moves the order to DELIVERED.
+	Deliver(
+		ctx context.Context,
+		deliveryApplicationID string,
+		courierID string,
+		proof models.ProofOfDelivery,
+		expectedVersion int,
+		event DeliveryEventFunc,
+	) (*models.DeliveryApplication, error)
+	// Fail and Cancel return the order to ASSEMBLED unless it was already canceled.
+	Fail(
+		ctx context.Context,
+		deliveryApplicationID string,
+		courierID string,
+		reason string,
+		expectedVersion int,
+		event DeliveryEventFunc,
+	) (*models.DeliveryApplication, error)
+	Cancel(ctx context.Context, deliveryApplicationID string, expectedVersion int, event DeliveryEventFunc) (*models.DeliveryApplication, error)
+}
+
+type deliveryRepository struct {
+	db *sql.DB
+}
+
+func NewDeliveryApplicationRepository(db *sql.DB) (DeliveryApplicationRepository, error) {
+	if db == nil {
+		return nil, fmt.Errorf("%w: database connection is required", ErrInvalidInput)
+	}
+
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &deliveryRepository{db: db}, nil
+}
+
+func (r *deliveryRepository) Create(ctx context.Context, orderID string, event DeliveryEventFunc) (application *models.DeliveryApplication, err error) {
+	if orderID == "" {
+		return nil, fmt.Errorf("%w: order ID is required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	// Повторный запрос после таймаута не должен падать: заявка уже создана
+	var openID string
+	err = tx.QueryRowContext(ctx, `
+        SELECT id
+        FROM delivery_applications
+        WHERE order_id = $1 AND status IN ($2, $3, $4)
+    `, orderID, models.DeliveryStatusCreated, models.DeliveryStatusAssigned, models.DeliveryStatusPickedUp).Scan(&openID)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: failed to fetch open delivery application: %v", ErrDatabaseOperation, err)
+	}
+	if err == nil {
+		application, err = r.fetchDeliveryApplication(ctx, tx, openID)
+		if err != nil {
+			return nil, err
+		}
+		return application, tx.Commit()
+	}
+
+	if _, err = changeOrderStatus(ctx, tx, orderID, models.OrderStatusPassedToDelivery, 0); err != nil {
+		return nil, err
+	}
+
+	application = &models.DeliveryApplication{
+		ID:        uuid.New().String(),
+		OrderID:   orderID,
+		Status:    models.DeliveryStatusCreated,
+		CreatedAt: time.Now(),
+		Version:   1,
+	}
+	err = tx.QueryRowContext(ctx, `SELECT assembly_application_id FROM orders WHERE id = $1`, orderID).
+		Scan(&application.AssemblyApplicationID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch assembly application of order: %v", ErrDatabaseOperation, err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO delivery_applications (id, order_id, assembly_application_id, status, created_at, version)
+        VALUES ($1, $2, $3, $4, $5, 1)
+    `, application.ID, application.OrderID, application.AssemblyApplicationID, application.Status, application.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create delivery application: %v", ErrDatabaseOperation, err)
+	}
+
+	err = insertStatusHistory(ctx, tx, orderID, HistoryEntityDeliveryApplication, application.ID, "", string(application.Status))
+	if err != nil {
+		return nil, err
+	}
+
+	application.Packages, err = fetchPackages(ctx, tx, application.AssemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// Get returns the application with the packages handed over to the courier.
+func (r *deliveryRepository) Get(ctx context.Context, deliveryApplicationID string) (*models.DeliveryApplication, error) {
+	if deliveryApplicationID == "" {
+		return nil, fmt.Errorf("%w: delivery application ID is required", ErrInvalidInput)
+	}
+
+	return r.fetchDeliveryApplication(ctx, r.db, deliveryApplicationID)
+}
+
+// ListByOrder returns every delivery attempt of the order, the first one first.
+func (r *deliveryRepository) ListByOrder(ctx context.Context, orderID string) ([]models.DeliveryApplication, error) {
+	if orderID == "" {
+		return nil, fmt.Errorf("%w: order ID is required", ErrInvalidInput)
+	}
+
+	applications, err := r.listApplications(ctx, `
+        SELECT `+deliveryApplicationColumns+`
+        FROM delivery_applications
+        WHERE order_id = $1
+        ORDER BY created_at, id
+    `, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Пустой список - либо заказ еще не передавали в доставку, либо его нет
+	if len(applications) == 0 {
+		var exists bool
+		err = r.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM orders WHERE id = $1)`, orderID).Scan(&exists)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to fetch order: %v", ErrDatabaseOperation, err)
+		}
+		if !exists {
+			return nil, fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+		}
+	}
+
+	return applications, nil
+}
+
+// ListCourierApplications returns the applications the courier has taken and not closed yet.
+func (r *deliveryRepository) ListCourierApplications(ctx context.Context, courierID string) ([]models.DeliveryApplication, error) {
+	if courierID == "" {
+		return nil, fmt.Errorf("%w: courier ID is required", ErrInvalidInput)
+	}
+
+	return r.listApplications(ctx, `
+        SELECT `+deliveryApplicationColumns+`
+        FROM delivery_applications
+        WHERE courier_id = $1 AND status IN ($2, $3)
+        ORDER BY assigned_at, id
+    `, courierID, models.DeliveryStatusAssigned, models.DeliveryStatusPickedUp)
+}
+
+// Assign gives the application to the courier. Assigning an application the courier already holds is a no-op.
+func (r *deliveryRepository) Assign(
+	ctx context.Context,
+	deliveryApplicationID string,
+	courierID string,
+	expectedVersion int,
+	event DeliveryEventFunc,
+) (*models.DeliveryApplication, error) {
+	if courierID == "" {
+		return nil, fmt.Errorf("%w: courier ID is required", ErrInvalidInput)
+	}
+
+	return r.changeDelivery(ctx, deliveryApplicationID, expectedVersion, deliveryChange{
+		to: models.DeliveryStatusAssigned,
+		check: func(application *models.DeliveryApplication) (bool, error) {
+			switch {
+			case application.CourierID == courierID && application.Status == models.DeliveryStatusAssigned:
+				// Повторное назначение того же курьера ничего не меняет
+				return false, nil
+			case application.CourierID != "":
+				return false, fmt.Errorf("%w: delivery application %s is assigned to %s",
+					ErrDeliveryApplicationAssigned, deliveryApplicationID, application.CourierID)
+			}
+			return true, nil
+		},
+		update: func(ctx context.Context, tx *sql.Tx) error {
+			return updateLockedRow(ctx, tx, "delivery_applications", "delivery application", deliveryApplicationID,
+				`courier_id = $3, assigned_at = $4`, courierID, time.Now())
+		},
+	}, event)
+}
+
+// Release returns the application the courier has not picked up yet to the unassigned ones.
+func (r *deliveryRepository) Release(
+	ctx context.Context,
+	deliveryApplicationID string,
+	courierID string,
+	expectedVersion int,
+	event DeliveryEventFunc,
+) (*models.DeliveryApplication, error) {
+	return r.changeDelivery(ctx, deliveryApplicationID, expectedVersion, deliveryChange{
+		to:    models.DeliveryStatusCreated,
+		check: courierCheck(deliveryApplicationID, courierID),
+		update: func(ctx context.Context, tx *sql.Tx) error {
+			return updateLockedRow(ctx, tx, "delivery_applications", "delivery application", deliveryApplicationID,
+				`courier_id = NULL, assigned_at = NULL`)
+		},
+	}, event)
+}
+
+// PickUp records that the courier took the packages from the warehouse.
+func (r *deliveryRepository) PickUp(
+	ctx context.Context,
+	deliveryApplicationID string,
+	courierID string,
+	expectedVersion int,
+	event DeliveryEventFunc,
+) (*models.DeliveryApplication, error) {
+	return r.changeDelivery(ctx, deliveryApplicationID, expectedVersion, deliveryChange{
+		to:    models.DeliveryStatusPickedUp,
+		check: courierCheck(deliveryApplicationID, courierID),
+		update: func(ctx context.Context, tx *sql.Tx) error {
+			return updateLockedRow(ctx, tx, "delivery_applications", "delivery application", deliveryApplicationID,
+				`picked_up_at = $3`, time.Now())
+		},
+	}, event)
+}
+
+func (r *deliveryRepository) Deliver(
+	ctx context.Context,
+	deliveryApplicationID string,
+	courierID string,
+	proof models.ProofOfDelivery,
+	expectedVersion int,
+	event DeliveryEventFunc,
+) (*models.DeliveryApplication, error) {
+	return r.changeDelivery(ctx, deliveryApplicationID, expectedVersion, deliveryChange{
+		to:    models.DeliveryStatusDelivered,
+		check: courierCheck(deliveryApplicationID, courierID),
+		update: func(ctx context.Context, tx *sql.Tx) error {
+			return updateLockedRow(ctx, tx, "delivery_applications", "delivery application", deliveryApplicationID,
+				`delivered_at = $3, proof_photo_ref = $4, proof_signature_ref = NULLIF($5, '')`,
+				time.Now(), proof.PhotoRef, proof.SignatureRef)
+		},
+		order: func(ctx context.Context, tx *sql.Tx, orderID string) error {
+			_, err := changeOrderStatus(ctx, tx, orderID, models.OrderStatusDelivered, 0)
+			return err
+		},
+	}, event)
+}
+
+func (r *deliveryRepository) Fail(
+	ctx context.Context,
+	deliveryApplicationID string,
+	courierID string,
+	reason string,
+	expectedVersion int,
+	event DeliveryEventFunc,
+) (*models.DeliveryApplication, error) {
+	return r.changeDelivery(ctx, deliveryApplicationID, expectedVersion, deliveryChange{
+		to:    models.DeliveryStatusFailed,
+		check: courierCheck(deliveryApplicationID, courierID),
+		update: func(ctx context.Context, tx *sql.Tx) error {
+			return updateLockedRow(ctx, tx, "delivery_applications", "delivery application", deliveryApplicationID,
+				`failure_reason = $3`, reason)
+		},
+		order: returnOrderFromDelivery,
+	}, event)
+}
+
+func (r *deliveryRepository) Cancel(
+	ctx context.Context,
+	deliveryApplicationID string,
+	expectedVersion int,
+	event DeliveryEventFunc,
+) (*models.DeliveryApplication, error) {
+	return r.changeDelivery(ctx, deliveryApplicationID, expectedVersion, deliveryChange{
+		to:    models.DeliveryStatusCanceled,
+		order: returnOrderFromDelivery,
+	}, event)
+}
+
+// deliveryChange describes a status change of a delivery application. check decides whether the change
+// applies to the current application, update saves the columns of the new status and order changes
+// the order accordingly. All of them are optional.
+type deliveryChange struct {
+	to     models.DeliveryStatus
+	check  func(application *models.DeliveryApplication) (apply bool, err error)
+	update func(ctx context.Context, tx *sql.Tx) error
+	order  func(ctx context.Context, tx *sql.Tx, orderID string) error
+}
+
+// changeDelivery applies change in a single transaction and writes the event of the changed application.
+// A change that check skipped returns the application as it is, without the event.
+func (r *deliveryRepository) changeDelivery(
+	ctx context.Context,
+	deliveryApplicationID string,
+	expectedVersion int,
+	change deliveryChange,
+	event DeliveryEventFunc,
+) (application *models.DeliveryApplication, err error) {
+	if deliveryApplicationID == "" {
+		return nil, fmt.Errorf("%w: delivery application ID is required", ErrInvalidInput)
+	}
+
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	orderID, err := r.fetchDeliveryOrderID(ctx, tx, deliveryApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Сначала блокируем заказ, затем заявку - тот же порядок, что и в Create. Заявку меняют только
+	// под блокировкой заказа, поэтому прочитанную ниже заявку до конца транзакции никто не изменит
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+	application, err = r.fetchDeliveryApplication(ctx, tx, deliveryApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if change.check != nil {
+		var apply bool
+		if apply, err = change.check(application); err != nil {
+			return nil, err
+		}
+		if !apply {
+			return application, tx.Commit()
+		}
+	}
+
+	if _, err = changeDeliveryStatus(ctx, tx, deliveryApplicationID, change.to, expectedVersion); err != nil {
+		return nil, err
+	}
+
+	if change.update != nil {
+		if err = change.update(ctx, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	if change.order != nil {
+		if err = change.order(ctx, tx, orderID); err != nil {
+			return nil, err
+		}
+	}
+
+	application, err = r.fetchDeliveryApplication(ctx, tx, deliveryApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = r.writeEvent(ctx, tx, application, event); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
+// courierCheck makes sure the application is assigned to the courier.
+func courierCheck(deliveryApplicationID, courierID string) func(application *models.DeliveryApplication) (bool, error) {
+	return func(application *models.DeliveryApplication) (bool, error) {
+		if courierID == "" || application.CourierID != courierID {
+			return false, fmt.Errorf("%w: delivery application %s is not assigned to courier %q",
+				ErrDeliveryApplicationAssigned, deliveryApplicationID, courierID)
+		}
+		return true, nil
+	}
+}
+
+// returnOrderFromDelivery moves the order of a failed or canceled delivery back to ASSEMBLED, its packages
+// are back at the warehouse. An order that is no longer passed to delivery, e.g. canceled by the customer,
+// is left as it is.
+func returnOrderFromDelivery(ctx context.Context, tx *sql.Tx, orderID string) error {
+	var status models.OrderStatus
+	err := tx.QueryRowContext(ctx, `SELECT status FROM orders WHERE id = $1`, orderID).Scan(&status)
+	if err != nil {
+		return fmt.Errorf("%w: failed to fetch order status: %v", ErrDatabaseOperation, err)
+	}
+	if status != models.OrderStatusPassedToDelivery {
+		return nil
+	}
+
+	_, err = changeOrderStatus(ctx, tx, orderID, models.OrderStatusAssembled, 0)
+	return err
+}
+
+// Вспомогательные методы
+
+func (r *deliveryRepository) rollbackOnError(tx *sql.Tx, err *error) {
+	if *err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			*err = fmt.Errorf("rollback failed: %v, original error: %w", rbErr, *err)
+		}
+	}
+}
+
+func (r *deliveryRepository) writeEvent(ctx context.Context, tx *sql.Tx, application *models.DeliveryApplication, event DeliveryEventFunc) error {
+	if event == nil {
+		return nil
+	}
+
+	message, err := event(application)
+	if err != nil {
+		return fmt.Errorf("%w: failed to build delivery event: %v", ErrInvalidInput, err)
+	}
+
+	return insertOutboxMessages(ctx, tx, message)
+}
+
+func (r *deliveryRepository) fetchDeliveryOrderID(ctx context.Context, tx *sql.Tx, deliveryApplicationID string) (string, error) {
+	var orderID string
+	err := tx.QueryRowContext(ctx, `SELECT order_id FROM delivery_applications WHERE id = $1`, deliveryApplicationID).Scan(&orderID)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: ID %s", ErrDeliveryApplicationNotFound, deliveryApplicationID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to fetch delivery application: %v", ErrDatabaseOperation, err)
+	}
+	return orderID, nil
+}
+
+// deliveryApplicationColumns are the columns read by scanDeliveryApplication.
+const deliveryApplicationColumns = `
+    id, order_id, assembly_application_id, status, created_at, version, COALESCE(courier_id, ''),
+    assigned_at, picked_up_at, delivered_at, closed_at, COALESCE(failure_reason, ''),
+    COALESCE(proof_photo_ref, ''), COALESCE(proof_signature_ref, '')`
+
+func scanDeliveryApplication(row rowScanner) (*models.DeliveryApplication, error) {
+	var (
+		application  = &models.DeliveryApplication{}
+		photoRef     string
+		signatureRef string
+	)
+	err := row.Scan(
+		&application.ID,
+		&application.OrderID,
+		&application.AssemblyApplicationID,
+		&application.Status,
+		&application.CreatedAt,
+		&application.Version,
+		&application.CourierID,
+		&application.AssignedAt,
+		&application.PickedUpAt,
+		&application.DeliveredAt,
+		&application.ClosedAt,
+		&application.FailureReason,
+		&photoRef,
+		&signatureRef,
+	)
+	if photoRef != "" {
+		application.ProofOfDelivery = &models.ProofOfDelivery{PhotoRef: photoRef, SignatureRef: signatureRef}
+	}
+	return application, err
+}
+
+func (r *deliveryRepository) fetchDeliveryApplication(ctx context.Context, q querier, deliveryApplicationID string) (*models.DeliveryApplication, error) {
+	application, err := scanDeliveryApplication(q.QueryRowContext(ctx, `
+        SELECT `+deliveryApplicationColumns+`
+        FROM delivery_applications
+        WHERE id = $1
+    `, deliveryApplicationID))
+
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: ID %s", ErrDeliveryApplicationNotFound, deliveryApplicationID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch delivery application: %v", ErrDatabaseOperation, err)
+	}
+
+	application.Packages, err = fetchPackages(ctx, q, application.AssemblyApplicationID)
+	if err != nil {
+		return nil, err
+	}
+
+	return application, nil
+}
+
+// listApplications runs a query selecting deliveryApplicationColumns and loads the packages of the found applications.
+func (r *deliveryRepository) listApplications(ctx context.Context, query string, args ...interface{}) ([]models.DeliveryApplication, error) {
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list delivery applications: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	applications := []models.DeliveryApplication{}
+	for rows.Next() {
+		application, err := scanDeliveryApplication(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to scan delivery application: %v", ErrDatabaseOperation, err)
+		}
+		applications = append(applications, *application)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	for i := range applications {
+		applications[i].Packages, err = fetchPackages(ctx, r.db, applications[i].AssemblyApplicationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return applications, nil
+}
@@ -0,0 +1,301 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// DeliverySlotRepository stores the delivery windows of the zones and the reservations of their places.
+// Reservations are compared with the database clock, so oms-core instances agree on what is expired.
+type DeliverySlotRepository struct {
+	db *sql.DB
+}
+
+func NewDeliverySlotRepository(db *sql.DB) (*DeliverySlotRepository, error) {
+	if db == nil {
+		return nil, fmt.Errorf("%w: database connection is required", ErrInvalidInput)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &DeliverySlotRepository{db: db}, nil
+}
+
+// deliverySlotColumns are the columns read by scanDeliverySlot. A place is taken by a confirmed reservation
+// and by a reservation that has not expired yet, even if the expirer has not marked it EXPIRED.
+const deliverySlotColumns = `
+    s.id, s.zone, s.starts_at, s.ends_at, s.capacity,
+    (
+        SELECT COUNT(*)
+        FROM delivery_slot_reservations r
+        WHERE r.slot_id = s.id
+          AND (r.status = 'CONFIRMED' OR (r.status = 'RESERVED' AND r.expires_at > CURRENT_TIMESTAMP))
+    )`
+
+func scanDeliverySlot(row rowScanner) (*models.DeliverySlot, error) {
+	slot := &models.DeliverySlot{}
+	err := row.Scan(&slot.ID, &slot.Zone, &slot.StartsAt, &slot.EndsAt, &slot.Capacity, &slot.Booked)
+	return slot, err
+}
+
+// CreateSlot adds a delivery window to the zone. A zone has one slot per start time,
+// another one fails with ErrDeliverySlotExists.
+func (r *DeliverySlotRepository) CreateSlot(ctx context.Context, slot *models.DeliverySlot) error {
+	slot.ID = uuid.New().String()
+	_, err := r.db.ExecContext(ctx, `
+        INSERT INTO delivery_slots (id, zone, starts_at, ends_at, capacity)
+        VALUES ($1, $2, $3, $4, $5)
+    `, slot.ID, slot.Zone, slot.StartsAt, slot.EndsAt, slot.Capacity)
+	if pqErr, ok := err.(*pq.Error); ok && pqErr.Code.Name() == "unique_violation" {
+		return fmt.Errorf("%w: zone %s at %s", ErrDeliverySlotExists, slot.Zone, slot.StartsAt.Format(time.RFC3339))
+	}
+	if err != nil {
+		return fmt.Errorf("%w: failed to create delivery slot: %v", ErrDatabaseOperation, err)
+	}
+
+	return nil
+}
+
+func (r *DeliverySlotRepository) GetSlot(ctx context.Context, slotID string) (*models.DeliverySlot, error) {
+	return fetchDeliverySlot(ctx, r.db, slotID)
+}
+
+// ListSlots returns the slots of the zone starting in [from, to), the earliest first.
+func (r *DeliverySlotRepository) ListSlots(ctx context.Context, zone string, from, to time.Time) ([]models.DeliverySlot, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT `+deliverySlotColumns+`
+        FROM delivery_slots s
+        WHERE s.zone = $1 AND s.starts_at >= $2 AND s.starts_at < $3
+        ORDER BY s.starts_at
+    `, zone, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list delivery slots: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	slots := []models.DeliverySlot{}
+	for rows.Next() {
+		slot, err := scanDeliverySlot(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to scan delivery slot: %v", ErrDatabaseOperation, err)
+		}
+		slots = append(slots, *slot)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return slots, nil
+}
+
+// Reserve takes a place in the slot until ttl passes. A slot that has started or has no free places
+// fails with ErrDeliverySlotUnavailable.
+func (r *DeliverySlotRepository) Reserve(ctx context.Context, slotID string, ttl time.Duration) (reservation *models.SlotReservation, err error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	// Блокировка окна упорядочивает параллельные брони, иначе обе увидят последнее свободное место
+	var started bool
+	err = tx.QueryRowContext(ctx, `
+        SELECT starts_at <= CURRENT_TIMESTAMP
+        FROM delivery_slots
+        WHERE id = $1
+        FOR UPDATE
+    `, slotID).Scan(&started)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: ID %s", ErrDeliverySlotNotFound, slotID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to lock delivery slot: %v", ErrDatabaseOperation, err)
+	}
+	if started {
+		return nil, fmt.Errorf("%w: slot %s has already started", ErrDeliverySlotUnavailable, slotID)
+	}
+
+	slot, err := fetchDeliverySlot(ctx, tx, slotID)
+	if err != nil {
+		return nil, err
+	}
+	if slot.Available() == 0 {
+		return nil, fmt.Errorf("%w: slot %s is fully booked", ErrDeliverySlotUnavailable, slotID)
+	}
+
+	reservation = &models.SlotReservation{
+		ID:     uuid.New().String(),
+		SlotID: slotID,
+		Status: models.SlotReservationStatusReserved,
+	}
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO delivery_slot_reservations (id, slot_id, status, created_at, expires_at)
+        VALUES ($1, $2, $3, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP + make_interval(secs => $4))
+        RETURNING created_at, expires_at
+    `, reservation.ID, reservation.SlotID, reservation.Status, ttl.Seconds()).Scan(&reservation.CreatedAt, &reservation.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to reserve delivery slot: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	slot.Booked++
+	reservation.Slot = slot
+	return reservation, nil
+}
+
+func (r *DeliverySlotRepository) GetReservation(ctx context.Context, reservationID string) (*models.SlotReservation, error) {
+	reservation, err := fetchSlotReservation(ctx, r.db, reservationID)
+	if err != nil {
+		return nil, err
+	}
+
+	reservation.Slot, err = fetchDeliverySlot(ctx, r.db, reservation.SlotID)
+	if err != nil {
+		return nil, err
+	}
+
+	return reservation, nil
+}
+
+// Release gives the place back, e.g. when the customer leaves the checkout. Releasing a reservation
+// that is already released or expired changes nothing, a confirmed one fails with ErrSlotReservationClosed:
+// its place is released by the cancellation of the order.
+func (r *DeliverySlotRepository) Release(ctx context.Context, reservationID string) (*models.SlotReservation, error) {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE delivery_slot_reservations
+        SET status = $2, closed_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = $3
+    `, reservationID, models.SlotReservationStatusReleased, models.SlotReservationStatusReserved)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to release slot reservation: %v", ErrDatabaseOperation, err)
+	}
+
+	reservation, err := r.GetReservation(ctx, reservationID)
+	if err != nil {
+		return nil, err
+	}
+	if reservation.Status == models.SlotReservationStatusConfirmed {
+		return nil, fmt.Errorf("%w: reservation %s is confirmed by order %s", ErrSlotReservationClosed, reservationID, reservation.OrderID)
+	}
+
+	return reservation, nil
+}
+
+// ExpireReservations marks the reservations that were not confirmed in time EXPIRED
+// and returns how many were marked.
+func (r *DeliverySlotRepository) ExpireReservations(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `
+        UPDATE delivery_slot_reservations
+        SET status = $1, closed_at = CURRENT_TIMESTAMP
+        WHERE status = $2 AND expires_at <= CURRENT_TIMESTAMP
+    `, models.SlotReservationStatusExpired, models.SlotReservationStatusReserved)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to expire slot reservations: %v", ErrDatabaseOperation, err)
+	}
+
+	return result.RowsAffected()
+}
+
+// Вспомогательные методы
+
+func (r *DeliverySlotRepository) rollbackOnError(tx *sql.Tx, err *error) {
+	if *err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			*err = fmt.Errorf("rollback failed: %v, original error: %w", rbErr, *err)
+		}
+	}
+}
+
+func fetchDeliverySlot(ctx context.Context, q querier, slotID string) (*models.DeliverySlot, error) {
+	slot, err := scanDeliverySlot(q.QueryRowContext(ctx, `
+        SELECT `+deliverySlotColumns+`
+        FROM delivery_slots s
+        WHERE s.id = $1
+    `, slotID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: ID %s", ErrDeliverySlotNotFound, slotID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch delivery slot: %v", ErrDatabaseOperation, err)
+	}
+
+	return slot, nil
+}
+
+func fetchSlotReservation(ctx context.Context, q querier, reservationID string) (*models.SlotReservation, error) {
+	reservation := &models.SlotReservation{}
+	err := q.QueryRowContext(ctx, `
+        SELECT id, slot_id, status, COALESCE(order_id, ''), created_at, expires_at, confirmed_at
+        FROM delivery_slot_reservations
+        WHERE id = $1
+    `, reservationID).Scan(
+		&reservation.ID,
+		&reservation.SlotID,
+		&reservation.Status,
+		&reservation.OrderID,
+		&reservation.CreatedAt,
+		&reservation.ExpiresAt,
+		&reservation.ConfirmedAt,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: ID %s", ErrSlotReservationNotFound, reservationID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch slot reservation: %v", ErrDatabaseOperation, err)
+	}
+
+	return reservation, nil
+}
+
+// confirmSlotReservation gives the reserved place to the order being created and returns the slot.
+// A reservation that expired, was released or confirmed by another order fails with ErrSlotReservationClosed.
+func confirmSlotReservation(ctx context.Context, tx *sql.Tx, reservationID, orderID string) (string, error) {
+	var slotID string
+	err := tx.QueryRowContext(ctx, `
+        UPDATE delivery_slot_reservations
+        SET status = $3, order_id = $2, confirmed_at = CURRENT_TIMESTAMP
+        WHERE id = $1 AND status = $4 AND expires_at > CURRENT_TIMESTAMP
+        RETURNING slot_id
+    `, reservationID, orderID, models.SlotReservationStatusConfirmed, models.SlotReservationStatusReserved).Scan(&slotID)
+	if err == nil {
+		return slotID, nil
+	}
+	if err != sql.ErrNoRows {
+		return "", fmt.Errorf("%w: failed to confirm slot reservation: %v", ErrDatabaseOperation, err)
+	}
+
+	reservation, err := fetchSlotReservation(ctx, tx, reservationID)
+	if err != nil {
+		return "", err
+	}
+	status := reservation.Status
+	if status == models.SlotReservationStatusReserved {
+		// Истекла, но еще не помечена
+		status = models.SlotReservationStatusExpired
+	}
+	return "", fmt.Errorf("%w: reservation %s is %s", ErrSlotReservationClosed, reservationID, status)
+}
+
+// releaseOrderSlot gives the place of the canceled order back to its slot.
+func releaseOrderSlot(ctx context.Context, tx *sql.Tx, orderID string) error {
+	_, err := tx.ExecContext(ctx, `
+        UPDATE delivery_slot_reservations
+        SET status = $2, closed_at = CURRENT_TIMESTAMP
+        WHERE order_id = $1 AND status = $3
+    `, orderID, models.SlotReservationStatusReleased, models.SlotReservationStatusConfirmed)
+	if err != nil {
+		return fmt.Errorf("%w: failed to release delivery slot of order: %v", ErrDatabaseOperation, err)
+	}
+	return nil
+}
@@ -1,10 +1,78 @@
 package repository
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	ErrOrderNotFound               = errors.New("order not found")
 	ErrAssemblyApplicationNotFound = errors.New("assembly application not found")
+	ErrOrderItemNotFound           = errors.New("order item not found")
 	ErrInvalidInput                = errors.New("invalid input parameters")
 	ErrDatabaseOperation           = errors.New("database operation failed")
+	ErrInvalidTransition           = errors.New("invalid status transition")
+	ErrIdempotencyKeyReused        = errors.New("idempotency key was already used with a different request")
+	ErrVersionConflict             = errors.New("version conflict")
+	ErrAssemblyApplicationClosed   = errors.New("assembly application is closed")
+	ErrAssemblyApplicationClaimed  = errors.New("assembly application is claimed by another picker")
+	ErrAssemblyApplicationAccepted = errors.New("assembly application is already accepted")
+	ErrAssemblyApplicationPicking  = errors.New("assembly application is already being picked")
+	ErrAssemblyQueueEmpty          = errors.New("assembly queue is empty")
+	ErrSubstitutionNotFound        = errors.New("substitution not found")
+	ErrSubstitutionPending         = errors.New("substitution is waiting for the customer")
+	ErrDeliveryApplicationNotFound = errors.New("delivery application not found")
+	ErrDeliveryApplicationAssigned = errors.New("delivery application is assigned to another courier")
+	ErrDeliverySlotNotFound        = errors.New("delivery slot not found")
+	ErrDeliverySlotExists          = errors.New("delivery slot already exists")
+	ErrDeliverySlotUnavailable     = errors.New("delivery slot is not available")
+	ErrSlotReservationNotFound     = errors.New("slot reservation not found")
+	ErrSlotReservationClosed       = errors.New("slot reservation is no longer active")
+	ErrPaymentNotFound             = errors.New("payment not found")
+	ErrDeadLetterNotFound          = errors.New("dead outbox message not found")
 )
+
+// InvalidTransitionError describes a status change rejected by the state machine.
+// It matches ErrInvalidTransition with errors.Is.
+type InvalidTransitionError struct {
+	Entity string
+	ID     string
+	From   string
+	To     string
+}
+
+func (e *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("%s: %s %s cannot move from %s to %s", ErrInvalidTransition, e.Entity, e.ID, e.From, e.To)
+}
+
+func (e *InvalidTransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// VersionConflictError is returned when a row was changed after the caller read it.
+// It matches ErrVersionConflict with errors.Is.
+type VersionConflictError struct {
+	Entity   string
+	ID       string
+	Expected int
+	Actual   int
+}
+
+func (e *VersionConflictError) Error() string {
+	if e.Actual == 0 {
+		return fmt.Sprintf("%s: %s %s was changed concurrently, expected version %d", ErrVersionConflict, e.Entity, e.ID, e.Expected)
+	}
+	return fmt.Sprintf("%s: %s %s has version %d, expected %d", ErrVersionConflict, e.Entity, e.ID, e.Actual, e.Expected)
+}
+
+func (e *VersionConflictError) Unwrap() error {
+	return ErrVersionConflict
+}
+
+// checkVersion compares the current version of a locked row with the expected one. Zero expected means any version.
+func checkVersion(entity, id string, expected, actual int) error {
+	if expected != 0 && expected != actual {
+		return &VersionConflictError{Entity: entity, ID: id, Expected: expected, Actual: actual}
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const (
+	HistoryEntityOrder               = "order"
+	HistoryEntityAssemblyApplication = "assembly_application"
+	HistoryEntityDeliveryApplication = "delivery_application"
+	HistoryEntityPayment             = "payment"
+
+	// DefaultActor is recorded when the caller did not identify itself.
+	DefaultActor = "system"
+)
+
+// StatusHistoryEntry is a single status change of an order or of its assembly or delivery application or payment.
+// OldStatus is empty for the entry that records creation.
+type StatusHistoryEntry struct {
+	ID        int64     `json:"id"`
+	OrderID   string    `json:"order_id"`
+	Entity    string    `json:"entity"`
+	EntityID  string    `json:"entity_id"`
+	OldStatus string    `json:"old_status,omitempty"`
+	NewStatus string    `json:"new_status"`
+	Actor     string    `json:"actor"`
+	Reason    string    `json:"reason,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// StatusChange describes who changes a status and why. It travels in the request context
+// down to the repositories, which record it in the status history.
+type StatusChange struct {
+	Actor  string
+	Reason string
+}
+
+type statusChangeKey struct{}
+
+// WithStatusChange returns a copy of ctx carrying change.
+func WithStatusChange(ctx context.Context, change StatusChange) context.Context {
+	return context.WithValue(ctx, statusChangeKey{}, change)
+}
+
+// StatusChangeFromContext returns the change stored in ctx, defaulting the actor to DefaultActor.
+func StatusChangeFromContext(ctx context.Context) StatusChange {
+	change, _ := ctx.Value(statusChangeKey{}).(StatusChange)
+	if change.Actor == "" {
+		change.Actor = DefaultActor
+	}
+	return change
+}
+
+func insertStatusHistory(
+	ctx context.Context,
+	tx *sql.Tx,
+	orderID, entity, entityID, oldStatus, newStatus string,
+) error {
+	change := StatusChangeFromContext(ctx)
+
+	_, err := tx.ExecContext(ctx, `
+        INSERT INTO order_status_history (order_id, entity, entity_id, old_status, new_status, actor, reason, created_at)
+        VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, NULLIF($7, ''), $8)
+    `, orderID, entity, entityID, oldStatus, newStatus, change.Actor, change.Reason, time.Now())
+	if err != nil {
+		return fmt.Errorf("%w: failed to insert status history: %v", ErrDatabaseOperation, err)
+	}
+
+	return nil
+}
+
+// GetOrderHistory returns status changes of the order, its assembly and delivery applications and payments in the order they happened.
+func (r *OrderRepository) GetOrderHistory(ctx context.Context, orderID string) ([]StatusHistoryEntry, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM orders WHERE id = $1)`, orderID).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to check order existence: %v", ErrDatabaseOperation, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT id, order_id, entity, entity_id, COALESCE(old_status, ''), new_status, actor, COALESCE(reason, ''), created_at
+        FROM order_status_history
+        WHERE order_id = $1
+        ORDER BY id
+    `, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch status history: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	history := []StatusHistoryEntry{}
+	for rows.Next() {
+		var entry StatusHistoryEntry
+		if err := rows.Scan(
+			&entry.ID,
+			&entry.OrderID,
+			&entry.Entity,
+			&entry.EntityID,
+			&entry.OldStatus,
+			&entry.NewStatus,
+			&entry.Actor,
+			&entry.Reason,
+			&entry.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan status history: %v", ErrDatabaseOperation, err)
+		}
+		history = append(history, entry)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return history, nil
+}
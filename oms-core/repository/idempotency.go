@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// MaxIdempotencyKeyLength matches the key column size.
+const MaxIdempotencyKeyLength = 255
+
+// IdempotencyRecord binds a client supplied Idempotency-Key to the request it was first used with
+// and to the response that was returned for it.
+type IdempotencyRecord struct {
+	Key         string
+	RequestHash string
+	OrderID     string
+	Response    []byte
+	CreatedAt   time.Time
+	ExpiresAt   time.Time
+}
+
+type IdempotencyRepository struct {
+	db *sql.DB
+}
+
+func NewIdempotencyRepository(db *sql.DB) (*IdempotencyRepository, error) {
+	if db == nil {
+		return nil, fmt.Errorf("%w: database connection is required", ErrInvalidInput)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &IdempotencyRepository{db: db}, nil
+}
+
+// DeleteExpired removes keys whose expiration time has passed and returns how many were removed.
+func (r *IdempotencyRepository) DeleteExpired(ctx context.Context) (int64, error) {
+	result, err := r.db.ExecContext(ctx, `DELETE FROM idempotency_keys WHERE expires_at <= $1`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to delete expired idempotency keys: %v", ErrDatabaseOperation, err)
+	}
+
+	return result.RowsAffected()
+}
+
+// claimIdempotencyKey stores record within tx. An expired key is taken over by the new request.
+// If the key is still alive, the stored record is returned instead and nothing is written;
+// a stored record with a different request hash fails with ErrIdempotencyKeyReused.
+func claimIdempotencyKey(ctx context.Context, tx *sql.Tx, record *IdempotencyRecord) (*IdempotencyRecord, error) {
+	if record.Key == "" || len(record.Key) > MaxIdempotencyKeyLength {
+		return nil, fmt.Errorf("%w: idempotency key must be 1 to %d characters long", ErrInvalidInput, MaxIdempotencyKeyLength)
+	}
+
+	// Параллельный запрос с тем же ключом ждет на уникальном индексе и затем видит уже сохраненную запись
+	result, err := tx.ExecContext(ctx, `
+        INSERT INTO idempotency_keys (key, request_hash, order_id, response, created_at, expires_at)
+        VALUES ($1, $2, $3, $4, $5, $6)
+        ON CONFLICT (key) DO UPDATE
+        SET request_hash = EXCLUDED.request_hash,
+            order_id = EXCLUDED.order_id,
+            response = EXCLUDED.response,
+            created_at = EXCLUDED.created_at,
+            expires_at = EXCLUDED.expires_at
+        WHERE idempotency_keys.expires_at <= EXCLUDED.created_at
+    `, record.Key, record.RequestHash, record.OrderID, string(record.Response), record.CreatedAt, record.ExpiresAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to store idempotency key: %v", ErrDatabaseOperation, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to get rows affected: %v", ErrDatabaseOperation, err)
+	}
+	if rows > 0 {
+		return nil, nil
+	}
+
+	stored := &IdempotencyRecord{}
+	err = tx.QueryRowContext(ctx, `
+        SELECT key, request_hash, order_id, response, created_at, expires_at
+        FROM idempotency_keys
+        WHERE key = $1
+    `, record.Key).Scan(
+		&stored.Key,
+		&stored.RequestHash,
+		&stored.OrderID,
+		&stored.Response,
+		&stored.CreatedAt,
+		&stored.ExpiresAt,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch idempotency key: %v", ErrDatabaseOperation, err)
+	}
+
+	if stored.RequestHash != record.RequestHash {
+		return nil, fmt.Errorf("%w: key %s", ErrIdempotencyKeyReused, record.Key)
+	}
+
+	return stored, nil
+}
@@ -26,10 +26,19 @@ func NewOrderRepository(db *sql.DB) (*OrderRepository, error) {
 	return &OrderRepository{db: db}, nil
 }
 
-func (r *OrderRepository) SaveOrder(ctx context.Context, order *models.Order) error {
+// SaveOrder inserts the order with its items and stores the outbox messages in the same transaction.
+// When idempotency is set, its key is claimed in that transaction as well. If the key was already used
+// for the same request, the order is not saved and the stored record is returned.
+// The slot reservation of the order is confirmed in the transaction and order.DeliverySlot is set.
+func (r *OrderRepository) SaveOrder(
+	ctx context.Context,
+	order *models.Order,
+	idempotency *IdempotencyRecord,
+	outbox ...*OutboxMessage,
+) (replay *IdempotencyRecord, err error) {
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer func() {
 		if err != nil {
@@ -38,95 +47,284 @@ func (r *OrderRepository) SaveOrder(ctx context.Context, order *models.Order) er
 			}
 		}
 	}()
+
+	if idempotency != nil {
+		replay, err = claimIdempotencyKey(ctx, tx, idempotency)
+		if err != nil {
+			return nil, err
+		}
+		if replay != nil {
+			// Повтор запроса: ничего не записано, просто закрываем транзакцию
+			return replay, tx.Rollback()
+		}
+	}
+
+	var slotID string
+	if order.DeliverySlotReservationID != "" {
+		slotID, err = confirmSlotReservation(ctx, tx, order.DeliverySlotReservationID, order.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	orderQuery := `
 		INSERT INTO orders (
 			id, 
 			customer_id, 
 			total_amount, 
+			currency,
+			region,
 			status, 
 			created_at,
-			assembly_application_id
+			updated_at,
+			delivery_slot_id,
+			version
 		)
-		VALUES ($1, $2, $3, $4, $5, $6)
+		VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7, $7, NULLIF($8, ''), 1)
 	`
 
 	_, err = tx.ExecContext(ctx, orderQuery,
 		order.ID,
 		order.CustomerID,
 		order.TotalAmount,
+		order.Currency,
+		order.Region,
 		order.Status,
 		order.CreatedAt,
-		order.AssemblyApplicationID,
+		slotID,
 	)
 	if err != nil {
-		return fmt.Errorf("failed to insert order: %w", err)
+		return nil, fmt.Errorf("failed to insert order: %w", err)
+	}
+
+	if slotID != "" {
+		order.DeliverySlot, err = fetchDeliverySlot(ctx, tx, slotID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	if len(order.Items) > 0 {
-		itemQueryBase := `
-			INSERT INTO order_items (
-				order_id, 
-				product_id, 
-				quantity, 
-				price
-			) VALUES `
+	if err = insertOrderItems(ctx, tx, order.ID, order.Items); err != nil {
+		return nil, err
+	}
 
-		valueStrings := make([]string, 0, len(order.Items))
-		valueArgs := make([]interface{}, 0, len(order.Items)*4)
+	if err = insertStatusHistory(ctx, tx, order.ID, HistoryEntityOrder, order.ID, "", string(order.Status)); err != nil {
+		return nil, err
+	}
 
-		for i, item := range order.Items {
-			n := i * 4
-			valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4))
+	if err = insertOutboxMessages(ctx, tx, outbox...); err != nil {
+		return nil, err
+	}
 
-			valueArgs = append(valueArgs, order.ID)
-			valueArgs = append(valueArgs, item.ProductID)
-			valueArgs = append(valueArgs, item.Quantity)
-			valueArgs = append(valueArgs, item.Price)
-		}
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
 
-		itemQuery := itemQueryBase + strings.Join(valueStrings, ",")
+	return nil, nil
+}
 
-		_, err = tx.ExecContext(ctx, itemQuery, valueArgs...)
+func insertOrderItems(ctx context.Context, tx *sql.Tx, orderID string, items []models.OrderItem) error {
+	if len(items) == 0 {
+		return nil
+	}
+
+	itemQueryBase := `
+		INSERT INTO order_items (
+			order_id, 
+			product_id, 
+			quantity, 
+			price
+		) VALUES `
+
+	valueStrings := make([]string, 0, len(items))
+	valueArgs := make([]interface{}, 0, len(items)*4)
+
+	for i, item := range items {
+		n := i * 4
+		valueStrings = append(valueStrings, fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4))
+
+		valueArgs = append(valueArgs, orderID)
+		valueArgs = append(valueArgs, item.ProductID)
+		valueArgs = append(valueArgs, item.Quantity)
+		valueArgs = append(valueArgs, item.Price)
+	}
+
+	itemQuery := itemQueryBase + strings.Join(valueStrings, ",")
+
+	if _, err := tx.ExecContext(ctx, itemQuery, valueArgs...); err != nil {
+		return fmt.Errorf("failed to bulk insert order items: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateOrderStatus changes the order status and stores the outbox messages in the same transaction.
+// Moves not allowed by the order state machine fail with ErrInvalidTransition. A non-zero expectedVersion
+// that differs from the current one fails with ErrVersionConflict. A canceled order releases its delivery slot.
+func (r *OrderRepository) UpdateOrderStatus(
+	ctx context.Context,
+	orderID string,
+	status models.OrderStatus,
+	expectedVersion int,
+	outbox ...*OutboxMessage,
+) (err error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer func() {
 		if err != nil {
-			return fmt.Errorf("failed to bulk insert order items: %w", err)
+			if rollbackErr := tx.Rollback(); rollbackErr != nil {
+				err = fmt.Errorf("failed to rollback transaction: %v (original error: %w)", rollbackErr, err)
+			}
+		}
+	}()
+
+	if _, err = changeOrderStatus(ctx, tx, orderID, status, expectedVersion); err != nil {
+		return err
+	}
+
+	if status == models.OrderStatusCanceled {
+		if err = releaseOrderSlot(ctx, tx, orderID); err != nil {
+			return err
 		}
 	}
 
+	if err = insertOutboxMessages(ctx, tx, outbox...); err != nil {
+		return err
+	}
+
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return err
+	return nil
 }
 
-func (r *OrderRepository) UpdateOrderStatus(ctx context.Context, orderID string, status models.OrderStatus) error {
-	query := `
-        UPDATE orders
-        SET status = $1
-        WHERE id = $2
-    `
-	_, err := r.db.ExecContext(ctx, query, status, orderID)
+// GetOrder returns the order with its items, the linked assembly application and the delivery slot, if any.
+func (r *OrderRepository) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+	order, err := r.fetchOrder(ctx, r.db, orderID)
+	if err != nil {
+		return nil, err
+	}
 
-	return err
+	items, err := r.fetchOrderItems(ctx, r.db, orderID)
+	if err != nil {
+		return nil, err
+	}
+	order.Items = items
+
+	if order.AssemblyApplicationID != "" {
+		application, err := r.fetchAssemblyApplication(ctx, r.db, order.AssemblyApplicationID)
+		if err != nil {
+			return nil, err
+		}
+		application.Items, err = fetchAssemblyItems(ctx, r.db, application.ID)
+		if err != nil {
+			return nil, err
+		}
+		application.Substitutions, err = fetchSubstitutions(ctx, r.db, application.ID)
+		if err != nil {
+			return nil, err
+		}
+		application.Packages, err = fetchPackages(ctx, r.db, application.ID)
+		if err != nil {
+			return nil, err
+		}
+		order.AssemblyApplication = application
+	}
+
+	return order, nil
 }
 
-func (r *OrderRepository) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
+func (r *OrderRepository) fetchOrder(ctx context.Context, q querier, orderID string) (*models.Order, error) {
 	query := `
-        SELECT id, customer_id, total_amount, status, created_at
+        SELECT id, customer_id, total_amount, currency, COALESCE(region, ''), status, created_at, updated_at,
+               COALESCE(assembly_application_id, ''), assembly_overdue_at, COALESCE(delivery_slot_id, ''), version
         FROM orders
         WHERE id = $1
     `
-	row := r.db.QueryRowContext(ctx, query, orderID)
+	row := q.QueryRowContext(ctx, query, orderID)
 
-	var order models.Order
-	err := row.Scan(&order.ID, &order.CustomerID, &order.TotalAmount, &order.Status, &order.CreatedAt)
+	var (
+		order  models.Order
+		slotID string
+	)
+	err := row.Scan(
+		&order.ID,
+		&order.CustomerID,
+		&order.TotalAmount,
+		&order.Currency,
+		&order.Region,
+		&order.Status,
+		&order.CreatedAt,
+		&order.UpdatedAt,
+		&order.AssemblyApplicationID,
+		&order.AssemblyOverdueAt,
+		&slotID,
+		&order.Version,
+	)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+	}
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("%w: failed to fetch order: %v", ErrDatabaseOperation, err)
+	}
+
+	if slotID != "" {
+		order.DeliverySlot, err = fetchDeliverySlot(ctx, q, slotID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	return &order, nil
 }
 
+func (r *OrderRepository) fetchOrderItems(ctx context.Context, q querier, orderID string) ([]models.OrderItem, error) {
+	rows, err := q.QueryContext(ctx, `
+        SELECT product_id, quantity, price
+        FROM order_items
+        WHERE order_id = $1
+        ORDER BY id
+    `, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch order items: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	items := []models.OrderItem{}
+	for rows.Next() {
+		var item models.OrderItem
+		if err := rows.Scan(&item.ProductID, &item.Quantity, &item.Price); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan order item: %v", ErrDatabaseOperation, err)
+		}
+		items = append(items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return items, nil
+}
+
+func (r *OrderRepository) fetchAssemblyApplication(ctx context.Context, q querier, assemblyApplicationID string) (*models.AssemblyApplication, error) {
+	application, err := scanAssemblyApplication(q.QueryRowContext(ctx, `
+        SELECT `+assemblyApplicationColumns+`
+        FROM assembly_applications
+        WHERE id = $1
+    `, assemblyApplicationID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch assembly application: %v", ErrDatabaseOperation, err)
+	}
+
+	return application, nil
+}
+
 func (r *OrderRepository) Close() error {
 	return r.db.Close()
 }
@@ -148,11 +346,14 @@ func (r *OrderRepository) GetOrderStatus(ctx context.Context, orderID string) (s
 	return status, nil
 }
 
-func (r *OrderRepository) SaveAssemblyApplicationID(ctx context.Context, orderID, assemblyApplicationID string) error {
+func (r *OrderRepository) SaveAssemblyApplicationID(ctx context.Context, orderID, assemblyApplicationID string, expectedVersion int) error {
 	query := `
-        UPDATE orders SET assembly_application_id = $1 WHERE id = $2
+        UPDATE orders SET assembly_application_id = $1, version = version + 1 WHERE id = $2 AND version = $3
     `
-	_, err := r.db.ExecContext(ctx, query, assemblyApplicationID, orderID)
+	result, err := r.db.ExecContext(ctx, query, assemblyApplicationID, orderID, expectedVersion)
+	if err != nil {
+		return fmt.Errorf("%w: failed to update order: %v", ErrDatabaseOperation, err)
+	}
 
-	return err
+	return checkRowUpdated(result, "order", orderID, expectedVersion)
 }
@@ -0,0 +1,176 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// OrderAmendFunc changes the order in place. It is called inside the transaction with the order row locked,
+// so checks it makes against the current order state cannot be invalidated by a concurrent request.
+type OrderAmendFunc func(order *models.Order) error
+
+// OrderEventFunc builds the outbox message for an order inside the transaction that changed it.
+type OrderEventFunc func(order *models.Order) (*OutboxMessage, error)
+
+// AmendOrder loads the order with its items, applies amend and stores the new items and total
+// together with the event in one transaction. The lines of the linked assembly application follow the order
+// while no picker has taken it; an application that is claimed, started or has substitutions fails
+// with ErrAssemblyApplicationPicking. A non-zero expectedVersion that differs from
+// the current one fails with ErrVersionConflict. It returns the amended order.
+func (r *OrderRepository) AmendOrder(
+	ctx context.Context,
+	orderID string,
+	expectedVersion int,
+	amend OrderAmendFunc,
+	event OrderEventFunc,
+) (order *models.Order, err error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: %v, original error: %w", rbErr, err)
+			}
+		}
+	}()
+
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	order, err = r.fetchOrder(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkVersion("order", orderID, expectedVersion, order.Version); err != nil {
+		return nil, err
+	}
+
+	var applicationVersion int
+	if order.AssemblyApplicationID != "" {
+		applicationVersion, err = lockUnpickedApplication(ctx, tx, order.AssemblyApplicationID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	order.Items, err = r.fetchOrderItems(ctx, tx, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = amend(order); err != nil {
+		return nil, err
+	}
+
+	if _, err = tx.ExecContext(ctx, `DELETE FROM order_items WHERE order_id = $1`, orderID); err != nil {
+		return nil, fmt.Errorf("%w: failed to delete order items: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = insertOrderItems(ctx, tx, orderID, order.Items); err != nil {
+		return nil, err
+	}
+
+	// Заявку еще не взял сборщик, поэтому он получит новый состав
+	if order.AssemblyApplicationID != "" {
+		items := make([]models.AssemblyItem, len(order.Items))
+		for i, item := range order.Items {
+			items[i] = models.AssemblyItem{ProductID: item.ProductID, Quantity: item.Quantity}
+		}
+		if err = replaceAssemblyItems(ctx, tx, order.AssemblyApplicationID, items); err != nil {
+			return nil, err
+		}
+		if err = bumpApplicationVersion(ctx, tx, order.AssemblyApplicationID, applicationVersion); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE orders
+        SET total_amount = $1, version = version + 1
+        WHERE id = $2 AND version = $3
+    `, order.TotalAmount, orderID, order.Version)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to update order total: %v", ErrDatabaseOperation, err)
+	}
+	if err = checkRowUpdated(result, "order", orderID, order.Version); err != nil {
+		return nil, err
+	}
+	order.Version++
+
+	if event != nil {
+		var message *OutboxMessage
+		message, err = event(order)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to build order event: %v", ErrInvalidInput, err)
+		}
+		if err = insertOutboxMessages(ctx, tx, message); err != nil {
+			return nil, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return order, nil
+}
+
+// lockUnpickedApplication locks the assembly application whose lines are about to be replaced and returns
+// its version. Only an application waiting in the queue can change: once a picker claimed or started it,
+// or proposed a substitution, the lines are being picked and the collected quantities refer to them.
+func lockUnpickedApplication(ctx context.Context, tx *sql.Tx, assemblyApplicationID string) (int, error) {
+	var (
+		status   models.AssemblyStatus
+		pickerID string
+		version  int
+	)
+	err := tx.QueryRowContext(ctx, `
+        SELECT status, COALESCE(picker_id, ''), version
+        FROM assembly_applications
+        WHERE id = $1
+        FOR UPDATE
+    `, assemblyApplicationID).Scan(&status, &pickerID, &version)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to lock assembly application: %v", ErrDatabaseOperation, err)
+	}
+
+	if status != models.AssemblyStatusCreated || pickerID != "" {
+		return 0, fmt.Errorf("%w: assembly application %s is %s, picker %q", ErrAssemblyApplicationPicking, assemblyApplicationID, status, pickerID)
+	}
+
+	var substitutions int
+	err = tx.QueryRowContext(ctx, `
+        SELECT COUNT(*) FROM assembly_substitutions WHERE assembly_application_id = $1
+    `, assemblyApplicationID).Scan(&substitutions)
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to fetch substitutions: %v", ErrDatabaseOperation, err)
+	}
+	if substitutions > 0 {
+		return 0, fmt.Errorf("%w: assembly application %s has substitutions", ErrAssemblyApplicationPicking, assemblyApplicationID)
+	}
+
+	return version, nil
+}
+
+// lockOrder locks the order row until the end of tx.
+func lockOrder(ctx context.Context, tx *sql.Tx, orderID string) error {
+	var id string
+	err := tx.QueryRowContext(ctx, `SELECT id FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+	}
+	if err != nil {
+		return fmt.Errorf("%w: failed to lock order: %v", ErrDatabaseOperation, err)
+	}
+	return nil
+}
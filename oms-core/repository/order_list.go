@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+const (
+	DefaultOrderListLimit = 50
+	MaxOrderListLimit     = 500
+)
+
+// OrderFilter describes the orders search. Empty fields are not applied.
+type OrderFilter struct {
+	CustomerID     string
+	Status         models.OrderStatus
+	AssemblyStatus models.AssemblyStatus
+	CreatedFrom    *time.Time
+	CreatedTo      *time.Time
+	Descending     bool
+	Cursor         string
+	Limit          int
+}
+
+// OrderPage is a page of orders sorted by created_at and id.
+// NextCursor is empty when there are no more orders.
+type OrderPage struct {
+	Orders     []models.Order `json:"orders"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// orderCursor is the position of the last order of a page. Orders are sorted by (created_at, id),
+// so the id breaks ties between orders created at the same moment.
+type orderCursor struct {
+	CreatedAt time.Time
+	ID        string
+}
+
+func (c orderCursor) encode() string {
+	raw := c.CreatedAt.UTC().Format(time.RFC3339Nano) + "|" + c.ID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeOrderCursor(cursor string) (*orderCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+
+	createdAt, id, ok := strings.Cut(string(raw), "|")
+	if !ok || id == "" {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed cursor", ErrInvalidInput)
+	}
+
+	return &orderCursor{CreatedAt: t, ID: id}, nil
+}
+
+// ListOrders returns a page of orders matching the filter using keyset pagination.
+func (r *OrderRepository) ListOrders(ctx context.Context, filter OrderFilter) (*OrderPage, error) {
+	limit := filter.Limit
+	if limit <= 0 {
+		limit = DefaultOrderListLimit
+	}
+	if limit > MaxOrderListLimit {
+		limit = MaxOrderListLimit
+	}
+
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	addCondition := func(condition string, values ...interface{}) {
+		placeholders := make([]interface{}, len(values))
+		for i, value := range values {
+			args = append(args, value)
+			placeholders[i] = fmt.Sprintf("$%d", len(args))
+		}
+		conditions = append(conditions, fmt.Sprintf(condition, placeholders...))
+	}
+
+	if filter.CustomerID != "" {
+		addCondition("o.customer_id = %s", filter.CustomerID)
+	}
+	if filter.Status != "" {
+		addCondition("o.status = %s", filter.Status)
+	}
+	if filter.AssemblyStatus != "" {
+		addCondition("a.status = %s", filter.AssemblyStatus)
+	}
+	if filter.CreatedFrom != nil {
+		addCondition("o.created_at >= %s", *filter.CreatedFrom)
+	}
+	if filter.CreatedTo != nil {
+		addCondition("o.created_at < %s", *filter.CreatedTo)
+	}
+
+	direction, comparison := "ASC", ">"
+	if filter.Descending {
+		direction, comparison = "DESC", "<"
+	}
+
+	if filter.Cursor != "" {
+		cursor, err := decodeOrderCursor(filter.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		addCondition("(o.created_at, o.id) "+comparison+" (%s, %s)", cursor.CreatedAt, cursor.ID)
+	}
+
+	where := ""
+	if len(conditions) > 0 {
+		where = "WHERE " + strings.Join(conditions, " AND ")
+	}
+
+	// Берем на одну запись больше, чтобы понять, есть ли следующая страница
+	args = append(args, limit+1)
+	query := fmt.Sprintf(`
+        SELECT
+            o.id, o.customer_id, o.total_amount, o.currency, COALESCE(o.region, ''), o.status, o.created_at, o.updated_at,
+            COALESCE(o.assembly_application_id, ''), o.assembly_overdue_at, o.version,
+            a.id, a.status, a.created_at, a.completed_at, a.version
+        FROM orders o
+        LEFT JOIN assembly_applications a ON a.id = o.assembly_application_id
+        %s
+        ORDER BY o.created_at %s, o.id %s
+        LIMIT $%d
+    `, where, direction, direction, len(args))
+
+	rows, err := r.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list orders: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	orders := []models.Order{}
+	for rows.Next() {
+		var (
+			order               models.Order
+			assemblyID          *string
+			assemblyStatus      *models.AssemblyStatus
+			assemblyCreatedAt   *time.Time
+			assemblyCompletedAt *time.Time
+			assemblyVersion     *int
+		)
+		if err := rows.Scan(
+			&order.ID,
+			&order.CustomerID,
+			&order.TotalAmount,
+			&order.Currency,
+			&order.Region,
+			&order.Status,
+			&order.CreatedAt,
+			&order.UpdatedAt,
+			&order.AssemblyApplicationID,
+			&order.AssemblyOverdueAt,
+			&order.Version,
+			&assemblyID,
+			&assemblyStatus,
+			&assemblyCreatedAt,
+			&assemblyCompletedAt,
+			&assemblyVersion,
+		); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan order: %v", ErrDatabaseOperation, err)
+		}
+
+		if assemblyID != nil {
+			order.AssemblyApplication = &models.AssemblyApplication{
+				ID:          *assemblyID,
+				OrderID:     order.ID,
+				Status:      *assemblyStatus,
+				CreatedAt:   *assemblyCreatedAt,
+				CompletedAt: assemblyCompletedAt,
+				Version:     *assemblyVersion,
+			}
+		}
+		orders = append(orders, order)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	page := &OrderPage{Orders: orders}
+	if len(orders) > limit {
+		page.Orders = orders[:limit]
+		last := page.Orders[limit-1]
+		page.NextCursor = orderCursor{CreatedAt: last.CreatedAt, ID: last.ID}.encode()
+	}
+
+	if err := r.fillOrderItems(ctx, page.Orders); err != nil {
+		return nil, err
+	}
+
+	return page, nil
+}
+
+// fillOrderItems loads items for all orders of a page with a single query.
+func (r *OrderRepository) fillOrderItems(ctx context.Context, orders []models.Order) error {
+	if len(orders) == 0 {
+		return nil
+	}
+
+	ids := make([]string, len(orders))
+	index := make(map[string]int, len(orders))
+	for i := range orders {
+		ids[i] = orders[i].ID
+		index[orders[i].ID] = i
+		orders[i].Items = []models.OrderItem{}
+	}
+
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT order_id, product_id, quantity, price
+        FROM order_items
+        WHERE order_id = ANY($1)
+        ORDER BY id
+    `, pq.Array(ids))
+	if err != nil {
+		return fmt.Errorf("%w: failed to fetch order items: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			orderID string
+			item    models.OrderItem
+		)
+		if err := rows.Scan(&orderID, &item.ProductID, &item.Quantity, &item.Price); err != nil {
+			return fmt.Errorf("%w: failed to scan order item: %v", ErrDatabaseOperation, err)
+		}
+		i := index[orderID]
+		orders[i].Items = append(orders[i].Items, item)
+	}
+
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return nil
+}
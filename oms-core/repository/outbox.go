@@ -0,0 +1,214 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// OutboxMessage is an event waiting in the outbox table to be published to Kafka.
+type OutboxMessage struct {
+	ID        int64
+	Topic     string
+	Key       string
+	Payload   []byte
+	Attempts  int
+	CreatedAt time.Time
+}
+
+// NewOutboxMessage serializes event into a message for the given topic.
+// The key is used as the Kafka message key, so events with the same key keep their order.
+func NewOutboxMessage(topic, key string, event interface{}) (*OutboxMessage, error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal outbox event: %w", err)
+	}
+
+	return &OutboxMessage{
+		Topic:   topic,
+		Key:     key,
+		Payload: payload,
+	}, nil
+}
+
+type OutboxRepository struct {
+	db *sql.DB
+}
+
+func NewOutboxRepository(db *sql.DB) (*OutboxRepository, error) {
+	if db == nil {
+		return nil, fmt.Errorf("%w: database connection is required", ErrInvalidInput)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &OutboxRepository{db: db}, nil
+}
+
+// ClaimPending takes up to limit messages that are ready to be published for lease. A message is ready
+// when no earlier message with the same key is pending, so a message that keeps failing holds back only
+// the events of its own key. The claim is committed before anything is published, no row stays locked
+// while Kafka is called; a relay that dies with claimed messages leaves them to be claimed again after lease.
+func (r *OutboxRepository) ClaimPending(ctx context.Context, limit int, lease time.Duration) (messages []*OutboxMessage, err error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer func() {
+		if err != nil {
+			if rbErr := tx.Rollback(); rbErr != nil {
+				err = fmt.Errorf("rollback failed: %v, original error: %w", rbErr, err)
+			}
+		}
+	}()
+
+	now := time.Now()
+	// Занятое другим релеем сообщение тоже считается неотправленным и задерживает свой ключ
+	rows, err := tx.QueryContext(ctx, `
+        SELECT o.id, o.topic, o.message_key, o.payload, o.attempts, o.created_at
+        FROM outbox o
+        WHERE o.sent_at IS NULL
+            AND o.dead_at IS NULL
+            AND (o.locked_until IS NULL OR o.locked_until <= $1)
+            AND NOT EXISTS (
+                SELECT 1
+                FROM outbox p
+                WHERE p.message_key = o.message_key
+                    AND p.id < o.id
+                    AND p.sent_at IS NULL
+                    AND p.dead_at IS NULL
+            )
+        ORDER BY o.id
+        LIMIT $2
+        FOR UPDATE SKIP LOCKED
+    `, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch outbox messages: %v", ErrDatabaseOperation, err)
+	}
+	messages, err = scanOutboxMessages(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, message := range messages {
+		_, err = tx.ExecContext(ctx, `
+            UPDATE outbox
+            SET locked_until = $1
+            WHERE id = $2
+        `, now.Add(lease), message.ID)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to claim outbox message: %v", ErrDatabaseOperation, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return messages, nil
+}
+
+func scanOutboxMessages(rows *sql.Rows) ([]*OutboxMessage, error) {
+	defer rows.Close()
+
+	var messages []*OutboxMessage
+	for rows.Next() {
+		message := &OutboxMessage{}
+		if err := rows.Scan(
+			&message.ID,
+			&message.Topic,
+			&message.Key,
+			&message.Payload,
+			&message.Attempts,
+			&message.CreatedAt,
+		); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan outbox message: %v", ErrDatabaseOperation, err)
+		}
+		messages = append(messages, message)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return messages, nil
+}
+
+func (r *OutboxRepository) MarkSent(ctx context.Context, id int64) error {
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE outbox
+        SET sent_at = $1, attempts = attempts + 1, last_error = NULL, locked_until = NULL
+        WHERE id = $2
+    `, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("%w: failed to mark outbox message as sent: %v", ErrDatabaseOperation, err)
+	}
+
+	return nil
+}
+
+// MarkFailed records the failed attempt. The message is retried after retryAt, or, when dead is set,
+// is never published again and no longer holds back the messages of its key.
+func (r *OutboxRepository) MarkFailed(ctx context.Context, id int64, cause error, retryAt time.Time, dead bool) error {
+	var deadAt *time.Time
+	if dead {
+		now := time.Now()
+		deadAt = &now
+	}
+
+	_, err := r.db.ExecContext(ctx, `
+        UPDATE outbox
+        SET attempts = attempts + 1, last_error = $1, locked_until = $2, dead_at = $3
+        WHERE id = $4
+    `, cause.Error(), retryAt, deadAt, id)
+	if err != nil {
+		return fmt.Errorf("%w: failed to mark outbox message as failed: %v", ErrDatabaseOperation, err)
+	}
+
+	return nil
+}
+
+// Requeue returns the dead message to the pending ones with its attempts reset. Until it is published
+// it holds back the later pending messages of its key again.
+func (r *OutboxRepository) Requeue(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx, `
+        UPDATE outbox
+        SET dead_at = NULL, attempts = 0, locked_until = NULL
+        WHERE id = $1 AND dead_at IS NOT NULL AND sent_at IS NULL
+    `, id)
+	if err != nil {
+		return fmt.Errorf("%w: failed to requeue outbox message: %v", ErrDatabaseOperation, err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: failed to get rows affected: %v", ErrDatabaseOperation, err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("%w: ID %d", ErrDeadLetterNotFound, id)
+	}
+
+	return nil
+}
+
+// insertOutboxMessages stores messages within the caller's transaction.
+func insertOutboxMessages(ctx context.Context, tx *sql.Tx, messages ...*OutboxMessage) error {
+	for _, message := range messages {
+		if message == nil {
+			continue
+		}
+
+		_, err := tx.ExecContext(ctx, `
+            INSERT INTO outbox (topic, message_key, payload, created_at)
+            VALUES ($1, $2, $3, $4)
+        `, message.Topic, message.Key, string(message.Payload), time.Now())
+		if err != nil {
+			return fmt.Errorf("%w: failed to insert outbox message: %v", ErrDatabaseOperation, err)
+		}
+	}
+
+	return nil
+}
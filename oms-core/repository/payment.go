@@ -0,0 +1,264 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// PaymentRepository stores the payments of orders. The gateway is called outside of the transactions,
+// so a payment is created PENDING first and its ID is the idempotency key of the gateway calls.
+type PaymentRepository struct {
+	db *sql.DB
+}
+
+func NewPaymentRepository(db *sql.DB) (*PaymentRepository, error) {
+	if db == nil {
+		return nil, fmt.Errorf("%w: database connection is required", ErrInvalidInput)
+	}
+	if err := db.Ping(); err != nil {
+		return nil, fmt.Errorf("failed to ping database: %w", err)
+	}
+
+	return &PaymentRepository{db: db}, nil
+}
+
+// PaymentChange is what the gateway answered, it is stored together with the new status of the payment.
+// Amounts are added to the captured and refunded amounts of the payment.
+type PaymentChange struct {
+	GatewayReference string
+	DeclineReason    string
+	Captured         models.Money
+	Refunded         models.Money
+}
+
+// StartAuthorization creates a PENDING payment for the total of the order. If the order already has
+// an active payment, it is returned instead, so a repeated authorization does not charge twice.
+func (r *PaymentRepository) StartAuthorization(ctx context.Context, orderID string) (payment *models.Payment, err error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	if err = lockOrder(ctx, tx, orderID); err != nil {
+		return nil, err
+	}
+
+	payment, err = scanPayment(tx.QueryRowContext(ctx, `
+        SELECT `+paymentColumns+`
+        FROM payments
+        WHERE order_id = $1 AND status IN ($2, $3, $4)
+    `, orderID, models.PaymentStatusPending, models.PaymentStatusAuthorized, models.PaymentStatusCaptured))
+	if err == nil {
+		return payment, tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: failed to fetch active payment: %v", ErrDatabaseOperation, err)
+	}
+
+	now := time.Now()
+	payment = &models.Payment{
+		ID:        uuid.New().String(),
+		OrderID:   orderID,
+		Status:    models.PaymentStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+		Version:   1,
+	}
+	err = tx.QueryRowContext(ctx, `
+        INSERT INTO payments (id, order_id, status, amount, currency, created_at, updated_at, version)
+        SELECT $1, id, $2, total_amount, currency, $3, $3, 1
+        FROM orders
+        WHERE id = $4
+        RETURNING amount, currency
+    `, payment.ID, payment.Status, now, orderID).Scan(&payment.Amount, &payment.Currency)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to create payment: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = insertStatusHistory(ctx, tx, orderID, HistoryEntityPayment, payment.ID, "", string(payment.Status)); err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return payment, nil
+}
+
+// OrderTotal returns the current total of the order the payment is for.
+func (r *PaymentRepository) OrderTotal(ctx context.Context, orderID string) (models.Money, error) {
+	var total models.Money
+	err := r.db.QueryRowContext(ctx, `SELECT total_amount FROM orders WHERE id = $1`, orderID).Scan(&total)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("%w: failed to fetch order total: %v", ErrDatabaseOperation, err)
+	}
+	return total, nil
+}
+
+// IncreaseAmount stores the amount the authorization of the payment was raised to. A smaller amount
+// leaves the payment as it is.
+func (r *PaymentRepository) IncreaseAmount(ctx context.Context, paymentID string, amount models.Money) (payment *models.Payment, err error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	if _, err = fetchPayment(ctx, tx, paymentID); err != nil {
+		return nil, err
+	}
+
+	err = updateLockedRow(ctx, tx, "payments", "payment", paymentID,
+		`amount = GREATEST(amount, $3), updated_at = $4`, amount, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	// Статус проверяется под блокировкой: списанную или отмененную оплату увеличить нельзя
+	payment, err = fetchPayment(ctx, tx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+	if payment.Status != models.PaymentStatusAuthorized {
+		return nil, &InvalidTransitionError{Entity: "payment", ID: paymentID, From: string(payment.Status), To: string(models.PaymentStatusAuthorized)}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return payment, nil
+}
+
+func (r *PaymentRepository) Get(ctx context.Context, paymentID string) (*models.Payment, error) {
+	return fetchPayment(ctx, r.db, paymentID)
+}
+
+// ListByOrder returns the payments of the order, the first one first.
+func (r *PaymentRepository) ListByOrder(ctx context.Context, orderID string) ([]models.Payment, error) {
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT `+paymentColumns+`
+        FROM payments
+        WHERE order_id = $1
+        ORDER BY created_at, id
+    `, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to list payments: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	payments := []models.Payment{}
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to scan payment: %v", ErrDatabaseOperation, err)
+		}
+		payments = append(payments, *payment)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	return payments, nil
+}
+
+// ChangeStatus moves the payment to the status with what the gateway answered. Moves not allowed
+// by the payment state machine fail with ErrInvalidTransition.
+func (r *PaymentRepository) ChangeStatus(
+	ctx context.Context,
+	paymentID string,
+	to models.PaymentStatus,
+	change PaymentChange,
+) (payment *models.Payment, err error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelReadCommitted})
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to begin transaction: %v", ErrDatabaseOperation, err)
+	}
+	defer r.rollbackOnError(tx, &err)
+
+	if _, err = changePaymentStatus(ctx, tx, paymentID, to); err != nil {
+		return nil, err
+	}
+
+	err = updateLockedRow(ctx, tx, "payments", "payment", paymentID, `
+            gateway_reference = COALESCE(NULLIF($3, ''), gateway_reference),
+            decline_reason = COALESCE(NULLIF($4, ''), decline_reason),
+            captured_amount = captured_amount + $5,
+            refunded_amount = refunded_amount + $6`,
+		change.GatewayReference, change.DeclineReason, change.Captured, change.Refunded)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err = fetchPayment(ctx, tx, paymentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("%w: failed to commit transaction: %v", ErrDatabaseOperation, err)
+	}
+
+	return payment, nil
+}
+
+// Вспомогательные методы
+
+func (r *PaymentRepository) rollbackOnError(tx *sql.Tx, err *error) {
+	if *err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil {
+			*err = fmt.Errorf("rollback failed: %v, original error: %w", rbErr, *err)
+		}
+	}
+}
+
+// paymentColumns are the columns read by scanPayment.
+const paymentColumns = `
+    id, order_id, status, amount, captured_amount, refunded_amount, currency,
+    COALESCE(gateway_reference, ''), COALESCE(decline_reason, ''), created_at, updated_at, version`
+
+func scanPayment(row rowScanner) (*models.Payment, error) {
+	payment := &models.Payment{}
+	err := row.Scan(
+		&payment.ID,
+		&payment.OrderID,
+		&payment.Status,
+		&payment.Amount,
+		&payment.CapturedAmount,
+		&payment.RefundedAmount,
+		&payment.Currency,
+		&payment.GatewayReference,
+		&payment.DeclineReason,
+		&payment.CreatedAt,
+		&payment.UpdatedAt,
+		&payment.Version,
+	)
+	return payment, err
+}
+
+func fetchPayment(ctx context.Context, q querier, paymentID string) (*models.Payment, error) {
+	payment, err := scanPayment(q.QueryRowContext(ctx, `
+        SELECT `+paymentColumns+`
+        FROM payments
+        WHERE id = $1
+    `, paymentID))
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("%w: ID %s", ErrPaymentNotFound, paymentID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch payment: %v", ErrDatabaseOperation, err)
+	}
+
+	return payment, nil
+}
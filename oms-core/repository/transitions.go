@@ -0,0 +1,258 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// changeOrderStatus locks the order row until the end of tx, checks the expected version and the move
+// against the order state machine, updates the status and records it in the status history.
+// Zero expectedVersion skips the version check. It returns the previous status.
+func changeOrderStatus(
+	ctx context.Context,
+	tx *sql.Tx,
+	orderID string,
+	to models.OrderStatus,
+	expectedVersion int,
+) (models.OrderStatus, error) {
+	var (
+		from    models.OrderStatus
+		version int
+	)
+	err := tx.QueryRowContext(ctx, `SELECT status, version FROM orders WHERE id = $1 FOR UPDATE`, orderID).Scan(&from, &version)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to lock order: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = checkVersion("order", orderID, expectedVersion, version); err != nil {
+		return from, err
+	}
+
+	if !from.CanTransitionTo(to) {
+		return from, &InvalidTransitionError{Entity: "order", ID: orderID, From: string(from), To: string(to)}
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE orders
+        SET status = $1, version = version + 1
+        WHERE id = $2 AND version = $3
+    `, to, orderID, version)
+	if err != nil {
+		return from, fmt.Errorf("%w: failed to update order status: %v", ErrDatabaseOperation, err)
+	}
+	if err = checkRowUpdated(result, "order", orderID, version); err != nil {
+		return from, err
+	}
+
+	if err = insertStatusHistory(ctx, tx, orderID, HistoryEntityOrder, orderID, string(from), string(to)); err != nil {
+		return from, err
+	}
+
+	return from, nil
+}
+
+// changeAssemblyStatus locks the assembly application row until the end of tx, checks the expected version
+// and the move against the assembly state machine, updates the status and records it in the history
+// of the related order. Zero expectedVersion skips the version check. It returns the previous status.
+func changeAssemblyStatus(
+	ctx context.Context,
+	tx *sql.Tx,
+	assemblyApplicationID string,
+	to models.AssemblyStatus,
+	expectedVersion int,
+) (models.AssemblyStatus, error) {
+	var (
+		from    models.AssemblyStatus
+		orderID string
+		version int
+	)
+	err := tx.QueryRowContext(ctx, `
+        SELECT status, order_id, version
+        FROM assembly_applications
+        WHERE id = $1
+        FOR UPDATE
+    `, assemblyApplicationID).Scan(&from, &orderID, &version)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: ID %s", ErrAssemblyApplicationNotFound, assemblyApplicationID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to lock assembly application: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = checkVersion("assembly application", assemblyApplicationID, expectedVersion, version); err != nil {
+		return from, err
+	}
+
+	if !from.CanTransitionTo(to) {
+		return from, &InvalidTransitionError{Entity: "assembly application", ID: assemblyApplicationID, From: string(from), To: string(to)}
+	}
+
+	// completed_at фиксирует момент закрытия заявки; отклонение проверкой качества его не меняет
+	var completedAt *time.Time
+	if to.IsClosed() {
+		now := time.Now()
+		completedAt = &now
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE assembly_applications
+        SET status = $1, completed_at = COALESCE(completed_at, $2), version = version + 1
+        WHERE id = $3 AND version = $4
+    `, to, completedAt, assemblyApplicationID, version)
+	if err != nil {
+		return from, fmt.Errorf("%w: failed to update assembly status: %v", ErrDatabaseOperation, err)
+	}
+	if err = checkRowUpdated(result, "assembly application", assemblyApplicationID, version); err != nil {
+		return from, err
+	}
+
+	if err = insertStatusHistory(ctx, tx, orderID, HistoryEntityAssemblyApplication, assemblyApplicationID, string(from), string(to)); err != nil {
+		return from, err
+	}
+
+	return from, nil
+}
+
+// changeDeliveryStatus locks the delivery application row until the end of tx, checks the expected version
+// and the move against the delivery state machine, updates the status and records it in the history
+// of the related order. Zero expectedVersion skips the version check. It returns the previous status.
+func changeDeliveryStatus(
+	ctx context.Context,
+	tx *sql.Tx,
+	deliveryApplicationID string,
+	to models.DeliveryStatus,
+	expectedVersion int,
+) (models.DeliveryStatus, error) {
+	var (
+		from    models.DeliveryStatus
+		orderID string
+		version int
+	)
+	err := tx.QueryRowContext(ctx, `
+        SELECT status, order_id, version
+        FROM delivery_applications
+        WHERE id = $1
+        FOR UPDATE
+    `, deliveryApplicationID).Scan(&from, &orderID, &version)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: ID %s", ErrDeliveryApplicationNotFound, deliveryApplicationID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to lock delivery application: %v", ErrDatabaseOperation, err)
+	}
+
+	if err = checkVersion("delivery application", deliveryApplicationID, expectedVersion, version); err != nil {
+		return from, err
+	}
+
+	if !from.CanTransitionTo(to) {
+		return from, &InvalidTransitionError{Entity: "delivery application", ID: deliveryApplicationID, From: string(from), To: string(to)}
+	}
+
+	var closedAt *time.Time
+	if to.IsFinal() {
+		now := time.Now()
+		closedAt = &now
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE delivery_applications
+        SET status = $1, closed_at = COALESCE(closed_at, $2), version = version + 1
+        WHERE id = $3 AND version = $4
+    `, to, closedAt, deliveryApplicationID, version)
+	if err != nil {
+		return from, fmt.Errorf("%w: failed to update delivery status: %v", ErrDatabaseOperation, err)
+	}
+	if err = checkRowUpdated(result, "delivery application", deliveryApplicationID, version); err != nil {
+		return from, err
+	}
+
+	if err = insertStatusHistory(ctx, tx, orderID, HistoryEntityDeliveryApplication, deliveryApplicationID, string(from), string(to)); err != nil {
+		return from, err
+	}
+
+	return from, nil
+}
+
+// checkRowUpdated turns a conditional UPDATE that matched no rows into a version conflict.
+func checkRowUpdated(result sql.Result, entity, id string, version int) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%w: failed to get rows affected: %v", ErrDatabaseOperation, err)
+	}
+	if rows == 0 {
+		return &VersionConflictError{Entity: entity, ID: id, Expected: version}
+	}
+	return nil
+}
+
+// updateLockedRow updates the columns in set of a row locked in tx, bumps its version and matches
+// the row on the version it has under the lock. The placeholders of set start from $3.
+func updateLockedRow(ctx context.Context, tx *sql.Tx, table, entity, id, set string, args ...interface{}) error {
+	var version int
+	err := tx.QueryRowContext(ctx, `SELECT version FROM `+table+` WHERE id = $1 FOR UPDATE`, id).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("%w: failed to lock %s: %v", ErrDatabaseOperation, entity, err)
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE `+table+`
+        SET `+set+`, version = version + 1
+        WHERE id = $1 AND version = $2
+    `, append([]interface{}{id, version}, args...)...)
+	if err != nil {
+		return fmt.Errorf("%w: failed to update %s: %v", ErrDatabaseOperation, entity, err)
+	}
+	return checkRowUpdated(result, entity, id, version)
+}
+
+// changePaymentStatus locks the payment row until the end of tx, checks the move against the payment
+// state machine, updates the status and records it in the history of the order. It returns the previous status.
+func changePaymentStatus(ctx context.Context, tx *sql.Tx, paymentID string, to models.PaymentStatus) (models.PaymentStatus, error) {
+	var (
+		from    models.PaymentStatus
+		orderID string
+		version int
+	)
+	err := tx.QueryRowContext(ctx, `
+        SELECT status, order_id, version
+        FROM payments
+        WHERE id = $1
+        FOR UPDATE
+    `, paymentID).Scan(&from, &orderID, &version)
+	if err == sql.ErrNoRows {
+		return "", fmt.Errorf("%w: ID %s", ErrPaymentNotFound, paymentID)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%w: failed to lock payment: %v", ErrDatabaseOperation, err)
+	}
+
+	if !from.CanTransitionTo(to) {
+		return from, &InvalidTransitionError{Entity: "payment", ID: paymentID, From: string(from), To: string(to)}
+	}
+
+	result, err := tx.ExecContext(ctx, `
+        UPDATE payments
+        SET status = $1, updated_at = $2, version = version + 1
+        WHERE id = $3 AND version = $4
+    `, to, time.Now(), paymentID, version)
+	if err != nil {
+		return from, fmt.Errorf("%w: failed to update payment status: %v", ErrDatabaseOperation, err)
+	}
+	if err = checkRowUpdated(result, "payment", paymentID, version); err != nil {
+		return from, err
+	}
+
+	if err = insertStatusHistory(ctx, tx, orderID, HistoryEntityPayment, paymentID, string(from), string(to)); err != nil {
+		return from, err
+	}
+
+	return from, nil
+}
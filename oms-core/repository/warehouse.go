@@ -0,0 +1,167 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+type WarehouseRepository struct {
+	db *sql.DB
+}
+
+func NewWarehouseRepository(db *sql.DB) (*WarehouseRepository, error) {
+	if db == nil {
+		return nil, fmt.Errorf("%w: database connection is required", ErrInvalidInput)
+	}
+
+	return &WarehouseRepository{db: db}, nil
+}
+
+// WarehouseCandidate is an active warehouse evaluated for an order by the assembly routing.
+// InStock is set when the warehouse has every line of the order in the ordered quantity
+// besides the stock reserved by its open applications, MatchesRegion when the warehouse
+// is in the region of the order.
+type WarehouseCandidate struct {
+	models.Warehouse
+	InStock       bool
+	MatchesRegion bool
+}
+
+// RoutingCandidates returns the active warehouses that can assemble the order, the preferred ones first.
+// Warehouses that already canceled an assembly of the order are skipped.
+// The result is empty when there are no such warehouses.
+func (r *WarehouseRepository) RoutingCandidates(ctx context.Context, orderID string) ([]WarehouseCandidate, error) {
+	// LEFT JOIN, чтобы отличить отсутствующий заказ от отсутствия активных складов.
+	// Позиции незакрытых заявок склада резервируют его остатки
+	rows, err := r.db.QueryContext(ctx, `
+        SELECT
+            w.id, w.name, COALESCE(w.region, ''), w.priority,
+            COALESCE(w.region = o.region, FALSE),
+            NOT EXISTS (
+                SELECT 1
+                FROM order_items oi
+                LEFT JOIN warehouse_stock s ON s.warehouse_id = w.id AND s.product_id = oi.product_id
+                WHERE oi.order_id = o.id AND COALESCE(s.quantity, 0) - (
+                    SELECT COALESCE(SUM(ai.requested_quantity), 0)
+                    FROM assembly_items ai
+                    JOIN assembly_applications a ON a.id = ai.assembly_application_id
+                    WHERE a.warehouse_id = w.id AND ai.product_id = oi.product_id
+                      AND a.status NOT IN ($2, $3, $4)
+                ) < oi.quantity
+            )
+        FROM orders o
+        LEFT JOIN warehouses w ON w.active AND NOT EXISTS (
+            SELECT 1
+            FROM assembly_applications c
+            WHERE c.order_id = o.id AND c.warehouse_id = w.id AND c.status = $3
+        )
+        WHERE o.id = $1
+        ORDER BY w.priority, w.id
+    `, orderID, models.AssemblyStatusComplete, models.AssemblyStatusCanceled, models.AssemblyStatusRejected)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch warehouse candidates: %v", ErrDatabaseOperation, err)
+	}
+	defer rows.Close()
+
+	found := false
+	candidates := []WarehouseCandidate{}
+	for rows.Next() {
+		found = true
+
+		var (
+			id, name, region sql.NullString
+			priority         sql.NullInt64
+			matchesRegion    bool
+			inStock          sql.NullBool
+		)
+		if err := rows.Scan(&id, &name, &region, &priority, &matchesRegion, &inStock); err != nil {
+			return nil, fmt.Errorf("%w: failed to scan warehouse candidate: %v", ErrDatabaseOperation, err)
+		}
+		if !id.Valid {
+			continue
+		}
+
+		candidates = append(candidates, WarehouseCandidate{
+			Warehouse: models.Warehouse{
+				ID:       id.String,
+				Name:     name.String,
+				Region:   region.String,
+				Priority: int(priority.Int64),
+				Active:   true,
+			},
+			InStock:       inStock.Bool,
+			MatchesRegion: matchesRegion,
+		})
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%w: failed to iterate over rows: %v", ErrDatabaseOperation, err)
+	}
+
+	if !found {
+		return nil, fmt.Errorf("%w: order ID %s", ErrOrderNotFound, orderID)
+	}
+
+	return candidates, nil
+}
+
+// AssemblySLA returns the assembly SLA of the warehouse for the order type.
+// ok is false when the warehouse has no SLA for it.
+func (r *WarehouseRepository) AssemblySLA(ctx context.Context, warehouseID string, orderType models.OrderType) (sla time.Duration, ok bool, err error) {
+	var minutes int
+	err = r.db.QueryRowContext(ctx, `
+        SELECT duration_minutes
+        FROM assembly_slas
+        WHERE warehouse_id = $1 AND order_type = $2
+    `, warehouseID, orderType).Scan(&minutes)
+	if err == sql.ErrNoRows {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, fmt.Errorf("%w: failed to fetch assembly SLA: %v", ErrDatabaseOperation, err)
+	}
+
+	return time.Duration(minutes) * time.Minute, true, nil
+}
+
+// consumeStock writes off what was collected for a complete application from the stock of its warehouse.
+// Until then the lines of the application only reserve the stock, see RoutingCandidates.
+func consumeStock(ctx context.Context, tx *sql.Tx, assemblyApplicationID string) error {
+	_, err := tx.ExecContext(ctx, `
+        UPDATE warehouse_stock s
+        SET quantity = GREATEST(s.quantity - ai.collected_quantity, 0)
+        FROM assembly_items ai
+        JOIN assembly_applications a ON a.id = ai.assembly_application_id
+        WHERE ai.assembly_application_id = $1
+          AND s.warehouse_id = a.warehouse_id
+          AND s.product_id = ai.product_id
+          AND ai.collected_quantity > 0
+    `, assemblyApplicationID)
+	if err != nil {
+		return fmt.Errorf("%w: failed to write off warehouse stock: %v", ErrDatabaseOperation, err)
+	}
+	return nil
+}
+
+// restoreStock returns what was collected for a rejected application to the stock of its warehouse,
+// the next attempt picks it again and writes it off when complete.
+func restoreStock(ctx context.Context, tx *sql.Tx, assemblyApplicationID string) error {
+	_, err := tx.ExecContext(ctx, `
+        UPDATE warehouse_stock s
+        SET quantity = s.quantity + ai.collected_quantity
+        FROM assembly_items ai
+        JOIN assembly_applications a ON a.id = ai.assembly_application_id
+        WHERE ai.assembly_application_id = $1
+          AND s.warehouse_id = a.warehouse_id
+          AND s.product_id = ai.product_id
+          AND ai.collected_quantity > 0
+    `, assemblyApplicationID)
+	if err != nil {
+		return fmt.Errorf("%w: failed to restore warehouse stock: %v", ErrDatabaseOperation, err)
+	}
+	return nil
+}
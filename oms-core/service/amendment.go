@@ -0,0 +1,108 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+	"github.com/milovidov983/oms-temporal-demo/shared/events"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+var ErrOrderNotAmendable = errors.New("order items can no longer be changed")
+
+// amendableStatuses are the order statuses in which lines can still be changed: until a picker takes
+// the assembly application the warehouse picks by the current order items.
+var amendableStatuses = map[models.OrderStatus]bool{
+	models.OrderStatusCreated:          true,
+	models.OrderStatusPassedToAssembly: true,
+}
+
+func (s *OrderService) AddOrderItem(ctx context.Context, orderID string, expectedVersion int, item models.OrderItem) (*models.Order, error) {
+	return s.amendOrder(ctx, orderID, expectedVersion, func(order *models.Order) error {
+		order.Items = append(order.Items, item)
+		return nil
+	})
+}
+
+func (s *OrderService) ChangeOrderItemQuantity(ctx context.Context, orderID string, expectedVersion int, productID string, quantity int) (*models.Order, error) {
+	return s.amendOrder(ctx, orderID, expectedVersion, func(order *models.Order) error {
+		i, err := findOrderItem(order, productID)
+		if err != nil {
+			return err
+		}
+		order.Items[i].Quantity = quantity
+		return nil
+	})
+}
+
+func (s *OrderService) RemoveOrderItem(ctx context.Context, orderID string, expectedVersion int, productID string) (*models.Order, error) {
+	return s.amendOrder(ctx, orderID, expectedVersion, func(order *models.Order) error {
+		i, err := findOrderItem(order, productID)
+		if err != nil {
+			return err
+		}
+		order.Items = append(order.Items[:i], order.Items[i+1:]...)
+		return nil
+	})
+}
+
+// amendOrder applies change to the locked order, validates the result, recomputes the total
+// and publishes OrderAmended. The linked assembly application is picked by the order items,
+// so it sees the new lines as soon as the transaction commits; once a picker has taken it
+// the order fails with ErrOrderNotAmendable.
+// A non-zero expectedVersion must match the current order version.
+func (s *OrderService) amendOrder(
+	ctx context.Context,
+	orderID string,
+	expectedVersion int,
+	change repository.OrderAmendFunc,
+) (*models.Order, error) {
+	amend := func(order *models.Order) error {
+		if !amendableStatuses[order.Status] {
+			return fmt.Errorf("%w: order %s is %s", ErrOrderNotAmendable, order.ID, order.Status)
+		}
+
+		if err := change(order); err != nil {
+			return err
+		}
+
+		if err := s.validateOrder(order); err != nil {
+			return err
+		}
+
+		total, err := itemsTotal(order.Items)
+		if err != nil {
+			return err
+		}
+		order.TotalAmount = total
+
+		return nil
+	}
+
+	event := func(order *models.Order) (*repository.OutboxMessage, error) {
+		return s.orderEvent(events.OrderAmended, order)
+	}
+
+	order, err := s.repo.AmendOrder(ctx, orderID, expectedVersion, amend, event)
+	if errors.Is(err, repository.ErrAssemblyApplicationPicking) {
+		err = fmt.Errorf("%w: %v", ErrOrderNotAmendable, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to amend order: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] order with ID %s amended, total %s", order.ID, order.TotalAmount)
+
+	return order, nil
+}
+
+func findOrderItem(order *models.Order, productID string) (int, error) {
+	for i, item := range order.Items {
+		if item.ProductID == productID {
+			return i, nil
+		}
+	}
+	return -1, fmt.Errorf("%w: product %s in order %s", repository.ErrOrderItemNotFound, productID, order.ID)
+}
@@ -2,124 +2,292 @@ package service
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
+	"unicode/utf8"
 
-	"github.com/IBM/sarama"
 	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
 	"github.com/milovidov983/oms-temporal-demo/shared/events"
 	"github.com/milovidov983/oms-temporal-demo/shared/models"
 )
 
 type AssemblyApplicationService struct {
-	repo  repository.AssemblyApplicationRepository
-	kafka sarama.SyncProducer
-	topic string
+	repo   repository.AssemblyApplicationRepository
+	router *AssemblyRouter
+	sla    *AssemblySLA
+	topic  string
 }
 
 func NewAssemblyApplicationService(
 	repo repository.AssemblyApplicationRepository,
-	kafka sarama.SyncProducer,
+	router *AssemblyRouter,
+	sla *AssemblySLA,
 	topic string,
 ) *AssemblyApplicationService {
 	return &AssemblyApplicationService{
-		repo:  repo,
-		kafka: kafka,
-		topic: topic,
+		repo:   repo,
+		router: router,
+		sla:    sla,
+		topic:  topic,
 	}
 }
 
+// CreateAssemblyApplication creates the application in the warehouse chosen by the assembly routing.
+// warehouseID is the explicit choice of the caller and may be empty. The application is due
+// by the SLA of the warehouse for orderType, an empty orderType means ASSEMBLY.
 func (s *AssemblyApplicationService) CreateAssemblyApplication(
 	ctx context.Context,
 	orderID string,
+	warehouseID string,
+	orderType models.OrderType,
 ) (*models.AssemblyApplication, error) {
-	application, err := s.repo.Create(ctx, orderID)
+	if orderType == "" {
+		orderType = models.OrderTypeAssembly
+	}
+	if orderType != models.OrderTypeAssembly && orderType != models.OrderTypeDelivery {
+		verr := &ValidationError{}
+		verr.add("order_type", "must be %s or %s", models.OrderTypeAssembly, models.OrderTypeDelivery)
+		return nil, verr
+	}
 
+	warehouseID, err := s.router.Route(ctx, orderID, warehouseID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to save assembly application: %w", err)
+		return nil, fmt.Errorf("failed to route assembly application: %w", err)
 	}
-	log.Printf("[debug] assembly application with ID %s saved to database", application.ID)
+	log.Printf("[debug] order %s routed to warehouse %s", orderID, warehouseID)
 
-	if err := s.publishAssemblyApplication(application); err != nil {
-		return nil, fmt.Errorf("failed to publish assembly application event: %w", err)
+	sla, err := s.sla.Duration(ctx, warehouseID, orderType)
+	if err != nil {
+		return nil, err
 	}
 
+	draft := repository.NewAssemblyApplication{
+		OrderID:     orderID,
+		WarehouseID: warehouseID,
+		OrderType:   orderType,
+		SLA:         sla,
+	}
+	application, err := s.repo.Create(ctx, draft, s.publishAssemblyApplication)
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to save assembly application: %w", err)
+	}
+	log.Printf("[debug] assembly application with ID %s saved to database", application.ID)
+
 	return application, nil
 }
 
+// CompleteAssembly completes the application with the quantities actually collected and the packages
+// the order was packed into. nil collected means every line was collected in full.
+// AssemblyCompleted carries the packages for the delivery stage.
 func (s *AssemblyApplicationService) CompleteAssembly(
 	ctx context.Context,
 	applicationID string,
+	expectedVersion int,
+	collected []models.AssemblyItem,
+	packages []models.Package,
 ) error {
-	application, err := s.repo.Complete(ctx, applicationID)
+	if err := validateCollected(collected); err != nil {
+		return err
+	}
+	if err := validatePackages(packages); err != nil {
+		return err
+	}
+
+	_, err := s.repo.Complete(ctx, applicationID, expectedVersion, collected, packages, s.publishAssemblyApplicationCompleted)
 	if err != nil {
-		return fmt.Errorf("failed to complete assembly: %w", err)
+		return fmt.Errorf("failed to complete assembly: %w", preconditionError(err, expectedVersion))
 	}
 	log.Printf("[debug] assembly application with ID %s completed", applicationID)
 
-	if err := s.publishAssemblyApplicationCompleted(application); err != nil {
-		return fmt.Errorf("failed to publish assembly application completed event: %w", err)
+	return nil
+}
+
+// MaxCancelReasonLength limits the reason of an assembly cancellation or rejection.
+const MaxCancelReasonLength = 255
+
+// withReason validates the reason of a cancellation or rejection and puts it into the status change
+// of ctx, so it is recorded in the order history.
+func withReason(ctx context.Context, reason string) (context.Context, repository.StatusChange, error) {
+	reason = strings.TrimSpace(reason)
+	verr := &ValidationError{}
+	if reason == "" {
+		verr.add("reason", "is required")
+	} else if utf8.RuneCountInString(reason) > MaxCancelReasonLength {
+		verr.add("reason", "must be at most %d characters", MaxCancelReasonLength)
+	}
+	if err := verr.errOrNil(); err != nil {
+		return ctx, repository.StatusChange{}, err
 	}
 
-	return nil
+	change := repository.StatusChangeFromContext(ctx)
+	change.Reason = reason
+	return repository.WithStatusChange(ctx, change), change, nil
 }
 
+// CancelAssembly cancels the application with the reason recorded in the order history. The lines
+// no longer reserve the warehouse stock and the order returns to CREATED. AssemblyCancelled carries
+// the reason, the order workflow decides whether to route the order to another warehouse or cancel it.
+// A complete application can be canceled only after its order was canceled, the collected items
+// are returned to the warehouse stock.
 func (s *AssemblyApplicationService) CancelAssembly(
 	ctx context.Context,
 	applicationID string,
+	expectedVersion int,
+	reason string,
 ) error {
-	err := s.repo.Cancel(ctx, applicationID)
+	ctx, change, err := withReason(ctx, reason)
 	if err != nil {
-		return fmt.Errorf("failed to get assembly application: %w", err)
+		return err
 	}
-	log.Printf("[debug] assembly application with ID %s canceled", applicationID)
 
-	if err := s.publishAssemblyApplicationCanceled(applicationID); err != nil {
-		return fmt.Errorf("failed to publish assembly application canceled event: %w", err)
+	err = s.repo.Cancel(ctx, applicationID, expectedVersion, s.publishAssemblyApplicationCanceled(change))
+	if err != nil {
+		return fmt.Errorf("failed to cancel assembly application: %w", preconditionError(err, expectedVersion))
 	}
+	log.Printf("[debug] assembly application with ID %s canceled", applicationID)
 
 	return nil
 }
 
-func (s *AssemblyApplicationService) publishAssemblyApplicationCanceled(
+// MarkOverdue escalates the application that missed its SLA and publishes AssemblyOverdue.
+// It is called by the order workflow when its SLA timer fires.
+func (s *AssemblyApplicationService) MarkOverdue(ctx context.Context, applicationID string) (*models.AssemblyApplication, error) {
+	event := func(application *models.AssemblyApplication) (*repository.OutboxMessage, error) {
+		log.Printf("[debug] publishing assembly overdue event for ID %s", application.ID)
+
+		return s.assemblyEvent(events.AssemblyOverdue, application)
+	}
+
+	application, err := s.repo.MarkOverdue(ctx, applicationID, event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark assembly application overdue: %w", err)
+	}
+	log.Printf("[info] assembly application with ID %s is overdue since %v", applicationID, application.DueAt)
+
+	return application, nil
+}
+
+// ListOverdue returns the open applications of the warehouse past their SLA, the most overdue first.
+// An empty warehouseID lists all warehouses.
+func (s *AssemblyApplicationService) ListOverdue(ctx context.Context, warehouseID string, limit int) ([]models.AssemblyApplication, error) {
+	applications, err := s.repo.ListOverdue(ctx, warehouseID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list overdue assembly applications: %w", err)
+	}
+
+	return applications, nil
+}
+
+// MaxAssemblyCommentLength is the size of the comment column.
+const MaxAssemblyCommentLength = 255
+
+// ChangeComment stores a comment of the picker or operator and publishes AssemblyCommentChanged,
+// so the order workflow keeps the comment history. A non-zero expectedVersion must match the application version.
+func (s *AssemblyApplicationService) ChangeComment(
+	ctx context.Context,
 	applicationID string,
-) error {
-	log.Printf("[debug] publishing assembly application canceled event for ID %s", applicationID)
-	return nil
+	comment string,
+	expectedVersion int,
+) (*models.AssemblyApplication, error) {
+	comment = strings.TrimSpace(comment)
+	if utf8.RuneCountInString(comment) > MaxAssemblyCommentLength {
+		verr := &ValidationError{}
+		verr.add("comment", "must be at most %d characters", MaxAssemblyCommentLength)
+		return nil, verr
+	}
+
+	actor := repository.StatusChangeFromContext(ctx).Actor
+	event := func(application *models.AssemblyApplication) (*repository.OutboxMessage, error) {
+		log.Printf("[debug] publishing assembly comment changed event for ID %s", application.ID)
+
+		return s.assemblyEvent(events.AssemblyCommentChanged, application, func(data *events.AssemblyEventData) {
+			data.Actor = actor
+		})
+	}
+
+	application, err := s.repo.ChangeComment(ctx, applicationID, comment, expectedVersion, event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to change assembly comment: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] comment of assembly application with ID %s changed", applicationID)
+
+	return application, nil
+}
+
+// Publishers below are called by the repository inside the transaction,
+// the resulting messages are sent to Kafka by the outbox relay.
+
+func (s *AssemblyApplicationService) publishAssemblyApplicationCanceled(change repository.StatusChange) repository.AssemblyEventFunc {
+	return func(application *models.AssemblyApplication) (*repository.OutboxMessage, error) {
+		log.Printf("[debug] publishing assembly application canceled event for ID %s", application.ID)
+
+		return s.assemblyEvent(events.AssemblyCancelled, application, func(data *events.AssemblyEventData) {
+			data.Actor = change.Actor
+			data.Reason = change.Reason
+		})
+	}
 }
 
 func (s *AssemblyApplicationService) publishAssemblyApplication(
 	application *models.AssemblyApplication,
-) error {
+) (*repository.OutboxMessage, error) {
 	log.Printf("[debug] publishing assembly application event for ID %s and status: %v and order %s", application.ID, application.Status, application.OrderID)
-	return nil
+
+	return s.assemblyEvent(events.AssemblyCreated, application)
 }
 
 func (s *AssemblyApplicationService) publishAssemblyApplicationCompleted(
 	application *models.AssemblyApplication,
-) error {
+) (*repository.OutboxMessage, error) {
 	log.Printf("[debug] publishing assembly application event for ID %s and status: %v and order %s", application.ID, application.Status, application.OrderID)
 
+	return s.assemblyEvent(events.AssemblyCompleted, application)
+}
+
+// assemblyEvent uses order ID as the message key, so all assembly events of one order keep their order.
+// A complete application also reports what was collected and what is missing.
+func (s *AssemblyApplicationService) assemblyEvent(
+	eventType events.EventType,
+	application *models.AssemblyApplication,
+	options ...func(data *events.AssemblyEventData),
+) (*repository.OutboxMessage, error) {
 	event := &events.AssemblyApplicationEvent{
-		EventType: events.AssemblyCompleted,
+		EventType: eventType,
 		EventData: events.AssemblyEventData{
-			ID:      application.ID,
-			OrderID: application.OrderID,
+			ID:          application.ID,
+			OrderID:     application.OrderID,
+			WarehouseID: application.WarehouseID,
+			Status:      application.Status,
+			Items:       application.Items,
+			Comment:     application.Comment,
+			DueAt:       application.DueAt,
 		},
 	}
-
-	msg, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal assembly event: %w", err)
+	for _, option := range options {
+		option(&event.EventData)
 	}
 
-	partition, offset, err := s.kafka.SendMessage(&sarama.ProducerMessage{
-		Topic: s.topic,
-		Value: sarama.StringEncoder(msg),
-	})
-
-	log.Printf("[debug] event published to kafka topic %s, partition %d, offset %d", s.topic, partition, offset)
+	if application.Status == models.AssemblyStatusComplete {
+		event.EventData.Packages = application.Packages
+		for _, item := range application.Items {
+			if item.Collected > 0 {
+				event.EventData.Collected = append(event.EventData.Collected, models.OrderItem{
+					ProductID: item.ProductID,
+					Quantity:  item.Collected,
+					Price:     item.Price,
+				})
+			}
+			if shortage := item.Shortage(); shortage > 0 {
+				event.EventData.Shortages = append(event.EventData.Shortages, models.OrderItem{
+					ProductID: item.ProductID,
+					Quantity:  shortage,
+					Price:     item.Price,
+				})
+			}
+		}
+	}
 
-	return err
+	return repository.NewOutboxMessage(s.topic, application.OrderID, event)
 }
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/label"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+var ErrNoPackages = errors.New("assembly application has no packages")
+
+const (
+	// MaxAssemblyPackages limits the packages of one assembly
+	MaxAssemblyPackages = 50
+	// MaxPackageBarcodeLength keeps the Code 128 barcode readable on a 4 inch label
+	MaxPackageBarcodeLength = 32
+)
+
+// Label renders the shipping labels of the packages of a complete application.
+// It fails with ErrNoPackages when no packages were recorded.
+func (s *AssemblyApplicationService) Label(ctx context.Context, applicationID string, format label.Format) ([]byte, error) {
+	if !format.IsValid() {
+		verr := &ValidationError{}
+		verr.add("format", "must be %s or %s", label.FormatPDF, label.FormatZPL)
+		return nil, verr
+	}
+
+	application, err := s.repo.Get(ctx, applicationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get assembly application: %w", err)
+	}
+	if len(application.Packages) == 0 {
+		return nil, fmt.Errorf("%w: assembly application %s is %s", ErrNoPackages, applicationID, application.Status)
+	}
+
+	document, err := label.Render(format, label.Labels(application))
+	if err != nil {
+		return nil, fmt.Errorf("failed to render labels: %w", err)
+	}
+	log.Printf("[debug] %d labels of assembly application %s rendered as %s", len(application.Packages), applicationID, format)
+
+	return document, nil
+}
+
+// validatePackages checks the packages reported on completion. A barcode is optional,
+// oms-core generates one for a package without it.
+func validatePackages(packages []models.Package) error {
+	verr := &ValidationError{}
+
+	if len(packages) > MaxAssemblyPackages {
+		verr.add("packages", "must contain at most %d packages", MaxAssemblyPackages)
+	}
+
+	seen := make(map[string]int, len(packages))
+	for i, p := range packages {
+		field := fmt.Sprintf("packages[%d]", i)
+
+		if p.WeightGrams <= 0 {
+			verr.add(field+".weight_grams", "must be > 0")
+		}
+		if p.LengthMM <= 0 {
+			verr.add(field+".length_mm", "must be > 0")
+		}
+		if p.WidthMM <= 0 {
+			verr.add(field+".width_mm", "must be > 0")
+		}
+		if p.HeightMM <= 0 {
+			verr.add(field+".height_mm", "must be > 0")
+		}
+
+		switch {
+		case p.Barcode == "":
+		case len(p.Barcode) > MaxPackageBarcodeLength:
+			verr.add(field+".barcode", "must be at most %d characters", MaxPackageBarcodeLength)
+		case strings.IndexFunc(p.Barcode, func(r rune) bool { return r < 33 || r > 126 }) >= 0:
+			verr.add(field+".barcode", "must contain printable ASCII characters without spaces")
+		default:
+			if first, ok := seen[p.Barcode]; ok {
+				verr.add(field+".barcode", "duplicates packages[%d]", first)
+			} else {
+				seen[p.Barcode] = i
+			}
+		}
+	}
+
+	return verr.errOrNil()
+}
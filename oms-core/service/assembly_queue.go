@@ -0,0 +1,86 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// ListQueue returns the applications of the warehouse waiting for a picker, the oldest first.
+// An empty warehouseID lists all warehouses.
+func (s *AssemblyApplicationService) ListQueue(ctx context.Context, warehouseID string, limit int) ([]models.AssemblyApplication, error) {
+	applications, err := s.repo.ListQueue(ctx, warehouseID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list assembly queue: %w", err)
+	}
+
+	return applications, nil
+}
+
+// ListPickerApplications returns what the picker is working on.
+func (s *AssemblyApplicationService) ListPickerApplications(ctx context.Context, pickerID string) ([]models.AssemblyApplication, error) {
+	applications, err := s.repo.ListPickerApplications(ctx, pickerID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list picker applications: %w", err)
+	}
+
+	return applications, nil
+}
+
+// ClaimNext gives the oldest waiting application of the warehouse to the picker.
+func (s *AssemblyApplicationService) ClaimNext(ctx context.Context, pickerID, warehouseID string) (*models.AssemblyApplication, error) {
+	application, err := s.repo.ClaimNext(ctx, pickerID, warehouseID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim assembly application: %w", err)
+	}
+	log.Printf("[debug] assembly application with ID %s claimed by picker %s", application.ID, pickerID)
+
+	return application, nil
+}
+
+func (s *AssemblyApplicationService) Claim(
+	ctx context.Context,
+	applicationID string,
+	pickerID string,
+	expectedVersion int,
+) (*models.AssemblyApplication, error) {
+	application, err := s.repo.Claim(ctx, applicationID, pickerID, expectedVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim assembly application: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] assembly application with ID %s claimed by picker %s", applicationID, pickerID)
+
+	return application, nil
+}
+
+func (s *AssemblyApplicationService) StartAssembly(
+	ctx context.Context,
+	applicationID string,
+	pickerID string,
+	expectedVersion int,
+) (*models.AssemblyApplication, error) {
+	application, err := s.repo.Start(ctx, applicationID, pickerID, expectedVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start assembly: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] assembly application with ID %s started by picker %s", applicationID, pickerID)
+
+	return application, nil
+}
+
+func (s *AssemblyApplicationService) Release(
+	ctx context.Context,
+	applicationID string,
+	pickerID string,
+	expectedVersion int,
+) (*models.AssemblyApplication, error) {
+	application, err := s.repo.Release(ctx, applicationID, pickerID, expectedVersion)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release assembly application: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] assembly application with ID %s released by picker %s", applicationID, pickerID)
+
+	return application, nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+	"github.com/milovidov983/oms-temporal-demo/shared/events"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// RejectAssembly rejects a complete application that failed the quality check and creates the next attempt
+// in the same warehouse, due by its SLA. The reason is required and is recorded in the order history.
+// AssemblyRejected and AssemblyCreated of the next attempt are published together, the order workflow
+// goes back to the assembly stage. It returns the next attempt.
+func (s *AssemblyApplicationService) RejectAssembly(
+	ctx context.Context,
+	applicationID string,
+	expectedVersion int,
+	reason string,
+) (*models.AssemblyApplication, error) {
+	ctx, change, err := withReason(ctx, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	next, err := s.repo.Reject(ctx, applicationID, expectedVersion, change.Reason, s.sla.Duration, s.publishReassembly(change))
+	if err != nil {
+		return nil, fmt.Errorf("failed to reject assembly application: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] assembly application with ID %s rejected, attempt %d is %s", applicationID, next.Attempt, next.ID)
+
+	return next, nil
+}
+
+// AcceptAssembly is called by the order workflow when it moves past the assembly, e.g. to capture the payment
+// or to pass the order to delivery. From then on RejectAssembly refuses the application.
+func (s *AssemblyApplicationService) AcceptAssembly(ctx context.Context, applicationID string) (*models.AssemblyApplication, error) {
+	application, err := s.repo.Accept(ctx, applicationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to accept assembly application: %w", err)
+	}
+	log.Printf("[debug] assembly application with ID %s accepted", applicationID)
+
+	return application, nil
+}
+
+// ListAttempts returns the assembly applications of the order, the first attempt first.
+func (s *AssemblyApplicationService) ListAttempts(ctx context.Context, orderID string) ([]models.AssemblyApplication, error) {
+	applications, err := s.repo.ListAttempts(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list assembly attempts: %w", err)
+	}
+
+	return applications, nil
+}
+
+func (s *AssemblyApplicationService) publishReassembly(change repository.StatusChange) repository.ReassemblyEventFunc {
+	return func(rejected, next *models.AssemblyApplication) ([]*repository.OutboxMessage, error) {
+		log.Printf("[debug] publishing assembly application rejected event for ID %s", rejected.ID)
+
+		rejectedMessage, err := s.assemblyEvent(events.AssemblyRejected, rejected, func(data *events.AssemblyEventData) {
+			data.Actor = change.Actor
+			data.Reason = change.Reason
+			data.NextApplicationID = next.ID
+			data.NextDueAt = next.DueAt
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		createdMessage, err := s.publishAssemblyApplication(next)
+		if err != nil {
+			return nil, err
+		}
+
+		return []*repository.OutboxMessage{rejectedMessage, createdMessage}, nil
+	}
+}
@@ -0,0 +1,140 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+	"github.com/milovidov983/oms-temporal-demo/shared/events"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// ProposeSubstitution records a substitute for a product the picker could not find and publishes
+// SubstitutionProposed. The order workflow waits for the customer to approve or reject it.
+func (s *AssemblyApplicationService) ProposeSubstitution(
+	ctx context.Context,
+	applicationID string,
+	substitution models.Substitution,
+	expectedVersion int,
+) (*models.AssemblyApplication, error) {
+	if err := validateSubstitution(substitution); err != nil {
+		return nil, err
+	}
+
+	event := s.substitutionEvent(ctx, events.SubstitutionProposed, lastSubstitution)
+
+	application, err := s.repo.ProposeSubstitution(ctx, applicationID, substitution, expectedVersion, event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to propose substitution: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] substitution %s proposed for assembly application %s", lastSubstitution(application).ID, applicationID)
+
+	return application, nil
+}
+
+// ApproveSubstitution applies the substitute to the order and the assembly application
+// and publishes SubstitutionApproved.
+func (s *AssemblyApplicationService) ApproveSubstitution(
+	ctx context.Context,
+	applicationID string,
+	substitutionID string,
+	expectedVersion int,
+) (*models.AssemblyApplication, error) {
+	return s.decideSubstitution(ctx, applicationID, substitutionID, true, expectedVersion)
+}
+
+// RejectSubstitution keeps the line as it is and publishes SubstitutionRejected.
+func (s *AssemblyApplicationService) RejectSubstitution(
+	ctx context.Context,
+	applicationID string,
+	substitutionID string,
+	expectedVersion int,
+) (*models.AssemblyApplication, error) {
+	return s.decideSubstitution(ctx, applicationID, substitutionID, false, expectedVersion)
+}
+
+func (s *AssemblyApplicationService) decideSubstitution(
+	ctx context.Context,
+	applicationID string,
+	substitutionID string,
+	approve bool,
+	expectedVersion int,
+) (*models.AssemblyApplication, error) {
+	eventType := events.SubstitutionRejected
+	if approve {
+		eventType = events.SubstitutionApproved
+	}
+
+	find := func(application *models.AssemblyApplication) *models.Substitution {
+		for i := range application.Substitutions {
+			if application.Substitutions[i].ID == substitutionID {
+				return &application.Substitutions[i]
+			}
+		}
+		return nil
+	}
+	event := s.substitutionEvent(ctx, eventType, find)
+
+	application, err := s.repo.DecideSubstitution(ctx, applicationID, substitutionID, approve, expectedVersion, event)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decide substitution: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] substitution %s of assembly application %s is %s", substitutionID, applicationID, find(application).Status)
+
+	return application, nil
+}
+
+// substitutionEvent builds the event about the substitution that find picks from the changed application.
+func (s *AssemblyApplicationService) substitutionEvent(
+	ctx context.Context,
+	eventType events.EventType,
+	find func(application *models.AssemblyApplication) *models.Substitution,
+) repository.AssemblyEventFunc {
+	actor := repository.StatusChangeFromContext(ctx).Actor
+
+	return func(application *models.AssemblyApplication) (*repository.OutboxMessage, error) {
+		substitution := find(application)
+		if substitution == nil {
+			return nil, fmt.Errorf("substitution is missing in assembly application %s", application.ID)
+		}
+		log.Printf("[debug] publishing %s event for assembly application %s", eventType, application.ID)
+
+		return s.assemblyEvent(eventType, application, func(data *events.AssemblyEventData) {
+			data.Substitution = substitution
+			data.Actor = actor
+		})
+	}
+}
+
+// lastSubstitution returns the latest proposal, substitutions are ordered by the time they were proposed.
+func lastSubstitution(application *models.AssemblyApplication) *models.Substitution {
+	if len(application.Substitutions) == 0 {
+		return nil
+	}
+	return &application.Substitutions[len(application.Substitutions)-1]
+}
+
+func validateSubstitution(substitution models.Substitution) error {
+	verr := &ValidationError{}
+
+	if strings.TrimSpace(substitution.ProductID) == "" {
+		verr.add("product_id", "is required")
+	}
+	if strings.TrimSpace(substitution.SubstituteProductID) == "" {
+		verr.add("substitute_product_id", "is required")
+	} else if substitution.SubstituteProductID == substitution.ProductID {
+		verr.add("substitute_product_id", "must differ from product_id")
+	}
+	if substitution.Quantity <= 0 {
+		verr.add("quantity", "must be > 0")
+	}
+	if substitution.Price < 0 {
+		verr.add("price", "must be >= 0")
+	} else if _, err := itemsTotal([]models.OrderItem{{Quantity: substitution.Quantity, Price: substitution.Price}}); err != nil {
+		verr.add("price", "must not exceed %s in total", models.MaxMoney)
+	}
+
+	return verr.errOrNil()
+}
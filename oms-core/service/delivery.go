@@ -0,0 +1,265 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+	"github.com/milovidov983/oms-temporal-demo/shared/events"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// MaxProofRefLength is the size of the proof of delivery columns.
+const MaxProofRefLength = 1024
+
+type DeliveryApplicationService struct {
+	repo  repository.DeliveryApplicationRepository
+	topic string
+}
+
+func NewDeliveryApplicationService(repo repository.DeliveryApplicationRepository, topic string) *DeliveryApplicationService {
+	return &DeliveryApplicationService{
+		repo:  repo,
+		topic: topic,
+	}
+}
+
+// CreateDeliveryApplication passes the assembled order to delivery. DeliveryCreated carries the packages
+// the courier has to pick up.
+func (s *DeliveryApplicationService) CreateDeliveryApplication(ctx context.Context, orderID string) (*models.DeliveryApplication, error) {
+	application, err := s.repo.Create(ctx, orderID, s.publish(events.DeliveryCreated))
+	if err != nil {
+		return nil, fmt.Errorf("failed to save delivery application: %w", err)
+	}
+	log.Printf("[debug] delivery application with ID %s saved to database", application.ID)
+
+	return application, nil
+}
+
+func (s *DeliveryApplicationService) Get(ctx context.Context, applicationID string) (*models.DeliveryApplication, error) {
+	application, err := s.repo.Get(ctx, applicationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get delivery application: %w", err)
+	}
+
+	return application, nil
+}
+
+// ListByOrder returns the delivery attempts of the order.
+func (s *DeliveryApplicationService) ListByOrder(ctx context.Context, orderID string) ([]models.DeliveryApplication, error) {
+	applications, err := s.repo.ListByOrder(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list delivery applications: %w", err)
+	}
+
+	return applications, nil
+}
+
+// ListCourierApplications returns what the courier is delivering.
+func (s *DeliveryApplicationService) ListCourierApplications(ctx context.Context, courierID string) ([]models.DeliveryApplication, error) {
+	applications, err := s.repo.ListCourierApplications(ctx, courierID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list courier applications: %w", err)
+	}
+
+	return applications, nil
+}
+
+func (s *DeliveryApplicationService) Assign(
+	ctx context.Context,
+	applicationID string,
+	courierID string,
+	expectedVersion int,
+) (*models.DeliveryApplication, error) {
+	if err := validateCourier(courierID); err != nil {
+		return nil, err
+	}
+
+	application, err := s.repo.Assign(ctx, applicationID, courierID, expectedVersion, s.publish(events.DeliveryAssigned))
+	if err != nil {
+		return nil, fmt.Errorf("failed to assign delivery application: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] delivery application with ID %s assigned to courier %s", applicationID, courierID)
+
+	return application, nil
+}
+
+func (s *DeliveryApplicationService) Release(
+	ctx context.Context,
+	applicationID string,
+	courierID string,
+	expectedVersion int,
+) (*models.DeliveryApplication, error) {
+	if err := validateCourier(courierID); err != nil {
+		return nil, err
+	}
+
+	application, err := s.repo.Release(ctx, applicationID, courierID, expectedVersion, s.publish(events.DeliveryReleased))
+	if err != nil {
+		return nil, fmt.Errorf("failed to release delivery application: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] delivery application with ID %s released by courier %s", applicationID, courierID)
+
+	return application, nil
+}
+
+// PickUp publishes DeliveryPickedUp, the order workflow treats it as the start of the delivery.
+func (s *DeliveryApplicationService) PickUp(
+	ctx context.Context,
+	applicationID string,
+	courierID string,
+	expectedVersion int,
+) (*models.DeliveryApplication, error) {
+	if err := validateCourier(courierID); err != nil {
+		return nil, err
+	}
+
+	application, err := s.repo.PickUp(ctx, applicationID, courierID, expectedVersion, s.publish(events.DeliveryPickedUp))
+	if err != nil {
+		return nil, fmt.Errorf("failed to pick up delivery application: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] delivery application with ID %s picked up by courier %s", applicationID, courierID)
+
+	return application, nil
+}
+
+// Deliver closes the application with the proof of delivery: the reference to the photo is required,
+// the reference to the signature of the recipient is optional.
+func (s *DeliveryApplicationService) Deliver(
+	ctx context.Context,
+	applicationID string,
+	courierID string,
+	proof models.ProofOfDelivery,
+	expectedVersion int,
+) (*models.DeliveryApplication, error) {
+	proof.PhotoRef = strings.TrimSpace(proof.PhotoRef)
+	proof.SignatureRef = strings.TrimSpace(proof.SignatureRef)
+
+	verr := &ValidationError{}
+	if strings.TrimSpace(courierID) == "" {
+		verr.add("courier_id", "is required")
+	}
+	if proof.PhotoRef == "" {
+		verr.add("proof_of_delivery.photo_ref", "is required")
+	} else if utf8.RuneCountInString(proof.PhotoRef) > MaxProofRefLength {
+		verr.add("proof_of_delivery.photo_ref", "must be at most %d characters", MaxProofRefLength)
+	}
+	if utf8.RuneCountInString(proof.SignatureRef) > MaxProofRefLength {
+		verr.add("proof_of_delivery.signature_ref", "must be at most %d characters", MaxProofRefLength)
+	}
+	if err := verr.errOrNil(); err != nil {
+		return nil, err
+	}
+
+	application, err := s.repo.Deliver(ctx, applicationID, courierID, proof, expectedVersion, s.publish(events.DeliveryDelivered))
+	if err != nil {
+		return nil, fmt.Errorf("failed to complete delivery: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] delivery application with ID %s delivered by courier %s", applicationID, courierID)
+
+	return application, nil
+}
+
+// Fail records that the courier could not deliver the order. The order returns to ASSEMBLED,
+// DeliveryFailed carries the reason for the order workflow.
+func (s *DeliveryApplicationService) Fail(
+	ctx context.Context,
+	applicationID string,
+	courierID string,
+	reason string,
+	expectedVersion int,
+) (*models.DeliveryApplication, error) {
+	if err := validateCourier(courierID); err != nil {
+		return nil, err
+	}
+	ctx, change, err := withReason(ctx, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	application, err := s.repo.Fail(ctx, applicationID, courierID, change.Reason, expectedVersion, s.publish(events.DeliveryFailed, withChange(change)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to record delivery failure: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] delivery application with ID %s failed by courier %s", applicationID, courierID)
+
+	return application, nil
+}
+
+// CancelDelivery withdraws the order from delivery, e.g. because the order was canceled.
+func (s *DeliveryApplicationService) CancelDelivery(
+	ctx context.Context,
+	applicationID string,
+	expectedVersion int,
+	reason string,
+) (*models.DeliveryApplication, error) {
+	ctx, change, err := withReason(ctx, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	application, err := s.repo.Cancel(ctx, applicationID, expectedVersion, s.publish(events.DeliveryCancelled, withChange(change)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to cancel delivery application: %w", preconditionError(err, expectedVersion))
+	}
+	log.Printf("[debug] delivery application with ID %s canceled", applicationID)
+
+	return application, nil
+}
+
+func validateCourier(courierID string) error {
+	verr := &ValidationError{}
+	if strings.TrimSpace(courierID) == "" {
+		verr.add("courier_id", "is required")
+	}
+	return verr.errOrNil()
+}
+
+// publish is called by the repository inside the transaction, the resulting message is sent to Kafka
+// by the outbox relay.
+func (s *DeliveryApplicationService) publish(
+	eventType events.EventType,
+	options ...func(data *events.DeliveryEventData),
+) repository.DeliveryEventFunc {
+	return func(application *models.DeliveryApplication) (*repository.OutboxMessage, error) {
+		log.Printf("[debug] publishing %s event for delivery application %s and order %s", eventType, application.ID, application.OrderID)
+
+		return s.deliveryEvent(eventType, application, options...)
+	}
+}
+
+// withChange puts who changed the application and why into the event.
+func withChange(change repository.StatusChange) func(data *events.DeliveryEventData) {
+	return func(data *events.DeliveryEventData) {
+		data.Actor = change.Actor
+		data.Reason = change.Reason
+	}
+}
+
+// deliveryEvent uses order ID as the message key, so all delivery events of one order keep their order.
+func (s *DeliveryApplicationService) deliveryEvent(
+	eventType events.EventType,
+	application *models.DeliveryApplication,
+	options ...func(data *events.DeliveryEventData),
+) (*repository.OutboxMessage, error) {
+	event := &events.DeliveryApplicationEvent{
+		EventType: eventType,
+		EventData: events.DeliveryEventData{
+			ID:                    application.ID,
+			OrderID:               application.OrderID,
+			AssemblyApplicationID: application.AssemblyApplicationID,
+			Status:                application.Status,
+			CourierID:             application.CourierID,
+			Packages:              application.Packages,
+			ProofOfDelivery:       application.ProofOfDelivery,
+		},
+	}
+	for _, option := range options {
+		option(&event.EventData)
+	}
+
+	return repository.NewOutboxMessage(s.topic, application.OrderID, event)
+}
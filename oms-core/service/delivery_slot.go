@@ -0,0 +1,158 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// maxZoneLength is the size of the delivery_slots.zone column.
+const maxZoneLength = 64
+
+type DeliverySlotConfig struct {
+	// ReservationTTL is how long a place reserved at checkout is held for the order
+	ReservationTTL time.Duration
+}
+
+func (cfg *DeliverySlotConfig) Check() {
+	if cfg.ReservationTTL <= 0 {
+		log.Fatal("[fatal] Delivery slot ReservationTTL is not set")
+	}
+}
+
+// DeliverySlotService manages the delivery windows of the zones. The cart reserves a place at checkout,
+// the order created with the reservation confirms it, see OrderService.CreateOrder.
+type DeliverySlotService struct {
+	repo   *repository.DeliverySlotRepository
+	config DeliverySlotConfig
+}
+
+func NewDeliverySlotService(repo *repository.DeliverySlotRepository, cfg DeliverySlotConfig) *DeliverySlotService {
+	cfg.Check()
+
+	return &DeliverySlotService{
+		repo:   repo,
+		config: cfg,
+	}
+}
+
+func (s *DeliverySlotService) CreateSlot(ctx context.Context, slot *models.DeliverySlot) error {
+	slot.Zone = strings.TrimSpace(slot.Zone)
+
+	verr := &ValidationError{}
+	if slot.Zone == "" {
+		verr.add("zone", "is required")
+	} else if len(slot.Zone) > maxZoneLength {
+		verr.add("zone", "must be at most %d characters", maxZoneLength)
+	}
+	if slot.StartsAt.IsZero() {
+		verr.add("starts_at", "is required")
+	}
+	if !slot.EndsAt.After(slot.StartsAt) {
+		verr.add("ends_at", "must be after starts_at")
+	}
+	if slot.Capacity <= 0 {
+		verr.add("capacity", "must be positive")
+	}
+	if err := verr.errOrNil(); err != nil {
+		return err
+	}
+
+	slot.Booked = 0
+	if err := s.repo.CreateSlot(ctx, slot); err != nil {
+		return fmt.Errorf("failed to create delivery slot: %w", err)
+	}
+	log.Printf("[debug] delivery slot with ID %s created in zone %s", slot.ID, slot.Zone)
+
+	return nil
+}
+
+// ListSlots returns the slots of the zone that start on the day of day in its location.
+func (s *DeliverySlotService) ListSlots(ctx context.Context, zone string, day time.Time) ([]models.DeliverySlot, error) {
+	from := time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, day.Location())
+
+	slots, err := s.repo.ListSlots(ctx, zone, from, from.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list delivery slots: %w", err)
+	}
+
+	return slots, nil
+}
+
+// Reserve holds a place in the slot for ReservationTTL. The reservation is lost unless an order
+// is created with it by then.
+func (s *DeliverySlotService) Reserve(ctx context.Context, slotID string) (*models.SlotReservation, error) {
+	reservation, err := s.repo.Reserve(ctx, slotID, s.config.ReservationTTL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve delivery slot: %w", err)
+	}
+	log.Printf("[debug] delivery slot %s reserved by %s until %s", slotID, reservation.ID, reservation.ExpiresAt.Format(time.RFC3339))
+
+	return reservation, nil
+}
+
+func (s *DeliverySlotService) GetReservation(ctx context.Context, reservationID string) (*models.SlotReservation, error) {
+	reservation, err := s.repo.GetReservation(ctx, reservationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get slot reservation: %w", err)
+	}
+
+	return reservation, nil
+}
+
+// Release gives the reserved place back before the reservation expires.
+func (s *DeliverySlotService) Release(ctx context.Context, reservationID string) (*models.SlotReservation, error) {
+	reservation, err := s.repo.Release(ctx, reservationID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to release slot reservation: %w", err)
+	}
+	log.Printf("[debug] slot reservation %s released", reservationID)
+
+	return reservation, nil
+}
+
+// SlotReservationExpirer periodically marks the reservations that were not confirmed in time EXPIRED.
+// Their places are free as soon as they expire, the expirer only keeps the statuses accurate.
+type SlotReservationExpirer struct {
+	repo     *repository.DeliverySlotRepository
+	interval time.Duration
+}
+
+func NewSlotReservationExpirer(repo *repository.DeliverySlotRepository, interval time.Duration) *SlotReservationExpirer {
+	if interval <= 0 {
+		log.Fatal("[fatal] Slot reservation expire interval is not set")
+	}
+
+	return &SlotReservationExpirer{
+		repo:     repo,
+		interval: interval,
+	}
+}
+
+// Run expires reservations every interval until ctx is cancelled.
+func (e *SlotReservationExpirer) Run(ctx context.Context) {
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		expired, err := e.repo.ExpireReservations(ctx)
+		if err != nil {
+			log.Printf("[error] Failed to expire slot reservations: %v", err)
+			continue
+		}
+		if expired > 0 {
+			log.Printf("[debug] %d slot reservations expired", expired)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+)
+
+// IdempotencyKeyCleaner periodically removes expired idempotency keys.
+type IdempotencyKeyCleaner struct {
+	repo     *repository.IdempotencyRepository
+	interval time.Duration
+}
+
+func NewIdempotencyKeyCleaner(repo *repository.IdempotencyRepository, interval time.Duration) *IdempotencyKeyCleaner {
+	if interval <= 0 {
+		log.Fatal("[fatal] Idempotency key cleanup interval is not set")
+	}
+
+	return &IdempotencyKeyCleaner{
+		repo:     repo,
+		interval: interval,
+	}
+}
+
+// Run deletes expired keys every interval until ctx is cancelled.
+func (c *IdempotencyKeyCleaner) Run(ctx context.Context) {
+	ticker := time.NewTicker(c.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		deleted, err := c.repo.DeleteExpired(ctx)
+		if err != nil {
+			log.Printf("[error] Failed to delete expired idempotency keys: %v", err)
+			continue
+		}
+		if deleted > 0 {
+			log.Printf("[debug] %d expired idempotency keys deleted", deleted)
+		}
+	}
+}
@@ -2,119 +2,242 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
-	"github.com/IBM/sarama"
 	"github.com/google/uuid"
 	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
 	"github.com/milovidov983/oms-temporal-demo/shared/events"
 	"github.com/milovidov983/oms-temporal-demo/shared/models"
 )
 
+type OrderServiceConfig struct {
+	Topic           string
+	DefaultCurrency string
+	IdempotencyTTL  time.Duration
+}
+
+func (cfg *OrderServiceConfig) Check() {
+	if cfg.Topic == "" {
+		log.Fatal("[fatal] Order service Topic is not set")
+	}
+	if cfg.DefaultCurrency == "" {
+		log.Fatal("[fatal] Order service DefaultCurrency is not set")
+	}
+	if cfg.IdempotencyTTL <= 0 {
+		log.Fatal("[fatal] Order service IdempotencyTTL is not set")
+	}
+}
+
 type OrderService struct {
-	repo  *repository.OrderRepository
-	kafka sarama.SyncProducer
-	topic string
+	repo   *repository.OrderRepository
+	config OrderServiceConfig
 }
 
-func NewOrderService(repo *repository.OrderRepository, kafka sarama.SyncProducer, topic string) *OrderService {
+func NewOrderService(repo *repository.OrderRepository, cfg OrderServiceConfig) *OrderService {
+	cfg.Check()
+
 	return &OrderService{
-		repo:  repo,
-		kafka: kafka,
-		topic: topic,
+		repo:   repo,
+		config: cfg,
 	}
 }
 
-func (s *OrderService) CreateOrder(ctx context.Context, order *models.Order) error {
+// CreateOrder validates and saves a new order. With a non-empty idempotencyKey a repeated request
+// does not create a second order: order.ID is set to the ID of the order created by the first request.
+// A delivery slot reserved at checkout is confirmed for the order, an expired reservation fails the request.
+func (s *OrderService) CreateOrder(ctx context.Context, order *models.Order, idempotencyKey string) error {
 	if err := s.validateOrder(order); err != nil {
 		return fmt.Errorf("order validation failed: %w", err)
 	}
 
+	// Сумму заказа всегда считаем сами, присланное клиентом значение игнорируется
+	total, err := itemsTotal(order.Items)
+	if err != nil {
+		return fmt.Errorf("failed to compute order total: %w", err)
+	}
+	order.TotalAmount = total
+	if order.Currency == "" {
+		order.Currency = s.config.DefaultCurrency
+	}
+
+	// Хеш считаем до того, как сервер заполнит ID и статус
+	requestHash, err := orderRequestHash(order)
+	if err != nil {
+		return fmt.Errorf("failed to hash order request: %w", err)
+	}
+
+	// Время создания и заявку на сборку назначает сервер, присланные клиентом значения игнорируются
 	order.Status = models.OrderStatusCreated
 	order.ID = uuid.New().String()
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.AssemblyApplicationID = ""
+
+	var idempotency *repository.IdempotencyRecord
+	if idempotencyKey != "" {
+		idempotency, err = s.idempotencyRecord(idempotencyKey, requestHash, order.ID)
+		if err != nil {
+			return err
+		}
+	}
 
-	if err := s.repo.SaveOrder(ctx, order); err != nil {
+	event, err := s.orderEvent(events.OrderCreated, order)
+	if err != nil {
+		return fmt.Errorf("failed to build order created event: %w", err)
+	}
+
+	replay, err := s.repo.SaveOrder(ctx, order, idempotency, event)
+	if err != nil {
 		return fmt.Errorf("failed to save order: %w", err)
 	}
+	if replay != nil {
+		log.Printf("[debug] idempotency key %s replayed, order with ID %s already exists", idempotencyKey, replay.OrderID)
+		order.ID = replay.OrderID
+		return nil
+	}
 	log.Printf("[debug] order with ID %s saved to database", order.ID)
 
-	if err := s.publishOrderCreated(order); err != nil {
-		return fmt.Errorf("failed to publish order created event: %w", err)
+	return nil
+}
+
+// orderRequestHash identifies the request body regardless of formatting and key order.
+func orderRequestHash(order *models.Order) (string, error) {
+	request := struct {
+		CustomerID                string             `json:"customer_id"`
+		Currency                  string             `json:"currency"`
+		Region                    string             `json:"region"`
+		DeliverySlotReservationID string             `json:"delivery_slot_reservation_id,omitempty"`
+		Items                     []models.OrderItem `json:"items"`
+	}{
+		CustomerID:                order.CustomerID,
+		Currency:                  order.Currency,
+		Region:                    order.Region,
+		DeliverySlotReservationID: order.DeliverySlotReservationID,
+		Items:                     order.Items,
 	}
 
-	return nil
+	data, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
 
-func (s *OrderService) GetOrderStatus(ctx context.Context, orderID string) (models.OrderStatus, error) {
-	order, err := s.repo.GetOrder(ctx, orderID)
+func (s *OrderService) idempotencyRecord(key, requestHash, orderID string) (*repository.IdempotencyRecord, error) {
+	response, err := json.Marshal(map[string]string{"order_id": orderID})
 	if err != nil {
-		return "", fmt.Errorf("failed to get order: %w", err)
+		return nil, fmt.Errorf("failed to marshal idempotent response: %w", err)
 	}
 
-	return order.Status, nil
+	now := time.Now()
+	return &repository.IdempotencyRecord{
+		Key:         key,
+		RequestHash: requestHash,
+		OrderID:     orderID,
+		Response:    response,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.config.IdempotencyTTL),
+	}, nil
 }
 
-func (s *OrderService) CancelOrder(ctx context.Context, orderID string) error {
+func (s *OrderService) GetOrder(ctx context.Context, orderID string) (*models.Order, error) {
 	order, err := s.repo.GetOrder(ctx, orderID)
 	if err != nil {
-		return fmt.Errorf("failed to get order: %w", err)
+		return nil, fmt.Errorf("failed to get order: %w", err)
 	}
 
-	order.Status = models.OrderStatusCanceled
+	return order, nil
+}
 
-	if err := s.repo.SaveOrder(ctx, order); err != nil {
-		return fmt.Errorf("failed to save order: %w", err)
+func (s *OrderService) ListOrders(ctx context.Context, filter repository.OrderFilter) (*repository.OrderPage, error) {
+	page, err := s.repo.ListOrders(ctx, filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list orders: %w", err)
 	}
 
-	if err := s.publishOrderCancelled(order); err != nil {
-		return fmt.Errorf("failed to publish order canceled event: %w", err)
+	return page, nil
+}
+
+func (s *OrderService) GetOrderHistory(ctx context.Context, orderID string) ([]repository.StatusHistoryEntry, error) {
+	history, err := s.repo.GetOrderHistory(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order history: %w", err)
 	}
 
-	return nil
+	return history, nil
 }
 
-func (s *OrderService) validateOrder(order *models.Order) error {
-	return nil
+func (s *OrderService) GetOrderStatus(ctx context.Context, orderID string) (models.OrderStatus, error) {
+	order, err := s.repo.GetOrder(ctx, orderID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get order: %w", err)
+	}
+
+	return order.Status, nil
 }
 
-func (s *OrderService) publishOrderCancelled(order *models.Order) error {
-	event := &events.OrderEvent{
-		EventType: events.OrderCancelled,
-		EventData: *order,
-	}
-	msg, err := json.Marshal(event)
+// ErrOrderNotCancelable is returned when the order has gone too far in its processing to be canceled.
+var ErrOrderNotCancelable = errors.New("order can no longer be canceled")
+
+// CancelOrder cancels the order. A non-zero expectedVersion must match the current order version.
+// OrderCancelled carries the actor and the reason, the order workflow compensates what was already done.
+func (s *OrderService) CancelOrder(ctx context.Context, orderID string, expectedVersion int) error {
+	order, err := s.repo.GetOrder(ctx, orderID)
 	if err != nil {
-		return fmt.Errorf("failed to marshal order cancelled event: %w", err)
+		return fmt.Errorf("failed to get order: %w", err)
+	}
+
+	if !order.Status.CanTransitionTo(models.OrderStatusCanceled) {
+		return fmt.Errorf("%w: order %s is %s", ErrOrderNotCancelable, order.ID, order.Status)
 	}
 
-	partition, offset, err := s.kafka.SendMessage(&sarama.ProducerMessage{
-		Topic: s.topic,
-		Value: sarama.StringEncoder(msg),
+	// Без If-Match ожидаем прочитанную версию: заказ не должен измениться между чтением и записью
+	version := expectedVersion
+	if version == 0 {
+		version = order.Version
+	}
+
+	order.Status = models.OrderStatusCanceled
+	order.Version = version + 1
+
+	change := repository.StatusChangeFromContext(ctx)
+	event, err := s.orderEvent(events.OrderCancelled, order, func(event *events.OrderEvent) {
+		event.Actor = change.Actor
+		event.Reason = change.Reason
 	})
+	if err != nil {
+		return fmt.Errorf("failed to build order canceled event: %w", err)
+	}
 
-	log.Printf("[debug] event published to kafka topic %s, partition %d, offset %d", s.topic, partition, offset)
+	if err := s.repo.UpdateOrderStatus(ctx, order.ID, order.Status, version, event); err != nil {
+		return fmt.Errorf("failed to save order: %w", preconditionError(err, expectedVersion))
+	}
 
-	return err
+	return nil
 }
 
-func (s *OrderService) publishOrderCreated(order *models.Order) error {
+// orderEvent builds the outbox message for an order event. Order ID is used as the key
+// so all events of one order land in the same partition.
+func (s *OrderService) orderEvent(
+	eventType events.EventType,
+	order *models.Order,
+	options ...func(event *events.OrderEvent),
+) (*repository.OutboxMessage, error) {
 	event := &events.OrderEvent{
-		EventType: events.OrderCreated,
+		EventType: eventType,
 		EventData: *order,
 	}
-	msg, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal order created event: %w", err)
+	for _, option := range options {
+		option(event)
 	}
 
-	partition, offset, err := s.kafka.SendMessage(&sarama.ProducerMessage{
-		Topic: s.topic,
-		Value: sarama.StringEncoder(msg),
-	})
-
-	log.Printf("[debug] event published to kafka topic %s, partition %d, offset %d", s.topic, partition, offset)
-
-	return err
+	return repository.NewOutboxMessage(s.config.Topic, order.ID, event)
 }
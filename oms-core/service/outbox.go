@@ -0,0 +1,169 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/IBM/sarama"
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+)
+
+type OutboxRelayConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// RetryDelay is the pause after the first failed attempt of a message, it doubles with every next attempt
+	RetryDelay time.Duration
+	// MaxRetryDelay caps the pause between the attempts
+	MaxRetryDelay time.Duration
+	// ClaimTimeout is how long a claimed message is left to the relay that claimed it
+	ClaimTimeout time.Duration
+}
+
+func (cfg *OutboxRelayConfig) Check() {
+	if cfg.PollInterval <= 0 {
+		log.Fatal("[fatal] Outbox relay PollInterval is not set")
+	}
+	if cfg.BatchSize <= 0 {
+		log.Fatal("[fatal] Outbox relay BatchSize is not set")
+	}
+	if cfg.RetryDelay <= 0 {
+		log.Fatal("[fatal] Outbox relay RetryDelay is not set")
+	}
+	if cfg.MaxRetryDelay < cfg.RetryDelay {
+		log.Fatal("[fatal] Outbox relay MaxRetryDelay must not be less than RetryDelay")
+	}
+	if cfg.ClaimTimeout <= 0 {
+		log.Fatal("[fatal] Outbox relay ClaimTimeout is not set")
+	}
+}
+
+// OutboxRelay publishes messages written to the outbox table to Kafka in order of their keys.
+// A failed message is retried with a growing pause and holds back the later messages of its key for as
+// long as Kafka is unavailable. Only a message Kafka can never accept is left in the table as a dead letter
+// with its last error, then its key moves on; Requeue puts it back once the cause is fixed.
+type OutboxRelay struct {
+	repo   *repository.OutboxRepository
+	kafka  sarama.SyncProducer
+	config OutboxRelayConfig
+}
+
+func NewOutboxRelay(repo *repository.OutboxRepository, kafka sarama.SyncProducer, cfg OutboxRelayConfig) *OutboxRelay {
+	cfg.Check()
+
+	return &OutboxRelay{
+		repo:   repo,
+		kafka:  kafka,
+		config: cfg,
+	}
+}
+
+// Run polls the outbox until ctx is cancelled.
+func (r *OutboxRelay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.config.PollInterval)
+	defer ticker.Stop()
+
+	log.Printf("[info] Outbox relay started with poll interval %s", r.config.PollInterval)
+
+	for {
+		// Пока сообщения отправляются, выбираем следующие без ожидания: за батч уходит одно сообщение ключа
+		for {
+			sent, err := r.processPending(ctx)
+			if err != nil {
+				log.Printf("[error] Outbox relay failed to process pending messages: %v", err)
+				break
+			}
+			if sent == 0 {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("[info] Outbox relay stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// processPending publishes a batch of claimed messages and returns how many were sent.
+func (r *OutboxRelay) processPending(ctx context.Context) (int, error) {
+	messages, err := r.repo.ClaimPending(ctx, r.config.BatchSize, r.config.ClaimTimeout)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, message := range messages {
+		if publishErr := r.publish(message); publishErr != nil {
+			dead := isUnpublishable(publishErr)
+			if dead {
+				log.Printf("[error] outbox message %d with key %s moved to dead letters: %v", message.ID, message.Key, publishErr)
+			}
+			retryAt := time.Now().Add(r.retryDelay(message.Attempts + 1))
+			if err := r.repo.MarkFailed(ctx, message.ID, publishErr, retryAt, dead); err != nil {
+				return sent, err
+			}
+			continue
+		}
+
+		if err := r.repo.MarkSent(ctx, message.ID); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+
+	return sent, nil
+}
+
+// Requeue returns the dead message to the pending ones, it is published with the next batch.
+func (r *OutboxRelay) Requeue(ctx context.Context, id int64) error {
+	if err := r.repo.Requeue(ctx, id); err != nil {
+		return err
+	}
+
+	log.Printf("[info] Dead outbox message %d requeued", id)
+	return nil
+}
+
+// retryDelay returns the pause after the given number of failed attempts: RetryDelay doubled
+// for every attempt after the first one, but not more than MaxRetryDelay.
+func (r *OutboxRelay) retryDelay(attempts int) time.Duration {
+	delay := r.config.RetryDelay
+	for i := 1; i < attempts && delay < r.config.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, r.config.MaxRetryDelay)
+}
+
+// isUnpublishable tells whether Kafka refuses the message itself, so publishing it again can never succeed.
+// Other errors, e.g. an unavailable broker or leader, are retried without a limit.
+func isUnpublishable(err error) bool {
+	var configErr sarama.ConfigurationError
+	if errors.As(err, &configErr) {
+		// Продюсер отказывается отправлять само сообщение, например больше Producer.MaxMessageBytes
+		return true
+	}
+	return errors.Is(err, sarama.ErrMessageSizeTooLarge) ||
+		errors.Is(err, sarama.ErrInvalidMessage) ||
+		errors.Is(err, sarama.ErrInvalidRecord) ||
+		errors.Is(err, sarama.ErrInvalidTopic)
+}
+
+func (r *OutboxRelay) publish(message *repository.OutboxMessage) error {
+	partition, offset, err := r.kafka.SendMessage(&sarama.ProducerMessage{
+		Topic: message.Topic,
+		Key:   sarama.StringEncoder(message.Key),
+		Value: sarama.ByteEncoder(message.Payload),
+	})
+	if err != nil {
+		log.Printf("[error] failed to publish outbox message %d to kafka topic %s (attempt %d): %v", message.ID, message.Topic, message.Attempts+1, err)
+		return err
+	}
+
+	log.Printf("[debug] event published to kafka topic %s, partition %d, offset %d", message.Topic, partition, offset)
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// ErrCaptureExceedsAuthorization is returned when the order costs more than was authorized, e.g. after
+// items were added to it. The gateway cannot charge more than it holds.
+var ErrCaptureExceedsAuthorization = errors.New("capture exceeds the authorized amount")
+
+// PaymentService runs the payment of an order through the PaymentGateway and stores every step in oms-core.
+// The order workflow authorizes the order total on creation, raises the authorization when the total grows,
+// captures what was collected after the assembly and cancels the payment when the order is canceled.
+type PaymentService struct {
+	repo    *repository.PaymentRepository
+	gateway PaymentGateway
+}
+
+func NewPaymentService(repo *repository.PaymentRepository, gateway PaymentGateway) *PaymentService {
+	return &PaymentService{
+		repo:    repo,
+		gateway: gateway,
+	}
+}
+
+// Authorize holds the total of the order. A declined authorization is not an error: the payment is returned
+// as DECLINED with the reason. A repeated call returns the active payment of the order and finishes
+// an authorization that was interrupted.
+func (s *PaymentService) Authorize(ctx context.Context, orderID string) (*models.Payment, error) {
+	payment, err := s.repo.StartAuthorization(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start payment authorization: %w", err)
+	}
+	if payment.Status != models.PaymentStatusPending {
+		return payment, nil
+	}
+
+	reference, err := s.gateway.Authorize(ctx, payment.ID, payment.Amount, payment.Currency)
+	if errors.Is(err, ErrPaymentDeclined) {
+		reason := truncate(err.Error(), MaxCancelReasonLength)
+		change := repository.StatusChangeFromContext(ctx)
+		change.Reason = reason
+		payment, err = s.repo.ChangeStatus(repository.WithStatusChange(ctx, change), payment.ID, models.PaymentStatusDeclined,
+			repository.PaymentChange{DeclineReason: reason})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decline payment: %w", err)
+		}
+		log.Printf("[debug] payment %s of order %s declined: %s", payment.ID, orderID, reason)
+		return payment, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to authorize payment %s: %w", payment.ID, err)
+	}
+
+	payment, err = s.repo.ChangeStatus(ctx, payment.ID, models.PaymentStatusAuthorized, repository.PaymentChange{GatewayReference: reference})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment authorization: %w", err)
+	}
+	log.Printf("[debug] payment %s of order %s authorized for %s", payment.ID, orderID, payment.Amount)
+
+	return payment, nil
+}
+
+func (s *PaymentService) Get(ctx context.Context, paymentID string) (*models.Payment, error) {
+	payment, err := s.repo.Get(ctx, paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	return payment, nil
+}
+
+func (s *PaymentService) ListByOrder(ctx context.Context, orderID string) ([]models.Payment, error) {
+	payments, err := s.repo.ListByOrder(ctx, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list payments: %w", err)
+	}
+
+	return payments, nil
+}
+
+// Reauthorize raises the authorization of the payment to the current total of the order, e.g. after items
+// were added to it or a dearer substitution was approved. A payment that already covers the total is returned
+// as it is. When the gateway refuses to hold more, the error matches ErrPaymentDeclined and the payment keeps
+// its amount.
+func (s *PaymentService) Reauthorize(ctx context.Context, paymentID string) (*models.Payment, error) {
+	payment, err := s.repo.Get(ctx, paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	total, err := s.repo.OrderTotal(ctx, payment.OrderID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get order total: %w", err)
+	}
+	if total <= payment.Amount {
+		return payment, nil
+	}
+	if payment.Status != models.PaymentStatusAuthorized {
+		return nil, &repository.InvalidTransitionError{Entity: "payment", ID: paymentID, From: string(payment.Status), To: string(models.PaymentStatusAuthorized)}
+	}
+
+	if err := s.gateway.Increase(ctx, payment.GatewayReference, total); err != nil {
+		return nil, fmt.Errorf("failed to increase payment %s to %s: %w", paymentID, total, err)
+	}
+
+	payment, err = s.repo.IncreaseAmount(ctx, paymentID, total)
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment increase: %w", err)
+	}
+	log.Printf("[debug] payment %s of order %s reauthorized for %s", paymentID, payment.OrderID, payment.Amount)
+
+	return payment, nil
+}
+
+// Capture charges the amount of what was collected, the rest of the authorization is released by the gateway.
+// Capturing a captured payment for the same amount again returns it as it is.
+func (s *PaymentService) Capture(ctx context.Context, paymentID string, amount models.Money) (*models.Payment, error) {
+	verr := &ValidationError{}
+	if amount <= 0 {
+		verr.add("amount", "must be positive")
+	}
+	if err := verr.errOrNil(); err != nil {
+		return nil, err
+	}
+
+	payment, err := s.repo.Get(ctx, paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+	if payment.Status == models.PaymentStatusCaptured && payment.CapturedAmount == amount {
+		return payment, nil
+	}
+	if !payment.Status.CanTransitionTo(models.PaymentStatusCaptured) {
+		return nil, &repository.InvalidTransitionError{Entity: "payment", ID: paymentID, From: string(payment.Status), To: string(models.PaymentStatusCaptured)}
+	}
+	if amount > payment.Amount {
+		return nil, fmt.Errorf("%w: payment %s is authorized for %s, capture of %s requested", ErrCaptureExceedsAuthorization, paymentID, payment.Amount, amount)
+	}
+
+	if err := s.gateway.Capture(ctx, payment.GatewayReference, amount); err != nil {
+		return nil, fmt.Errorf("failed to capture payment %s: %w", paymentID, err)
+	}
+
+	payment, err = s.repo.ChangeStatus(ctx, paymentID, models.PaymentStatusCaptured, repository.PaymentChange{Captured: amount})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment capture: %w", err)
+	}
+	log.Printf("[debug] payment %s captured for %s", paymentID, amount)
+
+	return payment, nil
+}
+
+// Cancel gives the money back to the customer: an authorization is voided, a captured amount is refunded.
+// A payment that was declined, voided or refunded is returned as it is. A payment still being authorized
+// cannot be canceled until the authorization is finished.
+func (s *PaymentService) Cancel(ctx context.Context, paymentID string, reason string) (*models.Payment, error) {
+	ctx, _, err := withReason(ctx, reason)
+	if err != nil {
+		return nil, err
+	}
+
+	payment, err := s.repo.Get(ctx, paymentID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get payment: %w", err)
+	}
+
+	switch payment.Status {
+	case models.PaymentStatusAuthorized:
+		if err := s.gateway.Void(ctx, payment.GatewayReference); err != nil {
+			return nil, fmt.Errorf("failed to void payment %s: %w", paymentID, err)
+		}
+		payment, err = s.repo.ChangeStatus(ctx, paymentID, models.PaymentStatusVoided, repository.PaymentChange{})
+	case models.PaymentStatusCaptured:
+		refund := payment.CapturedAmount - payment.RefundedAmount
+		if err := s.gateway.Refund(ctx, payment.GatewayReference, refund); err != nil {
+			return nil, fmt.Errorf("failed to refund payment %s: %w", paymentID, err)
+		}
+		payment, err = s.repo.ChangeStatus(ctx, paymentID, models.PaymentStatusRefunded, repository.PaymentChange{Refunded: refund})
+	case models.PaymentStatusPending:
+		return nil, &repository.InvalidTransitionError{Entity: "payment", ID: paymentID, From: string(payment.Status), To: string(models.PaymentStatusVoided)}
+	default:
+		return payment, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to save payment cancellation: %w", err)
+	}
+	log.Printf("[debug] payment %s canceled as %s", paymentID, payment.Status)
+
+	return payment, nil
+}
+
+// truncate cuts s to at most n runes.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n])
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+// ErrPaymentDeclined is returned by a PaymentGateway when the bank or the provider refused the authorization.
+var ErrPaymentDeclined = errors.New("payment declined")
+
+// PaymentGateway is the payment provider. Authorize gets the payment ID as the idempotency key, so a call
+// repeated after a timeout returns the same authorization; the other calls are made with the reference
+// it returned and must be safe to repeat as well.
+type PaymentGateway interface {
+	// Authorize holds the amount on the card of the customer and returns the reference of the authorization.
+	// A refusal is returned as ErrPaymentDeclined.
+	Authorize(ctx context.Context, paymentID string, amount models.Money, currency string) (string, error)
+	// Increase raises the hold of an authorization that was not captured to amount. A refusal is returned
+	// as ErrPaymentDeclined and leaves the hold as it was.
+	Increase(ctx context.Context, reference string, amount models.Money) error
+	// Capture charges up to the authorized amount, the rest of the hold is released.
+	Capture(ctx context.Context, reference string, amount models.Money) error
+	// Void releases an authorization that was not captured.
+	Void(ctx context.Context, reference string) error
+	// Refund returns a captured amount to the customer.
+	Refund(ctx context.Context, reference string, amount models.Money) error
+}
+
+type FakePaymentGatewayConfig struct {
+	// DeclineAbove makes the gateway decline larger amounts, zero declines nothing
+	DeclineAbove models.Money
+}
+
+// FakePaymentGateway keeps authorizations in memory. It is meant for local runs and demos,
+// where no payment provider is available.
+type FakePaymentGateway struct {
+	config FakePaymentGatewayConfig
+
+	mu             sync.Mutex
+	authorizations map[string]*fakeAuthorization
+}
+
+type fakeAuthorization struct {
+	amount   models.Money
+	captured models.Money
+	refunded models.Money
+	voided   bool
+}
+
+func NewFakePaymentGateway(cfg FakePaymentGatewayConfig) *FakePaymentGateway {
+	return &FakePaymentGateway{
+		config:         cfg,
+		authorizations: make(map[string]*fakeAuthorization),
+	}
+}
+
+func (g *FakePaymentGateway) Authorize(ctx context.Context, paymentID string, amount models.Money, currency string) (string, error) {
+	if g.config.DeclineAbove > 0 && amount > g.config.DeclineAbove {
+		return "", fmt.Errorf("%w: amount %s %s exceeds the limit of the card", ErrPaymentDeclined, amount, currency)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	reference := "fake-" + paymentID
+	if _, ok := g.authorizations[reference]; !ok {
+		g.authorizations[reference] = &fakeAuthorization{amount: amount}
+	}
+	log.Printf("[debug] fake payment gateway authorized %s %s as %s", amount, currency, reference)
+
+	return reference, nil
+}
+
+func (g *FakePaymentGateway) Increase(ctx context.Context, reference string, amount models.Money) error {
+	if g.config.DeclineAbove > 0 && amount > g.config.DeclineAbove {
+		return fmt.Errorf("%w: amount %s exceeds the limit of the card", ErrPaymentDeclined, amount)
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	authorization, err := g.authorization(reference)
+	if err != nil {
+		return err
+	}
+	if authorization.voided || authorization.captured > 0 {
+		return fmt.Errorf("authorization %s is closed", reference)
+	}
+	if amount > authorization.amount {
+		authorization.amount = amount
+	}
+	log.Printf("[debug] fake payment gateway increased %s to %s", reference, amount)
+
+	return nil
+}
+
+func (g *FakePaymentGateway) Capture(ctx context.Context, reference string, amount models.Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	authorization, err := g.authorization(reference)
+	if err != nil {
+		return err
+	}
+	if authorization.voided {
+		return fmt.Errorf("authorization %s is voided", reference)
+	}
+	if authorization.captured > 0 && authorization.captured != amount {
+		return fmt.Errorf("authorization %s is already captured for %s", reference, authorization.captured)
+	}
+	if amount > authorization.amount {
+		return fmt.Errorf("capture of %s exceeds the authorized %s", amount, authorization.amount)
+	}
+	authorization.captured = amount
+	log.Printf("[debug] fake payment gateway captured %s of %s", amount, reference)
+
+	return nil
+}
+
+func (g *FakePaymentGateway) Void(ctx context.Context, reference string) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	authorization, err := g.authorization(reference)
+	if err != nil {
+		return err
+	}
+	if authorization.captured > 0 {
+		return fmt.Errorf("authorization %s is captured, it can only be refunded", reference)
+	}
+	authorization.voided = true
+	log.Printf("[debug] fake payment gateway voided %s", reference)
+
+	return nil
+}
+
+func (g *FakePaymentGateway) Refund(ctx context.Context, reference string, amount models.Money) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	authorization, err := g.authorization(reference)
+	if err != nil {
+		return err
+	}
+	// Фейк поддерживает один возврат: повтор после таймаута не возвращает деньги дважды
+	if amount > authorization.captured {
+		return fmt.Errorf("refund of %s exceeds the captured %s", amount, authorization.captured)
+	}
+	authorization.refunded = amount
+	log.Printf("[debug] fake payment gateway refunded %s of %s", amount, reference)
+
+	return nil
+}
+
+// authorization returns the authorization of the reference, the caller holds g.mu. An oms-core restart
+// loses the authorizations of the fake, so unknown references are accepted as if they were authorized.
+func (g *FakePaymentGateway) authorization(reference string) (*fakeAuthorization, error) {
+	if reference == "" {
+		return nil, fmt.Errorf("payment reference is required")
+	}
+	authorization, ok := g.authorizations[reference]
+	if !ok {
+		authorization = &fakeAuthorization{amount: models.MaxMoney}
+		g.authorizations[reference] = authorization
+	}
+	return authorization, nil
+}
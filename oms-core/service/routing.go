@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+)
+
+// ErrNoWarehouseAvailable is returned when there is no active warehouse to assemble an order.
+var ErrNoWarehouseAvailable = errors.New("no warehouse available")
+
+// RoutingRule is a step of the assembly routing. Filtering rules are applied in the configured order.
+type RoutingRule string
+
+const (
+	// RoutingRuleExplicit allows the caller to choose the warehouse. The choice wins over the other rules.
+	RoutingRuleExplicit RoutingRule = "explicit"
+	// RoutingRuleStock keeps the warehouses that have every line of the order in stock.
+	RoutingRuleStock RoutingRule = "stock"
+	// RoutingRuleRegion keeps the warehouses in the region of the order.
+	RoutingRuleRegion RoutingRule = "region"
+)
+
+type AssemblyRoutingConfig struct {
+	Rules []RoutingRule
+}
+
+func (cfg *AssemblyRoutingConfig) Check() {
+	if len(cfg.Rules) == 0 {
+		log.Fatal("[fatal] Assembly routing Rules are not set")
+	}
+	for _, rule := range cfg.Rules {
+		switch rule {
+		case RoutingRuleExplicit, RoutingRuleStock, RoutingRuleRegion:
+		default:
+			log.Fatalf("[fatal] Unknown assembly routing rule %q", rule)
+		}
+	}
+}
+
+// AssemblyRouter picks the warehouse where an order is assembled.
+type AssemblyRouter struct {
+	repo   *repository.WarehouseRepository
+	config AssemblyRoutingConfig
+}
+
+func NewAssemblyRouter(repo *repository.WarehouseRepository, cfg AssemblyRoutingConfig) *AssemblyRouter {
+	cfg.Check()
+
+	return &AssemblyRouter{
+		repo:   repo,
+		config: cfg,
+	}
+}
+
+// Route returns the warehouse ID for the order. requestedWarehouseID is the explicit choice of the caller
+// and may be empty. Otherwise filtering rules narrow down the active warehouses, a rule that would leave
+// none is skipped. The warehouse with the lowest priority among the rest wins.
+func (r *AssemblyRouter) Route(ctx context.Context, orderID, requestedWarehouseID string) (string, error) {
+	candidates, err := r.repo.RoutingCandidates(ctx, orderID)
+	if err != nil {
+		return "", fmt.Errorf("failed to get warehouse candidates: %w", err)
+	}
+
+	if requestedWarehouseID != "" {
+		return r.explicitWarehouse(candidates, requestedWarehouseID)
+	}
+
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("%w: order %s", ErrNoWarehouseAvailable, orderID)
+	}
+
+	for _, rule := range r.config.Rules {
+		switch rule {
+		case RoutingRuleStock:
+			candidates = filterCandidates(candidates, func(c repository.WarehouseCandidate) bool { return c.InStock })
+		case RoutingRuleRegion:
+			candidates = filterCandidates(candidates, func(c repository.WarehouseCandidate) bool { return c.MatchesRegion })
+		}
+	}
+
+	// Кандидаты отсортированы по приоритету
+	return candidates[0].ID, nil
+}
+
+func (r *AssemblyRouter) explicitWarehouse(candidates []repository.WarehouseCandidate, warehouseID string) (string, error) {
+	verr := &ValidationError{}
+
+	allowed := false
+	for _, rule := range r.config.Rules {
+		allowed = allowed || rule == RoutingRuleExplicit
+	}
+	if !allowed {
+		verr.add("warehouse_id", "explicit warehouse choice is disabled")
+		return "", verr
+	}
+
+	for _, candidate := range candidates {
+		if candidate.ID == warehouseID {
+			return candidate.ID, nil
+		}
+	}
+
+	verr.add("warehouse_id", "must be an active warehouse")
+	return "", verr
+}
+
+// filterCandidates keeps the candidates matching keep. If none match, the rule does not apply
+// and all candidates are returned.
+func filterCandidates(
+	candidates []repository.WarehouseCandidate,
+	keep func(repository.WarehouseCandidate) bool,
+) []repository.WarehouseCandidate {
+	var kept []repository.WarehouseCandidate
+	for _, candidate := range candidates {
+		if keep(candidate) {
+			kept = append(kept, candidate)
+		}
+	}
+	if len(kept) == 0 {
+		return candidates
+	}
+	return kept
+}
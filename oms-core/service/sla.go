@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+type AssemblySLAConfig struct {
+	// Default is used for warehouses without an SLA for the order type
+	Default time.Duration
+}
+
+func (cfg *AssemblySLAConfig) Check() {
+	if cfg.Default <= 0 {
+		log.Fatal("[fatal] Assembly SLA Default is not set")
+	}
+}
+
+// AssemblySLA tells how long a warehouse has to assemble an order of a given type.
+type AssemblySLA struct {
+	repo   *repository.WarehouseRepository
+	config AssemblySLAConfig
+}
+
+func NewAssemblySLA(repo *repository.WarehouseRepository, cfg AssemblySLAConfig) *AssemblySLA {
+	cfg.Check()
+
+	return &AssemblySLA{
+		repo:   repo,
+		config: cfg,
+	}
+}
+
+// Duration returns the SLA of the warehouse for the order type or the default one.
+func (s *AssemblySLA) Duration(ctx context.Context, warehouseID string, orderType models.OrderType) (time.Duration, error) {
+	sla, ok, err := s.repo.AssemblySLA(ctx, warehouseID, orderType)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get assembly SLA: %w", err)
+	}
+	if !ok {
+		return s.config.Default, nil
+	}
+	return sla, nil
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
+
+var ErrValidation = errors.New("validation failed")
+
+// maxRegionLength is the size of the orders.region column.
+const maxRegionLength = 64
+
+// FieldError points to an invalid field of a request, e.g. items[2].quantity.
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+func (e FieldError) String() string {
+	return e.Field + " " + e.Message
+}
+
+// ValidationError collects all field errors of a request. It matches ErrValidation with errors.Is.
+type ValidationError struct {
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		messages[i] = field.String()
+	}
+	return fmt.Sprintf("%s: %s", ErrValidation, strings.Join(messages, "; "))
+}
+
+func (e *ValidationError) Unwrap() error {
+	return ErrValidation
+}
+
+func (e *ValidationError) add(field, format string, args ...interface{}) {
+	e.Fields = append(e.Fields, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// errOrNil returns nil when no field errors were collected, so the result can be returned as error directly.
+func (e *ValidationError) errOrNil() error {
+	if len(e.Fields) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (s *OrderService) validateOrder(order *models.Order) error {
+	verr := &ValidationError{}
+
+	if strings.TrimSpace(order.CustomerID) == "" {
+		verr.add("customer_id", "is required")
+	}
+
+	if order.Currency != "" && !isCurrencyCode(order.Currency) {
+		verr.add("currency", "must be an ISO 4217 code such as RUB")
+	}
+
+	if len(order.Region) > maxRegionLength {
+		verr.add("region", "must be at most %d characters", maxRegionLength)
+	}
+
+	if len(order.Items) == 0 {
+		verr.add("items", "must not be empty")
+	}
+
+	seen := make(map[string]int, len(order.Items))
+	for i, item := range order.Items {
+		field := fmt.Sprintf("items[%d]", i)
+
+		if strings.TrimSpace(item.ProductID) == "" {
+			verr.add(field+".product_id", "is required")
+		} else if first, ok := seen[item.ProductID]; ok {
+			verr.add(field+".product_id", "duplicates items[%d]", first)
+		} else {
+			seen[item.ProductID] = i
+		}
+
+		if item.Quantity <= 0 {
+			verr.add(field+".quantity", "must be > 0")
+		}
+		if item.Price < 0 {
+			verr.add(field+".price", "must be >= 0")
+		}
+	}
+
+	if len(verr.Fields) == 0 {
+		if _, err := itemsTotal(order.Items); err != nil {
+			verr.add("total_amount", "must not exceed %s", models.MaxMoney)
+		}
+	}
+
+	return verr.errOrNil()
+}
+
+// validateCollected checks the lines reported by the picker. Whether the products belong
+// to the application is checked by the repository against the requested lines.
+func validateCollected(collected []models.AssemblyItem) error {
+	verr := &ValidationError{}
+
+	seen := make(map[string]int, len(collected))
+	for i, item := range collected {
+		field := fmt.Sprintf("collected[%d]", i)
+
+		if strings.TrimSpace(item.ProductID) == "" {
+			verr.add(field+".product_id", "is required")
+		} else if first, ok := seen[item.ProductID]; ok {
+			verr.add(field+".product_id", "duplicates collected[%d]", first)
+		} else {
+			seen[item.ProductID] = i
+		}
+
+		if item.Collected < 0 {
+			verr.add(field+".quantity", "must be >= 0")
+		}
+	}
+
+	return verr.errOrNil()
+}
+
+var errTotalOverflow = errors.New("order total exceeds the maximum amount")
+
+// itemsTotal sums price * quantity of the lines in minor units.
+// The total is always computed by the server, the value sent by the client is ignored.
+func itemsTotal(items []models.OrderItem) (models.Money, error) {
+	var total models.Money
+	for _, item := range items {
+		if item.Quantity > 0 && item.Price > models.MaxMoney/models.Money(item.Quantity) {
+			return 0, errTotalOverflow
+		}
+		total += item.Price.Mul(item.Quantity)
+		if total > models.MaxMoney {
+			return 0, errTotalOverflow
+		}
+	}
+	return total, nil
+}
+
+func isCurrencyCode(code string) bool {
+	if len(code) != 3 {
+		return false
+	}
+	for _, c := range code {
+		if c < 'A' || c > 'Z' {
+			return false
+		}
+	}
+	return true
+}
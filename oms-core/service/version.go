@@ -0,0 +1,20 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/milovidov983/oms-temporal-demo/oms-core/repository"
+)
+
+// ErrPreconditionFailed is returned when the version sent by the client (If-Match) is no longer current.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// preconditionError marks a version conflict as a failed precondition when the expected version
+// came from the client. Conflicts between our own read and write stay ErrVersionConflict.
+func preconditionError(err error, clientVersion int) error {
+	if clientVersion != 0 && errors.Is(err, repository.ErrVersionConflict) {
+		return fmt.Errorf("%w: %w", ErrPreconditionFailed, err)
+	}
+	return err
+}
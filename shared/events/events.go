@@ -1,23 +1,44 @@
 package events
 
-import "github.com/milovidov983/oms-temporal-demo/shared/models"
+import (
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
 
 type EventType string
 
 const (
 	OrderCreated   EventType = "OrderCreated"
 	OrderCancelled EventType = "OrderCancelled"
+	// OrderAmended carries the order with its items and total after a line was added, changed or removed.
+	OrderAmended EventType = "OrderAmended"
 )
 
 type OrderEvent struct {
 	EventType EventType    `json:"eventType"`
 	EventData models.Order `json:"eventData"`
+	// Actor and Reason are who canceled the order and why, set for OrderCancelled
+	Actor  string `json:"actor,omitempty"`
+	Reason string `json:"reason,omitempty"`
 }
 
 const (
 	AssemblyCreated   EventType = "AssemblyCreated"
 	AssemblyCompleted EventType = "AssemblyCompleted"
 	AssemblyCancelled EventType = "AssemblyCancelled"
+	// AssemblyCommentChanged carries the new comment of the application and who left it.
+	AssemblyCommentChanged EventType = "AssemblyCommentChanged"
+	// SubstitutionProposed, SubstitutionApproved and SubstitutionRejected carry the substitution in Substitution.
+	// After SubstitutionApproved Items already hold the substitute.
+	SubstitutionProposed EventType = "SubstitutionProposed"
+	SubstitutionApproved EventType = "SubstitutionApproved"
+	SubstitutionRejected EventType = "SubstitutionRejected"
+	// AssemblyOverdue is published once when the application was not assembled by DueAt.
+	AssemblyOverdue EventType = "AssemblyOverdue"
+	// AssemblyRejected is published when a complete application failed the quality check. It carries
+	// the Reason and the next attempt, which is also announced by its own AssemblyCreated.
+	AssemblyRejected EventType = "AssemblyRejected"
 )
 
 type AssemblyApplicationEvent struct {
@@ -25,8 +46,57 @@ type AssemblyApplicationEvent struct {
 	EventData AssemblyEventData `json:"eventData"`
 }
 
+// AssemblyEventData describes an assembly application with its lines and the warehouse assembling it.
+// For AssemblyCompleted Collected holds the lines as they were actually picked and Shortages the missing
+// quantities, both with order prices, and Packages the parcels the order was packed into.
 type AssemblyEventData struct {
-	ID        string `json:"id"`
-	OrderID   string `json:"orderId"`
-	Collected []models.OrderItem
+	ID          string                `json:"id"`
+	OrderID     string                `json:"orderId"`
+	WarehouseID string                `json:"warehouseId"`
+	Status      models.AssemblyStatus `json:"status"`
+	Items       []models.AssemblyItem `json:"items"`
+	Collected   []models.OrderItem    `json:"collected,omitempty"`
+	Shortages   []models.OrderItem    `json:"shortages,omitempty"`
+	Packages    []models.Package      `json:"packages,omitempty"`
+	Comment     string                `json:"comment,omitempty"`
+	DueAt       *time.Time            `json:"dueAt,omitempty"`
+	Actor       string                `json:"actor,omitempty"`
+	// Reason is why the application was canceled or rejected, set for AssemblyCancelled and AssemblyRejected
+	Reason string `json:"reason,omitempty"`
+	// NextApplicationID and NextDueAt describe the attempt that assembles the order again, set for AssemblyRejected
+	NextApplicationID string     `json:"nextApplicationId,omitempty"`
+	NextDueAt         *time.Time `json:"nextDueAt,omitempty"`
+	// Substitution is the substitution the event is about
+	Substitution *models.Substitution `json:"substitution,omitempty"`
+}
+
+const (
+	DeliveryCreated  EventType = "DeliveryCreated"
+	DeliveryAssigned EventType = "DeliveryAssigned"
+	// DeliveryReleased is published when the courier gave the application back before picking the order up.
+	DeliveryReleased  EventType = "DeliveryReleased"
+	DeliveryPickedUp  EventType = "DeliveryPickedUp"
+	DeliveryDelivered EventType = "DeliveryDelivered"
+	DeliveryFailed    EventType = "DeliveryFailed"
+	DeliveryCancelled EventType = "DeliveryCancelled"
+)
+
+type DeliveryApplicationEvent struct {
+	EventType EventType         `json:"eventType"`
+	EventData DeliveryEventData `json:"eventData"`
+}
+
+// DeliveryEventData describes a delivery application with its packages and the courier delivering it.
+// For DeliveryDelivered ProofOfDelivery is set.
+type DeliveryEventData struct {
+	ID                    string                  `json:"id"`
+	OrderID               string                  `json:"orderId"`
+	AssemblyApplicationID string                  `json:"assemblyApplicationId"`
+	Status                models.DeliveryStatus   `json:"status"`
+	CourierID             string                  `json:"courierId,omitempty"`
+	Packages              []models.Package        `json:"packages,omitempty"`
+	ProofOfDelivery       *models.ProofOfDelivery `json:"proofOfDelivery,omitempty"`
+	Actor                 string                  `json:"actor,omitempty"`
+	// Reason is why the delivery failed or was canceled, set for DeliveryFailed and DeliveryCancelled
+	Reason string `json:"reason,omitempty"`
 }
@@ -10,18 +10,90 @@ const (
 	AssemblyStatusSent     AssemblyStatus = "SENT"
 	AssemblyStatusComplete AssemblyStatus = "COMPLETE"
 	AssemblyStatusCanceled AssemblyStatus = "CANCELED"
+	// AssemblyStatusRejected is a complete application that failed the quality check,
+	// the order is assembled again by the next attempt
+	AssemblyStatusRejected AssemblyStatus = "REJECTED"
 )
 
 type AssemblyApplication struct {
-	ID        string         `json:"id"`
-	OrderID   string         `json:"order_id"`
-	Items     []AssemblyItem `json:"items"`
-	Status    AssemblyStatus `json:"status"`
-	Comment   string         `json:"comment"`
-	CreatedAt time.Time      `json:"created_at"`
+	ID          string         `json:"id"`
+	OrderID     string         `json:"order_id"`
+	WarehouseID string         `json:"warehouse_id"`
+	Items       []AssemblyItem `json:"items"`
+	Status      AssemblyStatus `json:"status"`
+	Comment     string         `json:"comment"`
+	CreatedAt   time.Time      `json:"created_at"`
+	CompletedAt *time.Time     `json:"completed_at,omitempty"`
+	Version     int            `json:"version"`
+	// PickerID is the picker who claimed the application from the queue, StartedAt is when it was claimed
+	PickerID  string     `json:"picker_id,omitempty"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+	// Substitutions are the substitutes proposed by the picker, approved ones are already among Items
+	Substitutions []Substitution `json:"substitutions,omitempty"`
+	// OrderType selects the SLA of the warehouse, DueAt is when the assembly has to be done
+	// and OverdueAt is when it was escalated as overdue
+	OrderType OrderType  `json:"order_type,omitempty"`
+	DueAt     *time.Time `json:"due_at,omitempty"`
+	OverdueAt *time.Time `json:"overdue_at,omitempty"`
+	// Attempt numbers the applications of the order starting from 1, PreviousApplicationID is the attempt
+	// before this one. RejectedAt and RejectionReason are set when the quality check rejected the assembly,
+	// AcceptedAt when the order processing moved past the assembly and it can no longer be rejected.
+	Attempt               int        `json:"attempt"`
+	PreviousApplicationID string     `json:"previous_application_id,omitempty"`
+	RejectedAt            *time.Time `json:"rejected_at,omitempty"`
+	RejectionReason       string     `json:"rejection_reason,omitempty"`
+	AcceptedAt            *time.Time `json:"accepted_at,omitempty"`
+	// Packages are the parcels the order was packed into when the assembly was completed
+	Packages []Package `json:"packages,omitempty"`
 }
 
+// Package is a parcel produced by the assembly, numbered from 1. Weight is in grams, dimensions in millimeters.
+// Barcode is printed on the shipping label of the package.
+type Package struct {
+	Number      int    `json:"number"`
+	Barcode     string `json:"barcode"`
+	WeightGrams int    `json:"weight_grams"`
+	LengthMM    int    `json:"length_mm"`
+	WidthMM     int    `json:"width_mm"`
+	HeightMM    int    `json:"height_mm"`
+}
+
+// AssemblyItem is a line of an assembly application. Quantity is what the order asks for,
+// Collected is what the picker actually collected and is set when the assembly is complete.
+// Price is the price of the order line, so shortages can be valued.
 type AssemblyItem struct {
 	ProductID string `json:"product_id"`
 	Quantity  int    `json:"quantity"`
+	Collected int    `json:"collected"`
+	Price     Money  `json:"price"`
+}
+
+// Shortage returns how many units of the line were not collected.
+func (i AssemblyItem) Shortage() int {
+	if i.Collected >= i.Quantity {
+		return 0
+	}
+	return i.Quantity - i.Collected
+}
+
+type SubstitutionStatus string
+
+const (
+	SubstitutionStatusProposed SubstitutionStatus = "PROPOSED"
+	SubstitutionStatusApproved SubstitutionStatus = "APPROVED"
+	SubstitutionStatusRejected SubstitutionStatus = "REJECTED"
+)
+
+// Substitution is a substitute proposed by the picker for a product that could not be found.
+// Quantity units of ProductID are replaced by the same quantity of SubstituteProductID at Price
+// once the customer approves.
+type Substitution struct {
+	ID                  string             `json:"id"`
+	ProductID           string             `json:"product_id"`
+	SubstituteProductID string             `json:"substitute_product_id"`
+	Quantity            int                `json:"quantity"`
+	Price               Money              `json:"price"`
+	Status              SubstitutionStatus `json:"status"`
+	ProposedAt          time.Time          `json:"proposed_at"`
+	DecidedAt           *time.Time         `json:"decided_at,omitempty"`
 }
@@ -0,0 +1,45 @@
+package models
+
+import "time"
+
+type DeliveryStatus string
+
+const (
+	DeliveryStatusCreated DeliveryStatus = "CREATED"
+	// DeliveryStatusAssigned is an application taken by a courier who has not picked the order up yet
+	DeliveryStatusAssigned  DeliveryStatus = "ASSIGNED"
+	DeliveryStatusPickedUp  DeliveryStatus = "PICKED_UP"
+	DeliveryStatusDelivered DeliveryStatus = "DELIVERED"
+	// DeliveryStatusFailed is a delivery the courier could not make, the packages go back to the warehouse
+	DeliveryStatusFailed   DeliveryStatus = "FAILED"
+	DeliveryStatusCanceled DeliveryStatus = "CANCELED"
+)
+
+// DeliveryApplication is the delivery of the packages of a complete assembly application to the customer.
+type DeliveryApplication struct {
+	ID                    string         `json:"id"`
+	OrderID               string         `json:"order_id"`
+	AssemblyApplicationID string         `json:"assembly_application_id"`
+	Status                DeliveryStatus `json:"status"`
+	CreatedAt             time.Time      `json:"created_at"`
+	Version               int            `json:"version"`
+	// CourierID is the courier the application is assigned to, AssignedAt is when it was assigned
+	CourierID   string     `json:"courier_id,omitempty"`
+	AssignedAt  *time.Time `json:"assigned_at,omitempty"`
+	PickedUpAt  *time.Time `json:"picked_up_at,omitempty"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty"`
+	// ClosedAt is when the application became DELIVERED, FAILED or CANCELED
+	ClosedAt      *time.Time `json:"closed_at,omitempty"`
+	FailureReason string     `json:"failure_reason,omitempty"`
+	// ProofOfDelivery is attached by the courier when the order is delivered
+	ProofOfDelivery *ProofOfDelivery `json:"proof_of_delivery,omitempty"`
+	// Packages are the parcels of the assembly application handed over to the courier
+	Packages []Package `json:"packages,omitempty"`
+}
+
+// ProofOfDelivery holds references to the files the courier app uploaded to its storage:
+// the photo of the handed over order and the signature of the recipient, which may be missing.
+type ProofOfDelivery struct {
+	PhotoRef     string `json:"photo_ref"`
+	SignatureRef string `json:"signature_ref,omitempty"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import "time"
+
+// DeliverySlot is a delivery window of a zone. Capacity is how many orders can be delivered in it,
+// Booked is how many places are held by live reservations and confirmed orders.
+type DeliverySlot struct {
+	ID       string    `json:"id"`
+	Zone     string    `json:"zone"`
+	StartsAt time.Time `json:"starts_at"`
+	EndsAt   time.Time `json:"ends_at"`
+	Capacity int       `json:"capacity"`
+	Booked   int       `json:"booked"`
+}
+
+// Available is how many places of the slot are left.
+func (s *DeliverySlot) Available() int {
+	if s.Booked >= s.Capacity {
+		return 0
+	}
+	return s.Capacity - s.Booked
+}
+
+type SlotReservationStatus string
+
+const (
+	// SlotReservationStatusReserved holds a place in the slot until the reservation expires
+	SlotReservationStatusReserved SlotReservationStatus = "RESERVED"
+	// SlotReservationStatusConfirmed is the place of the order created with the reservation
+	SlotReservationStatusConfirmed SlotReservationStatus = "CONFIRMED"
+	SlotReservationStatusExpired   SlotReservationStatus = "EXPIRED"
+	// SlotReservationStatusReleased is a place given back by the cart or by the canceled order
+	SlotReservationStatusReleased SlotReservationStatus = "RELEASED"
+)
+
+// SlotReservation is a place in a delivery slot taken at checkout. The order confirms it when created.
+type SlotReservation struct {
+	ID          string                `json:"id"`
+	SlotID      string                `json:"slot_id"`
+	Status      SlotReservationStatus `json:"status"`
+	OrderID     string                `json:"order_id,omitempty"`
+	CreatedAt   time.Time             `json:"created_at"`
+	ExpiresAt   time.Time             `json:"expires_at"`
+	ConfirmedAt *time.Time            `json:"confirmed_at,omitempty"`
+	// Slot is the reserved delivery window
+	Slot *DeliverySlot `json:"slot,omitempty"`
+}
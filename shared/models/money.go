@@ -0,0 +1,131 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Money is an amount in minor currency units (kopecks, cents) with two decimal places,
+// the same precision as the NUMERIC(12, 2) columns it is stored in.
+// It never goes through float64, so sums and products are exact.
+type Money int64
+
+const (
+	moneyScale    = 100
+	moneyDecimals = 2
+
+	// MaxMoney is the largest amount that fits into NUMERIC(12, 2).
+	MaxMoney Money = 999_999_999_999
+)
+
+// ParseMoney parses a decimal string such as "150", "150.5" or "-0.99".
+// More than two fractional digits are rejected instead of being rounded.
+func ParseMoney(s string) (Money, error) {
+	value := strings.TrimSpace(s)
+	negative := strings.HasPrefix(value, "-")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "-"), "+")
+
+	units, fraction, hasFraction := strings.Cut(value, ".")
+	if units == "" || (hasFraction && fraction == "") {
+		return 0, fmt.Errorf("invalid money amount %q", s)
+	}
+	if len(fraction) > moneyDecimals {
+		return 0, fmt.Errorf("invalid money amount %q: more than %d decimal places", s, moneyDecimals)
+	}
+	fraction += strings.Repeat("0", moneyDecimals-len(fraction))
+
+	for _, part := range []string{units, fraction} {
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return 0, fmt.Errorf("invalid money amount %q", s)
+			}
+		}
+	}
+
+	major, err := strconv.ParseInt(units, 10, 64)
+	if err != nil || major > int64(MaxMoney/moneyScale) {
+		return 0, fmt.Errorf("invalid money amount %q: out of range", s)
+	}
+	minor, _ := strconv.ParseInt(fraction, 10, 64)
+
+	amount := Money(major*moneyScale + minor)
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// Mul returns the amount multiplied by quantity.
+func (m Money) Mul(quantity int) Money {
+	return m * Money(quantity)
+}
+
+// String formats the amount with exactly two decimal places.
+func (m Money) String() string {
+	sign := ""
+	value := int64(m)
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+	return fmt.Sprintf("%s%d.%02d", sign, value/moneyScale, value%moneyScale)
+}
+
+// MarshalJSON writes the amount as a JSON number with two decimal places.
+func (m Money) MarshalJSON() ([]byte, error) {
+	return []byte(m.String()), nil
+}
+
+// UnmarshalJSON accepts both JSON numbers and strings and parses their literal text,
+// so a value like 0.1 is read exactly.
+func (m *Money) UnmarshalJSON(data []byte) error {
+	text := string(data)
+	if text == "null" {
+		return nil
+	}
+
+	var quoted string
+	if err := json.Unmarshal(data, &quoted); err == nil {
+		text = quoted
+	}
+
+	amount, err := ParseMoney(text)
+	if err != nil {
+		return err
+	}
+	*m = amount
+	return nil
+}
+
+// Value stores the amount as a decimal string, which PostgreSQL converts to NUMERIC without rounding.
+func (m Money) Value() (driver.Value, error) {
+	return m.String(), nil
+}
+
+// Scan reads a NUMERIC column, which the driver returns as text.
+func (m *Money) Scan(src interface{}) error {
+	switch value := src.(type) {
+	case []byte:
+		amount, err := ParseMoney(string(value))
+		if err != nil {
+			return err
+		}
+		*m = amount
+	case string:
+		amount, err := ParseMoney(value)
+		if err != nil {
+			return err
+		}
+		*m = amount
+	case int64:
+		*m = Money(value * moneyScale)
+	case nil:
+		*m = 0
+	default:
+		return fmt.Errorf("cannot scan %T into Money", src)
+	}
+	return nil
+}
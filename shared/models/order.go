@@ -9,23 +9,36 @@ const (
 	OrderStatusCreated          OrderStatus = "CREATED"
 	OrderStatusPassedToAssembly OrderStatus = "PASSED_TO_ASSEMBLY"
 	OrderStatusAssembled        OrderStatus = "ASSEMBLED"
+	OrderStatusPassedToDelivery OrderStatus = "PASSED_TO_DELIVERY"
+	OrderStatusDelivered        OrderStatus = "DELIVERED"
 	OrderStatusCanceled         OrderStatus = "CANCELED"
 )
 
 type Order struct {
-	ID                    string      `json:"id"`
-	CustomerID            string      `json:"customer_id"`
-	Items                 []OrderItem `json:"items"`
-	TotalAmount           float64     `json:"total_amount"`
-	Status                OrderStatus `json:"status"`
-	CreatedAt             time.Time   `json:"created_at"`
-	AssemblyApplicationID string      `json:"assembly_application_id"`
+	ID                    string               `json:"id"`
+	CustomerID            string               `json:"customer_id"`
+	Items                 []OrderItem          `json:"items"`
+	TotalAmount           Money                `json:"total_amount"`
+	Currency              string               `json:"currency"`
+	Region                string               `json:"region,omitempty"`
+	Status                OrderStatus          `json:"status"`
+	CreatedAt             time.Time            `json:"created_at"`
+	UpdatedAt             time.Time            `json:"updated_at"`
+	AssemblyApplicationID string               `json:"assembly_application_id"`
+	AssemblyApplication   *AssemblyApplication `json:"assembly_application,omitempty"`
+	// AssemblyOverdueAt is set when the assembly of the order missed its SLA
+	AssemblyOverdueAt *time.Time `json:"assembly_overdue_at,omitempty"`
+	// DeliverySlotReservationID is the slot reservation made at checkout, it is confirmed when the order
+	// is created. DeliverySlot is the confirmed delivery window of the order.
+	DeliverySlotReservationID string        `json:"delivery_slot_reservation_id,omitempty"`
+	DeliverySlot              *DeliverySlot `json:"delivery_slot,omitempty"`
+	Version                   int           `json:"version"`
 }
 
 type OrderItem struct {
-	ProductID string  `json:"product_id"`
-	Quantity  int     `json:"quantity"`
-	Price     float64 `json:"price"`
+	ProductID string `json:"product_id"`
+	Quantity  int    `json:"quantity"`
+	Price     Money  `json:"price"`
 }
 
 type OrderType string
@@ -33,4 +46,7 @@ type OrderType string
 const (
 	OrderTypeAssembly OrderType = "ASSEMBLY"
 	OrderTypeDelivery OrderType = "DELIVERY"
-)
\ No newline at end of file
+	// OrderTypePayment is an order paid online: the total is authorized on creation
+	// and the cost of what was collected is captured after the assembly
+	OrderTypePayment OrderType = "PAYMENT"
+)
@@ -0,0 +1,34 @@
+package models
+
+import "time"
+
+type PaymentStatus string
+
+const (
+	// PaymentStatusPending is a payment whose authorization was sent to the gateway without an answer yet
+	PaymentStatusPending    PaymentStatus = "PENDING"
+	PaymentStatusAuthorized PaymentStatus = "AUTHORIZED"
+	PaymentStatusDeclined   PaymentStatus = "DECLINED"
+	PaymentStatusCaptured   PaymentStatus = "CAPTURED"
+	// PaymentStatusVoided is an authorization released without charging the customer
+	PaymentStatusVoided   PaymentStatus = "VOIDED"
+	PaymentStatusRefunded PaymentStatus = "REFUNDED"
+)
+
+// Payment is the payment of an order. Amount is authorized when the order is created, CapturedAmount
+// is charged for what was collected and RefundedAmount is returned to the customer.
+type Payment struct {
+	ID             string        `json:"id"`
+	OrderID        string        `json:"order_id"`
+	Status         PaymentStatus `json:"status"`
+	Amount         Money         `json:"amount"`
+	CapturedAmount Money         `json:"captured_amount"`
+	RefundedAmount Money         `json:"refunded_amount"`
+	Currency       string        `json:"currency"`
+	// GatewayReference identifies the authorization at the payment gateway
+	GatewayReference string    `json:"gateway_reference,omitempty"`
+	DeclineReason    string    `json:"decline_reason,omitempty"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	Version          int       `json:"version"`
+}
@@ -0,0 +1,120 @@
+package models
+
+// orderTransitions lists the statuses an order can move to from each status.
+// Final statuses have no outgoing transitions.
+var orderTransitions = map[OrderStatus][]OrderStatus{
+	OrderStatusNew:     {OrderStatusCreated, OrderStatusCanceled},
+	OrderStatusCreated: {OrderStatusPassedToAssembly, OrderStatusCanceled},
+	// PASSED_TO_ASSEMBLY -> CREATED: склад отменил сборку, заказ ждет другого склада или отмены
+	OrderStatusPassedToAssembly: {OrderStatusAssembled, OrderStatusCreated, OrderStatusCanceled},
+	// ASSEMBLED -> PASSED_TO_ASSEMBLY: сборка не прошла проверку качества, заказ собирают заново
+	OrderStatusAssembled: {OrderStatusPassedToAssembly, OrderStatusPassedToDelivery, OrderStatusCanceled},
+	// PASSED_TO_DELIVERY -> ASSEMBLED: доставка не удалась или отменена, посылки вернулись на склад
+	OrderStatusPassedToDelivery: {OrderStatusDelivered, OrderStatusAssembled, OrderStatusCanceled},
+	OrderStatusDelivered:        {},
+	OrderStatusCanceled:         {},
+}
+
+// CanTransitionTo reports whether an order in status s may be moved to next.
+func (s OrderStatus) CanTransitionTo(next OrderStatus) bool {
+	for _, allowed := range orderTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal reports whether no further transitions are allowed from s.
+func (s OrderStatus) IsFinal() bool {
+	allowed, ok := orderTransitions[s]
+	return ok && len(allowed) == 0
+}
+
+// assemblyTransitions lists the statuses an assembly application can move to from each status.
+var assemblyTransitions = map[AssemblyStatus][]AssemblyStatus{
+	AssemblyStatusNew:     {AssemblyStatusCreated, AssemblyStatusCanceled},
+	AssemblyStatusCreated: {AssemblyStatusSent, AssemblyStatusComplete, AssemblyStatusCanceled},
+	// SENT -> CREATED: сборщик вернул заявку в очередь
+	AssemblyStatusSent: {AssemblyStatusCreated, AssemblyStatusComplete, AssemblyStatusCanceled},
+	// COMPLETE -> REJECTED: собранный заказ не прошел проверку качества
+	// COMPLETE -> CANCELED: только вместе с отменой заказа, собранный товар возвращается на склад
+	AssemblyStatusComplete: {AssemblyStatusRejected, AssemblyStatusCanceled},
+	AssemblyStatusCanceled: {},
+	AssemblyStatusRejected: {},
+}
+
+// CanTransitionTo reports whether an assembly application in status s may be moved to next.
+func (s AssemblyStatus) CanTransitionTo(next AssemblyStatus) bool {
+	for _, allowed := range assemblyTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal reports whether no further transitions are allowed from s.
+func (s AssemblyStatus) IsFinal() bool {
+	allowed, ok := assemblyTransitions[s]
+	return ok && len(allowed) == 0
+}
+
+// IsClosed reports whether the assembly is over and the application can no longer be picked or changed.
+// A complete application is closed, though the quality check may still reject it.
+func (s AssemblyStatus) IsClosed() bool {
+	return s == AssemblyStatusComplete || s.IsFinal()
+}
+
+// deliveryTransitions lists the statuses a delivery application can move to from each status.
+var deliveryTransitions = map[DeliveryStatus][]DeliveryStatus{
+	DeliveryStatusCreated: {DeliveryStatusAssigned, DeliveryStatusCanceled},
+	// ASSIGNED -> CREATED: курьер отказался от заявки
+	DeliveryStatusAssigned:  {DeliveryStatusPickedUp, DeliveryStatusCreated, DeliveryStatusCanceled},
+	DeliveryStatusPickedUp:  {DeliveryStatusDelivered, DeliveryStatusFailed, DeliveryStatusCanceled},
+	DeliveryStatusDelivered: {},
+	DeliveryStatusFailed:    {},
+	DeliveryStatusCanceled:  {},
+}
+
+// CanTransitionTo reports whether a delivery application in status s may be moved to next.
+func (s DeliveryStatus) CanTransitionTo(next DeliveryStatus) bool {
+	for _, allowed := range deliveryTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal reports whether no further transitions are allowed from s.
+func (s DeliveryStatus) IsFinal() bool {
+	allowed, ok := deliveryTransitions[s]
+	return ok && len(allowed) == 0
+}
+
+// paymentTransitions lists the statuses a payment can move to from each status.
+var paymentTransitions = map[PaymentStatus][]PaymentStatus{
+	PaymentStatusPending:    {PaymentStatusAuthorized, PaymentStatusDeclined},
+	PaymentStatusAuthorized: {PaymentStatusCaptured, PaymentStatusVoided},
+	PaymentStatusCaptured:   {PaymentStatusRefunded},
+	PaymentStatusDeclined:   {},
+	PaymentStatusVoided:     {},
+	PaymentStatusRefunded:   {},
+}
+
+// CanTransitionTo reports whether a payment in status s may be moved to next.
+func (s PaymentStatus) CanTransitionTo(next PaymentStatus) bool {
+	for _, allowed := range paymentTransitions[s] {
+		if allowed == next {
+			return true
+		}
+	}
+	return false
+}
+
+// IsFinal reports whether no further transitions are allowed from s.
+func (s PaymentStatus) IsFinal() bool {
+	allowed, ok := paymentTransitions[s]
+	return ok && len(allowed) == 0
+}
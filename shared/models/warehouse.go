@@ -0,0 +1,11 @@
+package models
+
+// Warehouse is a store or a warehouse where orders are assembled.
+// Lower Priority wins when routing rules leave several warehouses.
+type Warehouse struct {
+	ID       string `json:"id"`
+	Name     string `json:"name"`
+	Region   string `json:"region,omitempty"`
+	Priority int    `json:"priority"`
+	Active   bool   `json:"active"`
+}
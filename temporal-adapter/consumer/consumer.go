@@ -25,6 +25,7 @@ type KafkaConsumer struct {
 type EventHandler interface {
 	HandleOrderEvent(event events.OrderEvent)
 	HandleAssemblyApplicationEvent(event events.AssemblyApplicationEvent)
+	HandleDeliveryApplicationEvent(event events.DeliveryApplicationEvent)
 }
 
 type ConsumerConfig struct {
@@ -37,6 +38,7 @@ type ConsumerConfig struct {
 type Topics struct {
 	Orders               string
 	AssemblyApplications string
+	DeliveryApplications string
 }
 
 func (t *Topics) ToStringArray() []string {
@@ -145,6 +147,9 @@ func (c *KafkaConsumer) ConsumeClaim(session sarama.ConsumerGroupSession, claim
 		case c.topics.AssemblyApplications:
 			c.logger.Printf("[info] Received message from topic %s", message.Topic)
 			c.handleAssemblyApplicationsTopic(message)
+		case c.topics.DeliveryApplications:
+			c.logger.Printf("[info] Received message from topic %s", message.Topic)
+			c.handleDeliveryApplicationsTopic(message)
 		default:
 			c.logger.Printf("[info] Received message from unknown topic %s", message.Topic)
 		}
@@ -173,3 +178,13 @@ func (c *KafkaConsumer) handleAssemblyApplicationsTopic(message *sarama.Consumer
 
 	c.handler.HandleAssemblyApplicationEvent(event)
 }
+
+func (c *KafkaConsumer) handleDeliveryApplicationsTopic(message *sarama.ConsumerMessage) {
+	var event events.DeliveryApplicationEvent
+	if err := json.Unmarshal(message.Value, &event); err != nil {
+		c.logger.Printf("[error] handleDeliveryApplicationsTopic error unmarshaling message: %v", err)
+		return
+	}
+
+	c.handler.HandleDeliveryApplicationEvent(event)
+}
@@ -7,6 +7,7 @@ require (
 	github.com/milovidov983/oms-temporal-demo/shared v0.0.0-20241122113211-e082f48f35f2
 	github.com/milovidov983/oms-temporal-demo/workers v0.0.0-20241122113211-e082f48f35f2
 	github.com/spf13/viper v1.19.0
+	go.temporal.io/api v1.40.0
 	go.temporal.io/sdk v1.30.0
 )
 
@@ -51,7 +52,6 @@ require (
 	github.com/stretchr/objx v0.5.2 // indirect
 	github.com/stretchr/testify v1.9.0 // indirect
 	github.com/subosito/gotenv v1.6.0 // indirect
-	go.temporal.io/api v1.40.0 // indirect
 	go.uber.org/atomic v1.9.0 // indirect
 	go.uber.org/multierr v1.9.0 // indirect
 	golang.org/x/crypto v0.26.0 // indirect
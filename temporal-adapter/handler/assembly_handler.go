@@ -1,7 +1,6 @@
 package handler
 
 import (
-	"context"
 	"log"
 	"os"
 	"time"
@@ -10,7 +9,6 @@ import (
 	"github.com/milovidov983/oms-temporal-demo/workers/signals"
 	"github.com/milovidov983/oms-temporal-demo/workers/signals/channels"
 	"github.com/milovidov983/oms-temporal-demo/workers/signals/routes"
-	"github.com/milovidov983/oms-temporal-demo/workers/workflows"
 
 	"go.temporal.io/sdk/client"
 )
@@ -40,8 +38,19 @@ func (h *Handler) HandleAssemblyApplicationEvent(event events.AssemblyApplicatio
 			err = h.handleAssemblyCreated(event)
 		case events.AssemblyCompleted:
 			err = h.handleAssemblyCompleted(event)
-		case events.OrderCancelled:
+		case events.AssemblyCancelled:
 			err = h.handleAssemblyCancelled(event)
+		case events.AssemblyRejected:
+			err = h.handleAssemblyRejected(event)
+		case events.AssemblyCommentChanged:
+			err = h.handleAssemblyCommentChanged(event)
+		case events.SubstitutionProposed:
+			err = h.handleSubstitutionProposed(event)
+		case events.SubstitutionApproved, events.SubstitutionRejected:
+			err = h.handleSubstitutionDecided(event)
+		case events.AssemblyOverdue:
+			// Просрочку отмечает сам workflow, сигнал не нужен
+			h.logger.Printf("[debug] Assembly application %s is overdue", event.EventData.ID)
 		default:
 			h.logger.Printf("[error] Unknown event type: %s", event.EventType)
 		}
@@ -59,31 +68,100 @@ func (h *Handler) HandleAssemblyApplicationEvent(event events.AssemblyApplicatio
 
 func (h *Handler) handleAssemblyCreated(event events.AssemblyApplicationEvent) error {
 	h.logger.Printf("[debug] Handling assembly created event: %v", event)
-	// not implemented
-	return nil
+
+	update := signals.SignalPayloadStartAssembly{
+		Route:                 routes.RouteTypeStartAssembly,
+		AssemblyApplicationID: event.EventData.ID,
+		Items:                 event.EventData.Items,
+	}
+
+	return h.signalOrderWorkflow(event.EventData.OrderID, channels.SignalNameStartAssemblyChannel, update)
 }
 func (h *Handler) handleAssemblyCancelled(event events.AssemblyApplicationEvent) error {
 	h.logger.Printf("[debug] Handling assembly cancelled event: %v", event)
-	// not implemented
-	return nil
+
+	update := signals.SignalPayloadCancelAssembly{
+		Route:                 routes.RouteTypeCancelAssembly,
+		AssemblyApplicationID: event.EventData.ID,
+		WarehouseID:           event.EventData.WarehouseID,
+		Reason:                event.EventData.Reason,
+	}
+
+	// После отмены заказа workflow сам отменяет заявку и к приходу события уже завершен
+	return h.signalOrderWorkflow(event.EventData.OrderID, channels.SignalNameCancelAssemblyChannel, update)
 }
+func (h *Handler) handleAssemblyRejected(event events.AssemblyApplicationEvent) error {
+	h.logger.Printf("[debug] Handling assembly rejected event: %v", event)
+
+	update := signals.SignalPayloadRejectAssembly{
+		Route:                     routes.RouteTypeRejectAssembly,
+		AssemblyApplicationID:     event.EventData.ID,
+		NextAssemblyApplicationID: event.EventData.NextApplicationID,
+		Reason:                    event.EventData.Reason,
+	}
+	if event.EventData.NextDueAt != nil {
+		update.NextDueAt = *event.EventData.NextDueAt
+	}
+
+	return h.signalOrderWorkflow(event.EventData.OrderID, channels.SignalNameRejectAssemblyChannel, update)
+}
+
 func (h *Handler) handleAssemblyCompleted(event events.AssemblyApplicationEvent) error {
 	h.logger.Printf("[debug] Handling assembly completed event: %v", event)
 
-	workflowID := workflows.OrderProcessingWorkflowID(event.EventData.OrderID)
-
 	update := signals.SignalPayloadCompleteAssembly{
 		Route:     routes.RouteTypeCompleteAssembly,
 		Collected: event.EventData.Collected,
+		Shortages: event.EventData.Shortages,
+		Packages:  event.EventData.Packages,
 	}
-	signalName := channels.SignalNameCompleteAssemblyChannel
 
-	err := h.temporal.SignalWorkflow(context.Background(), workflowID, "", signalName, update)
+	return h.signalOrderWorkflow(event.EventData.OrderID, channels.SignalNameCompleteAssemblyChannel, update)
+}
 
-	if err != nil {
-		h.logger.Printf("[error] Error signaling workflow: %v", err)
-		return err
+func (h *Handler) handleAssemblyCommentChanged(event events.AssemblyApplicationEvent) error {
+	h.logger.Printf("[debug] Handling assembly comment changed event: %v", event)
+
+	update := signals.SignalPayloadChangeAssemblyComment{
+		Route:                 routes.RouteTypeChangeAssemblyComment,
+		AssemblyApplicationID: event.EventData.ID,
+		Comment:               event.EventData.Comment,
+		Author:                event.EventData.Actor,
+	}
+
+	return h.signalOrderWorkflow(event.EventData.OrderID, channels.SignalNameChangeAssemblyCommentChannel, update)
+}
+
+func (h *Handler) handleSubstitutionProposed(event events.AssemblyApplicationEvent) error {
+	h.logger.Printf("[debug] Handling substitution proposed event: %v", event)
+
+	if event.EventData.Substitution == nil {
+		h.logger.Printf("[error] Substitution is missing in event for assembly application %s", event.EventData.ID)
+		return nil
+	}
+
+	update := signals.SignalPayloadProposeSubstitution{
+		Route:                 routes.RouteTypeProposeSubstitution,
+		AssemblyApplicationID: event.EventData.ID,
+		Substitution:          *event.EventData.Substitution,
+	}
+
+	return h.signalOrderWorkflow(event.EventData.OrderID, channels.SignalNameProposeSubstitutionChannel, update)
+}
+
+func (h *Handler) handleSubstitutionDecided(event events.AssemblyApplicationEvent) error {
+	h.logger.Printf("[debug] Handling substitution decided event: %v", event)
+
+	if event.EventData.Substitution == nil {
+		h.logger.Printf("[error] Substitution is missing in event for assembly application %s", event.EventData.ID)
+		return nil
+	}
+
+	update := signals.SignalPayloadDecideSubstitution{
+		Route:                 routes.RouteTypeDecideSubstitution,
+		AssemblyApplicationID: event.EventData.ID,
+		Substitution:          *event.EventData.Substitution,
 	}
 
-	return nil
+	return h.signalOrderWorkflow(event.EventData.OrderID, channels.SignalNameDecideSubstitutionChannel, update)
 }
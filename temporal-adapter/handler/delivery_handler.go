@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/events"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals/channels"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals/routes"
+	"github.com/milovidov983/oms-temporal-demo/workers/workflows"
+)
+
+// HandleDeliveryApplicationEvent signals the ProcessDelivery child workflow of the order.
+func (h *Handler) HandleDeliveryApplicationEvent(event events.DeliveryApplicationEvent) {
+	isFirstTime := true
+	var err error
+	for isFirstTime || err != nil {
+		isFirstTime = false
+		switch event.EventType {
+		case events.DeliveryPickedUp:
+			err = h.handleDeliveryPickedUp(event)
+		case events.DeliveryDelivered:
+			err = h.handleDeliveryDelivered(event)
+		case events.DeliveryFailed:
+			err = h.handleDeliveryFailed(event)
+		case events.DeliveryCreated, events.DeliveryAssigned, events.DeliveryReleased, events.DeliveryCancelled:
+			// Заявку создает и отменяет сам workflow, назначение курьера на обработку заказа не влияет
+			h.logger.Printf("[debug] Delivery application %s: %s", event.EventData.ID, event.EventType)
+		default:
+			h.logger.Printf("[error] Unknown event type: %s", event.EventType)
+		}
+
+		if err != nil {
+			h.logger.Printf("[error] Error handling event: %v", err)
+
+			h.logger.Printf("[debug] Sleep 5 seconds...")
+			time.Sleep(5 * time.Second)
+		}
+	}
+}
+
+func (h *Handler) handleDeliveryPickedUp(event events.DeliveryApplicationEvent) error {
+	h.logger.Printf("[debug] Handling delivery picked up event: %v", event)
+
+	update := signals.SignalPayloadStartDelivery{
+		Route:                 routes.RouteTypeStartDelivery,
+		DeliveryApplicationID: event.EventData.ID,
+		CourierID:             event.EventData.CourierID,
+	}
+
+	return h.signalDeliveryWorkflow(event.EventData.OrderID, channels.SignalNameStartDeliveryChannel, update)
+}
+
+func (h *Handler) handleDeliveryDelivered(event events.DeliveryApplicationEvent) error {
+	h.logger.Printf("[debug] Handling delivery delivered event: %v", event)
+
+	update := signals.SignalPayloadCompleteDelivery{
+		Route:                 routes.RouteTypeCompleteDelivery,
+		DeliveryApplicationID: event.EventData.ID,
+	}
+
+	return h.signalDeliveryWorkflow(event.EventData.OrderID, channels.SignalNameCompleteDeliveryChannel, update)
+}
+
+func (h *Handler) handleDeliveryFailed(event events.DeliveryApplicationEvent) error {
+	h.logger.Printf("[debug] Handling delivery failed event: %v", event)
+
+	update := signals.SignalPayloadFailDelivery{
+		Route:                 routes.RouteTypeFailDelivery,
+		DeliveryApplicationID: event.EventData.ID,
+		CourierID:             event.EventData.CourierID,
+		Reason:                event.EventData.Reason,
+	}
+
+	return h.signalDeliveryWorkflow(event.EventData.OrderID, channels.SignalNameFailDeliveryChannel, update)
+}
+
+// signalDeliveryWorkflow signals the ProcessDelivery child workflow of the order. The delivery workflow
+// ends with the delivery, so late events are dropped like for the order workflow.
+func (h *Handler) signalDeliveryWorkflow(orderID, signalName string, payload interface{}) error {
+	return h.signalWorkflow(workflows.DeliveryProcessingWorkflowID(orderID), signalName, payload)
+}
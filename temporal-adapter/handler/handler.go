@@ -1,9 +1,14 @@
 package handler
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 
+	"github.com/milovidov983/oms-temporal-demo/workers/workflows"
+
+	"go.temporal.io/api/serviceerror"
 	"go.temporal.io/sdk/client"
 )
 
@@ -47,3 +52,26 @@ func NewHandler(cfg HandlerConfig) (*Handler, error) {
 		temporal: client,
 	}, nil
 }
+
+// signalOrderWorkflow signals the processing workflow of the order. A completed workflow cannot take
+// the signal: the event came after the processing was over, so it is logged and dropped instead of retried.
+func (h *Handler) signalOrderWorkflow(orderID, signalName string, payload interface{}) error {
+	return h.signalWorkflow(workflows.OrderProcessingWorkflowID(orderID), signalName, payload)
+}
+
+// signalWorkflow signals the running workflow with workflowID, a workflow that is not running drops the signal.
+func (h *Handler) signalWorkflow(workflowID, signalName string, payload interface{}) error {
+	err := h.temporal.SignalWorkflow(context.Background(), workflowID, "", signalName, payload)
+
+	var notFound *serviceerror.NotFound
+	if errors.As(err, &notFound) {
+		h.logger.Printf("[warn] Workflow %s is not running, signal %s dropped: %v", workflowID, signalName, err)
+		return nil
+	}
+	if err != nil {
+		h.logger.Printf("[error] Error signaling workflow: %v", err)
+		return err
+	}
+
+	return nil
+}
@@ -6,7 +6,9 @@ import (
 
 	"github.com/milovidov983/oms-temporal-demo/shared/events"
 	"github.com/milovidov983/oms-temporal-demo/workers/queue"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals"
 	"github.com/milovidov983/oms-temporal-demo/workers/signals/channels"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals/routes"
 	"github.com/milovidov983/oms-temporal-demo/workers/workflows"
 
 	"go.temporal.io/sdk/client"
@@ -23,6 +25,8 @@ func (h *Handler) HandleOrderEvent(event events.OrderEvent) {
 			err = h.handleOrderCreated(event)
 		case events.OrderCancelled:
 			err = h.handleOrderCancelled(event)
+		case events.OrderAmended:
+			err = h.handleOrderAmended(event)
 		default:
 			h.logger.Printf("[error] Unknown event type: %s", event.EventType)
 		}
@@ -67,10 +71,32 @@ func (h *Handler) handleOrderCreated(event events.OrderEvent) error {
 	return nil
 }
 
+// handleOrderCancelled passes the cancellation accepted by oms-core to the workflow, which compensates
+// the processing. When the processing is already over the cancellation is rejected: it is logged
+// and not retried.
 func (h *Handler) handleOrderCancelled(event events.OrderEvent) error {
 	h.logger.Printf("[debug] Processing OrderCancelled event: %+v", event.EventData)
-	// not implemented
-	return nil
+
+	update := signals.SignalPayloadCancelOrder{
+		Route:  routes.RouteTypeCancelOrder,
+		Reason: event.Reason,
+	}
+
+	return h.signalOrderWorkflow(event.EventData.ID, channels.SignalNameCancelOrderChannel, update)
+}
+
+// handleOrderAmended passes the new lines and total of the order to the workflow, which raises
+// the authorization of the payment when the total grew.
+func (h *Handler) handleOrderAmended(event events.OrderEvent) error {
+	h.logger.Printf("[debug] Processing OrderAmended event: %+v", event.EventData)
+
+	update := signals.SignalPayloadAmendOrder{
+		Route:       routes.RouteTypeAmendOrder,
+		Items:       event.EventData.Items,
+		TotalAmount: event.EventData.TotalAmount,
+	}
+
+	return h.signalOrderWorkflow(event.EventData.ID, channels.SignalNameAmendOrderChannel, update)
 }
 
 func getOrderWorkflowID(orderID string) string {
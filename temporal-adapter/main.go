@@ -31,6 +31,7 @@ func main() {
 		Topics: consumer.Topics{
 			Orders:               viper.GetString("kafka.topics.orders"),
 			AssemblyApplications: viper.GetString("kafka.topics.assembly"),
+			DeliveryApplications: viper.GetString("kafka.topics.delivery"),
 		},
 		Handler: handler,
 	}
@@ -5,12 +5,20 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/milovidov983/oms-temporal-demo/shared/models"
+	"go.temporal.io/sdk/temporal"
 )
 
+// actorName is sent to oms-core so status changes made by activities are attributed to the workflow.
+const actorName = "temporal-worker"
+
 type ActivitiesConfig struct {
 	OmsCoreHostPort string
 }
@@ -37,9 +45,287 @@ type Input struct {
 	OrderID string
 }
 
-func (a *Activities) CreateAssemblyApplication(ctx context.Context, input *Input) (string, error) {
+type CreateAssemblyApplicationInput struct {
+	OrderID string
+	// OrderType selects the assembly SLA of the warehouse
+	OrderType models.OrderType
+}
+
+type CreateAssemblyApplicationOutput struct {
+	AssemblyApplicationID string
+	// DueAt is when the assembly has to be done by the SLA
+	DueAt time.Time
+}
+
+// CreateAssemblyApplication passes the order to assembly in the warehouse chosen by oms-core.
+// When no warehouse can take the order the error is not retried.
+// AssemblyNotCreatedErrorType is the type of the error CreateAssemblyApplication fails with when oms-core
+// refuses to pass the order to assembly, for example because no warehouse can assemble it.
+const AssemblyNotCreatedErrorType = "AssemblyNotCreated"
+
+func (a *Activities) CreateAssemblyApplication(ctx context.Context, input *CreateAssemblyApplicationInput) (*CreateAssemblyApplicationOutput, error) {
 	url := "http://" + a.OmsCoreHost + "/api/assembly"
 
+	request := struct {
+		OrderID   string           `json:"order_id"`
+		OrderType models.OrderType `json:"order_type"`
+	}{
+		OrderID:   input.OrderID,
+		OrderType: input.OrderType,
+	}
+
+	jsonBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusUnprocessableEntity {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("order %s cannot be passed to assembly, status code: %d", input.OrderID, resp.StatusCode),
+			AssemblyNotCreatedErrorType, nil)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	var responseBody struct {
+		ApplicationID string    `json:"application_id"`
+		DueAt         time.Time `json:"due_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&responseBody); err != nil {
+		return nil, err
+	}
+
+	if responseBody.ApplicationID == "" {
+		return nil, fmt.Errorf("missing application_id in response")
+	}
+
+	return &CreateAssemblyApplicationOutput{
+		AssemblyApplicationID: responseBody.ApplicationID,
+		DueAt:                 responseBody.DueAt,
+	}, nil
+}
+
+type AssemblyApplicationInput struct {
+	AssemblyApplicationID string
+}
+
+// MarkAssemblyOverdue escalates the assembly that missed its SLA. oms-core marks the order and publishes
+// AssemblyOverdue. An application that was closed in the meantime is left as it is.
+func (a *Activities) MarkAssemblyOverdue(ctx context.Context, input *AssemblyApplicationInput) error {
+	url := "http://" + a.OmsCoreHost + "/api/assembly/" + url.PathEscape(input.AssemblyApplicationID) + "/overdue"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		log.Printf("[info] assembly application %s is already closed", input.AssemblyApplicationID)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// AssemblyNotAcceptedErrorType is the type of the error AcceptAssembly fails with when the application
+// was rejected by the quality check or the order was canceled before the workflow accepted it.
+const AssemblyNotAcceptedErrorType = "AssemblyNotAccepted"
+
+// AcceptAssembly tells oms-core that the workflow moves past the assembly, the quality check can no longer
+// reject the application after that.
+func (a *Activities) AcceptAssembly(ctx context.Context, input *AssemblyApplicationInput) error {
+	url := "http://" + a.OmsCoreHost + "/api/assembly/" + url.PathEscape(input.AssemblyApplicationID) + "/accept"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("assembly application %s cannot be accepted, status code: %d", input.AssemblyApplicationID, resp.StatusCode),
+			AssemblyNotAcceptedErrorType, nil)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type CancelAssemblyApplicationInput struct {
+	AssemblyApplicationID string
+	Reason                string
+}
+
+// CancelAssemblyApplication cancels the assembly of a canceled order. oms-core releases the stock reserved
+// by the application, or returns the collected items to the stock when the assembly was complete.
+// An application that was already canceled or rejected is left as it is.
+func (a *Activities) CancelAssemblyApplication(ctx context.Context, input *CancelAssemblyApplicationInput) error {
+	url := "http://" + a.OmsCoreHost + "/api/assembly/cancel"
+
+	request := struct {
+		ApplicationID string `json:"application_id"`
+		Reason        string `json:"reason"`
+	}{
+		ApplicationID: input.AssemblyApplicationID,
+		Reason:        input.Reason,
+	}
+
+	jsonBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		log.Printf("[info] assembly application %s is already closed", input.AssemblyApplicationID)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type CancelOrderInput struct {
+	OrderID string
+	Reason  string
+}
+
+// CancelOrder cancels the order in oms-core. The reason is stored in the order history.
+// An order that cannot be canceled, e.g. one that is already delivered, is not retried.
+func (a *Activities) CancelOrder(ctx context.Context, input *CancelOrderInput) error {
+	query := url.Values{}
+	query.Set("order_id", input.OrderID)
+	query.Set("reason", input.Reason)
+	url := "http://" + a.OmsCoreHost + "/api/orders/cancel?" + query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound ||
+		resp.StatusCode == http.StatusUnprocessableEntity {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("order %s cannot be canceled, status code: %d", input.OrderID, resp.StatusCode),
+			"OrderNotCanceled", nil)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type SubstitutionInput struct {
+	AssemblyApplicationID string
+	SubstitutionID        string
+}
+
+// RejectSubstitution rejects a substitution the customer did not answer in time.
+// A substitution that was already decided or belongs to a closed application is left as it is.
+func (a *Activities) RejectSubstitution(ctx context.Context, input *SubstitutionInput) error {
+	url := "http://" + a.OmsCoreHost + "/api/assembly/" + url.PathEscape(input.AssemblyApplicationID) +
+		"/substitutions/" + url.PathEscape(input.SubstitutionID) + "/reject"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		log.Printf("[info] substitution %s is already decided", input.SubstitutionID)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type CreateDeliveryApplicationInput struct {
+	OrderID               string
+	AssemblyApplicationID string
+	// Packages are the parcels handed over to the courier
+	Packages []models.Package
+}
+
+type CreateDeliveryApplicationOutput struct {
+	DeliveryApplicationID string
+}
+
+// CreateDeliveryApplication passes the assembled order to delivery. oms-core takes the packages from
+// the complete assembly application of the order. An order that is not assembled is not retried.
+func (a *Activities) CreateDeliveryApplication(ctx context.Context, input *CreateDeliveryApplicationInput) (*CreateDeliveryApplicationOutput, error) {
+	url := "http://" + a.OmsCoreHost + "/api/delivery"
+
 	request := struct {
 		OrderID string `json:"order_id"`
 	}{
@@ -48,37 +334,325 @@ func (a *Activities) CreateAssemblyApplication(ctx context.Context, input *Input
 
 	jsonBytes, err := json.Marshal(request)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", actorName)
 
 	client := http.DefaultClient
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusConflict {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("order %s cannot be passed to delivery, status code: %d", input.OrderID, resp.StatusCode),
+			"DeliveryNotCreated", nil)
+	}
 	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
 	}
 
-	var responseBody map[string]string
-	if err := json.NewDecoder(resp.Body).Decode(&responseBody); err != nil {
-		return "", err
+	var application models.DeliveryApplication
+	if err := json.NewDecoder(resp.Body).Decode(&application); err != nil {
+		return nil, err
+	}
+
+	if application.ID == "" {
+		return nil, fmt.Errorf("missing id in response")
+	}
+
+	return &CreateDeliveryApplicationOutput{
+		DeliveryApplicationID: application.ID,
+	}, nil
+}
+
+type CancelDeliveryApplicationInput struct {
+	DeliveryApplicationID string
+	Reason                string
+}
+
+// CancelDeliveryApplication withdraws the order from delivery. An application that was already
+// delivered, failed or canceled is left as it is.
+func (a *Activities) CancelDeliveryApplication(ctx context.Context, input *CancelDeliveryApplicationInput) error {
+	url := "http://" + a.OmsCoreHost + "/api/delivery/" + url.PathEscape(input.DeliveryApplicationID) + "/cancel"
+
+	request := struct {
+		Reason string `json:"reason"`
+	}{
+		Reason: input.Reason,
+	}
+
+	jsonBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict {
+		log.Printf("[info] delivery application %s is already closed", input.DeliveryApplicationID)
+		return nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// PaymentDeclinedErrorType is the type of the error AuthorizePayment and ReauthorizePayment fail with
+// when the gateway declines the payment.
+// Its details hold the decline reason.
+const PaymentDeclinedErrorType = "PaymentDeclined"
+
+type AuthorizePaymentOutput struct {
+	PaymentID string
+	Amount    models.Money
+}
+
+// AuthorizePayment holds the total of the order through the payment gateway of oms-core. A declined
+// payment is not retried.
+func (a *Activities) AuthorizePayment(ctx context.Context, input *Input) (*AuthorizePaymentOutput, error) {
+	url := "http://" + a.OmsCoreHost + "/api/payments"
+
+	request := struct {
+		OrderID string `json:"order_id"`
+	}{
+		OrderID: input.OrderID,
+	}
+
+	jsonBytes, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	var payment models.Payment
+	if err := json.NewDecoder(resp.Body).Decode(&payment); err != nil {
+		return nil, err
+	}
+
+	if payment.Status == models.PaymentStatusDeclined {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("payment of order %s declined: %s", input.OrderID, payment.DeclineReason),
+			PaymentDeclinedErrorType, nil, payment.DeclineReason)
+	}
+
+	return &AuthorizePaymentOutput{
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+	}, nil
+}
+
+type ReauthorizePaymentInput struct {
+	PaymentID string
+}
+
+// ReauthorizePayment raises the authorization of the payment to the current total of the order. When the
+// gateway refuses to hold more, the activity fails with the non-retryable PaymentDeclined error and the payment
+// keeps its amount.
+func (a *Activities) ReauthorizePayment(ctx context.Context, input *ReauthorizePaymentInput) (*AuthorizePaymentOutput, error) {
+	url := "http://" + a.OmsCoreHost + "/api/payments/" + url.PathEscape(input.PaymentID) + "/reauthorize"
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPaymentRequired {
+		body, _ := io.ReadAll(resp.Body)
+		declineReason := strings.TrimSpace(string(body))
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("payment %s cannot be increased: %s", input.PaymentID, declineReason),
+			PaymentDeclinedErrorType, nil, declineReason)
+	}
+	if resp.StatusCode == http.StatusConflict {
+		return nil, temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("payment %s cannot be increased, status code: %d", input.PaymentID, resp.StatusCode),
+			"PaymentNotReauthorized", nil)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	var payment models.Payment
+	if err := json.NewDecoder(resp.Body).Decode(&payment); err != nil {
+		return nil, err
+	}
+
+	return &AuthorizePaymentOutput{
+		PaymentID: payment.ID,
+		Amount:    payment.Amount,
+	}, nil
+}
+
+type CapturePaymentInput struct {
+	PaymentID string
+	Amount    models.Money
+}
+
+// CapturePayment charges the cost of what was collected. When oms-core refuses the capture,
+// e.g. the order costs more than was authorized, the error is not retried.
+func (a *Activities) CapturePayment(ctx context.Context, input *CapturePaymentInput) error {
+	url := "http://" + a.OmsCoreHost + "/api/payments/" + url.PathEscape(input.PaymentID) + "/capture"
+
+	request := struct {
+		Amount models.Money `json:"amount"`
+	}{
+		Amount: input.Amount,
+	}
+
+	jsonBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusUnprocessableEntity {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("payment %s cannot be captured for %s, status code: %d", input.PaymentID, input.Amount, resp.StatusCode),
+			"PaymentNotCaptured", nil)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+type CancelPaymentInput struct {
+	PaymentID string
+	Reason    string
+}
+
+// CancelPayment gives the money back: oms-core voids the authorization or refunds the captured amount.
+// A payment that is already voided or refunded is left as it is, one oms-core refuses to cancel is not retried.
+func (a *Activities) CancelPayment(ctx context.Context, input *CancelPaymentInput) error {
+	url := "http://" + a.OmsCoreHost + "/api/payments/" + url.PathEscape(input.PaymentID) + "/cancel"
+
+	request := struct {
+		Reason string `json:"reason"`
+	}{
+		Reason: input.Reason,
+	}
+
+	jsonBytes, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonBytes))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Actor", actorName)
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound ||
+		resp.StatusCode == http.StatusUnprocessableEntity {
+		return temporal.NewNonRetryableApplicationError(
+			fmt.Sprintf("payment %s cannot be canceled, status code: %d", input.PaymentID, resp.StatusCode),
+			"PaymentNotCanceled", nil)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// GetDeliverySlot returns the delivery window confirmed for the order at checkout,
+// nil when the customer did not choose one.
+func (a *Activities) GetDeliverySlot(ctx context.Context, input *Input) (*models.DeliverySlot, error) {
+	url := "http://" + a.OmsCoreHost + "/api/orders/" + url.PathEscape(input.OrderID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := http.DefaultClient
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("received non-200 status code: %d", resp.StatusCode)
 	}
 
-	applicationID, ok := responseBody["application_id"]
-	if !ok {
-		return "", fmt.Errorf("missing application_id in response")
+	var order models.Order
+	if err := json.NewDecoder(resp.Body).Decode(&order); err != nil {
+		return nil, err
 	}
 
-	return applicationID, nil
+	return order.DeliverySlot, nil
 }
 
 func (a *Activities) GetOrderTypes(ctx context.Context, input *Input) ([]models.OrderType, error) {
@@ -89,10 +663,11 @@ func (a *Activities) GetOrderTypes(ctx context.Context, input *Input) ([]models.
 	// Если один из признаков удаляется, например клиент решил приехать сам за заказом. То это свойство
 	// можно поменять послав соответсвующий сигнал в wkrkflow
 
-	// mock: для целей демонстраии, все заказы со сборкой и доставкой
+	// mock: для целей демонстраии, все заказы со сборкой, доставкой и оплатой
 	orderTypes := []models.OrderType{
 		models.OrderTypeDelivery,
 		models.OrderTypeAssembly,
+		models.OrderTypePayment,
 	}
 
 	return orderTypes, nil
@@ -40,6 +40,7 @@ func main() {
 	w := worker.New(c, queue.TaskQueueNameOrder, worker.Options{})
 
 	w.RegisterWorkflow(workflows.ProcessOrder)
+	w.RegisterWorkflow(workflows.ProcessDelivery)
 
 	w.RegisterActivity(a)
 
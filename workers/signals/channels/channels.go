@@ -3,8 +3,17 @@ package channels
 const SignalNameStartOrderProcessingChannel = "START_ORDER_PROCESSING_CHANNEL"
 const SignalNameStartAssemblyChannel = "START_ASSEMBLY_CHANNEL"
 const SignalNameCompleteAssemblyChannel = "COMPLETE_ASSEMBLY_CHANNEL"
+const SignalNameCancelAssemblyChannel = "CANCEL_ASSEMBLY_CHANNEL"
+const SignalNameRejectAssemblyChannel = "REJECT_ASSEMBLY_CHANNEL"
 const SignalNameChangeAssemblyCommentChannel = "CHANGE_ASSEMBLY_COMMENT_CHANNEL"
+const SignalNameProposeSubstitutionChannel = "PROPOSE_SUBSTITUTION_CHANNEL"
+const SignalNameDecideSubstitutionChannel = "DECIDE_SUBSTITUTION_CHANNEL"
 const SignalNameStartDeliveryChannel = "START_DELIVERY_CHANNEL"
 const SignalNameCompleteDeliveryChannel = "COMPLETE_DELIVERY_CHANNEL"
+const SignalNameFailDeliveryChannel = "FAIL_DELIVERY_CHANNEL"
 const SignalNameChangeDeliveryCommentChannel = "CHANGE_DELIVERY_COMMENT_CHANNEL"
+
+// SignalNameDeliveryProgressChannel is signaled by the ProcessDelivery child workflow to its parent
+const SignalNameDeliveryProgressChannel = "DELIVERY_PROGRESS_CHANNEL"
 const SignalNameCancelOrderChannel = "CANCEL_ORDER_CHANNEL"
+const SignalNameAmendOrderChannel = "AMEND_ORDER_CHANNEL"
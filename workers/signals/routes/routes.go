@@ -1,8 +1,17 @@
 package routes
 
 const RouteTypeStartProcessing = "start_processing"
+const RouteTypeStartAssembly = "start_assembly"
 const RouteTypeCompleteAssembly = "complete_assembly"
+const RouteTypeCancelAssembly = "cancel_assembly"
+const RouteTypeRejectAssembly = "reject_assembly"
 const RouteTypeChangeAssemblyComment = "change_assembly_comment"
+const RouteTypeProposeSubstitution = "propose_substitution"
+const RouteTypeDecideSubstitution = "decide_substitution"
+const RouteTypeStartDelivery = "start_delivery"
 const RouteTypeCompleteDelivery = "complete_delivery"
+const RouteTypeFailDelivery = "fail_delivery"
+const RouteTypeDeliveryProgress = "delivery_progress"
 const RouteTypeChangeDeliveryComment = "change_delivery_comment"
 const RouteTypeCancelOrder = "cancel_order"
+const RouteTypeAmendOrder = "amend_order"
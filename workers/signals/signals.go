@@ -1,24 +1,99 @@
 package signals
 
-import "github.com/milovidov983/oms-temporal-demo/shared/models"
+import (
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+)
 
 type SignalPayloadStartProcessing struct {
 	Route string
 }
 
+// SignalPayloadStartAssembly tells the workflow the warehouse accepted the assembly application.
+type SignalPayloadStartAssembly struct {
+	Route                 string
+	AssemblyApplicationID string
+	Items                 []models.AssemblyItem
+}
+
+// SignalPayloadCancelAssembly tells the workflow the warehouse canceled the assembly application.
+type SignalPayloadCancelAssembly struct {
+	Route                 string
+	AssemblyApplicationID string
+	WarehouseID           string
+	Reason                string
+}
+
+// SignalPayloadRejectAssembly tells the workflow the assembled order failed the quality check
+// and is assembled again by the next application.
+type SignalPayloadRejectAssembly struct {
+	Route                     string
+	AssemblyApplicationID     string
+	NextAssemblyApplicationID string
+	NextDueAt                 time.Time
+	Reason                    string
+}
+
+// SignalPayloadCompleteAssembly carries the lines as they were picked
+// and the missing quantities, both with order prices, and the packages for the delivery.
 type SignalPayloadCompleteAssembly struct {
 	Route     string
 	Collected []models.OrderItem
+	Shortages []models.OrderItem
+	Packages  []models.Package
 }
 
 type SignalPayloadChangeAssemblyComment struct {
-	Route   string
-	Comment string
+	Route                 string
+	AssemblyApplicationID string
+	Comment               string
+	Author                string
+}
+
+// SignalPayloadProposeSubstitution tells the workflow the picker proposed a substitute,
+// the customer has to approve or reject it.
+type SignalPayloadProposeSubstitution struct {
+	Route                 string
+	AssemblyApplicationID string
+	Substitution          models.Substitution
+}
+
+// SignalPayloadDecideSubstitution carries the decision of the customer, Substitution.Status
+// is APPROVED or REJECTED.
+type SignalPayloadDecideSubstitution struct {
+	Route                 string
+	AssemblyApplicationID string
+	Substitution          models.Substitution
+}
+
+// SignalPayloadStartDelivery tells the delivery workflow the courier took the order.
+type SignalPayloadStartDelivery struct {
+	Route                 string
+	DeliveryApplicationID string
+	CourierID             string
 }
 
 type SignalPayloadCompleteDelivery struct {
-	Route     string
-	Delivered []models.OrderItem
+	Route                 string
+	DeliveryApplicationID string
+	Delivered             []models.OrderItem
+}
+
+// SignalPayloadFailDelivery tells the delivery workflow the courier could not deliver the order.
+type SignalPayloadFailDelivery struct {
+	Route                 string
+	DeliveryApplicationID string
+	CourierID             string
+	Reason                string
+}
+
+// SignalPayloadDeliveryProgress is sent by the delivery workflow to the order workflow: once the delivery
+// application is created and again with CourierID when the courier took the order.
+type SignalPayloadDeliveryProgress struct {
+	Route                 string
+	DeliveryApplicationID string
+	CourierID             string
 }
 
 type SignalPayloadChangeDeliveryComment struct {
@@ -30,3 +105,10 @@ type SignalPayloadCancelOrder struct {
 	Route  string
 	Reason string
 }
+
+// SignalPayloadAmendOrder carries the lines and the total of the order after a line was added, changed or removed.
+type SignalPayloadAmendOrder struct {
+	Route       string
+	Items       []models.OrderItem
+	TotalAmount models.Money
+}
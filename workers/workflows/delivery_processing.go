@@ -0,0 +1,251 @@
+package workflows
+
+import (
+	"time"
+
+	"github.com/milovidov983/oms-temporal-demo/shared/models"
+	"github.com/milovidov983/oms-temporal-demo/workers/activities"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals/channels"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals/routes"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+)
+
+const DeliveryProcessingStatusQuery = "delivery-processing-status"
+
+// DeliveryFailedErrorType is the type of the error ProcessDelivery fails with when the courier could not
+// deliver the order. Its details hold the DeliveryFailure.
+const DeliveryFailedErrorType = "DeliveryFailed"
+
+type DeliveryProcessingWorkflowInput struct {
+	OrderID               string
+	AssemblyApplicationID string
+	// Items are the collected lines, they are delivered unless the courier reports otherwise
+	Items    []models.OrderItem
+	Packages []models.Package
+}
+
+// DeliveryProcessingState uses the delivery statuses of the order processing:
+// transferred_to_delivery, delivery_in_progress and delivered.
+type DeliveryProcessingState struct {
+	OrderID               string
+	DeliveryApplicationID string
+	CurrentState          OrderProcessingStatus
+	CourierID             string
+	Comments              []DeliveryComment
+	Delivered             []models.OrderItem
+	DeliveredAt           time.Time
+}
+
+// DeliveryFailure is a delivery attempt the courier could not make, oms-core returned the order to ASSEMBLED.
+type DeliveryFailure struct {
+	DeliveryApplicationID string
+	CourierID             string
+	Reason                string
+	FailedAt              time.Time
+}
+
+type DeliveryComment struct {
+	Comment   string
+	ChangedAt time.Time
+}
+
+// DeliveryProcessingResult is reported by ProcessDelivery to the order workflow when the order is delivered.
+type DeliveryProcessingResult struct {
+	DeliveryApplicationID string
+	CourierID             string
+	Delivered             []models.OrderItem
+	DeliveredAt           time.Time
+}
+
+type deliveryProcessingWorkflow struct {
+	DeliveryProcessingState
+	input  *DeliveryProcessingWorkflowInput
+	logger log.Logger
+}
+
+// ProcessDelivery is a child workflow of ProcessOrder for orders with delivery. It creates the delivery
+// application, follows the delivery by signals and returns the result to the parent. The parent cancels
+// the workflow when the order is canceled, the delivery application is canceled then. When the courier
+// could not deliver the order, the workflow fails with DeliveryFailedErrorType.
+func ProcessDelivery(ctx workflow.Context, input *DeliveryProcessingWorkflowInput) (*DeliveryProcessingResult, error) {
+	w := &deliveryProcessingWorkflow{
+		DeliveryProcessingState: DeliveryProcessingState{
+			OrderID: input.OrderID,
+		},
+		input:  input,
+		logger: workflow.GetLogger(ctx),
+	}
+
+	w.logger.Info("Processing delivery", "order_id", w.OrderID)
+
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: activityTimeout,
+	})
+
+	err := workflow.SetQueryHandler(ctx, DeliveryProcessingStatusQuery, func() (DeliveryProcessingState, error) {
+		return w.DeliveryProcessingState, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	startDeliveryChannel := workflow.GetSignalChannel(ctx, channels.SignalNameStartDeliveryChannel)
+	completeDeliveryChannel := workflow.GetSignalChannel(ctx, channels.SignalNameCompleteDeliveryChannel)
+	failDeliveryChannel := workflow.GetSignalChannel(ctx, channels.SignalNameFailDeliveryChannel)
+	changeDeliveryCommentChannel := workflow.GetSignalChannel(ctx, channels.SignalNameChangeDeliveryCommentChannel)
+
+	createInput := &activities.CreateDeliveryApplicationInput{
+		OrderID:               input.OrderID,
+		AssemblyApplicationID: input.AssemblyApplicationID,
+		Packages:              input.Packages,
+	}
+	var output activities.CreateDeliveryApplicationOutput
+	if err := workflow.ExecuteActivity(ctx, a.CreateDeliveryApplication, createInput).Get(ctx, &output); err != nil {
+		w.logger.Error("Error to create delivery application", "error", err, "order_id", w.OrderID)
+		return nil, err
+	}
+
+	w.DeliveryApplicationID = output.DeliveryApplicationID
+	w.CurrentState = OrderStatusTransferredToDelivery
+	w.reportProgress(ctx)
+
+	canceled := false
+	var failure *DeliveryFailure
+	for w.CurrentState != OrderStatusDelivered && !canceled && failure == nil {
+		s := workflow.NewSelector(ctx)
+		// Signal handler for the delivery start: the courier took the order
+		s.AddReceive(startDeliveryChannel, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadStartDelivery
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling start delivery channel", "courier_id", payload.CourierID)
+			if !w.isCurrentApplication(payload.DeliveryApplicationID) {
+				return
+			}
+
+			w.CourierID = payload.CourierID
+			w.CurrentState = OrderStatusDeliveryInProgress
+			w.reportProgress(ctx)
+		})
+		s.AddReceive(changeDeliveryCommentChannel, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadChangeDeliveryComment
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling change delivery comment channel")
+
+			w.Comments = append(w.Comments, DeliveryComment{
+				Comment:   payload.Comment,
+				ChangedAt: workflow.Now(ctx),
+			})
+		})
+		// Signal handler for the delivery complete process
+		s.AddReceive(completeDeliveryChannel, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadCompleteDelivery
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling complete delivery channel")
+			if !w.isCurrentApplication(payload.DeliveryApplicationID) {
+				return
+			}
+
+			w.Delivered = payload.Delivered
+			if len(w.Delivered) == 0 {
+				w.Delivered = w.input.Items
+			}
+			w.DeliveredAt = workflow.Now(ctx)
+			w.CurrentState = OrderStatusDelivered
+		})
+		// Signal handler for the failed delivery: the packages go back to the warehouse
+		s.AddReceive(failDeliveryChannel, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadFailDelivery
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling fail delivery channel", "reason", payload.Reason)
+			if !w.isCurrentApplication(payload.DeliveryApplicationID) {
+				return
+			}
+
+			failure = &DeliveryFailure{
+				DeliveryApplicationID: w.DeliveryApplicationID,
+				CourierID:             payload.CourierID,
+				Reason:                payload.Reason,
+				FailedAt:              workflow.Now(ctx),
+			}
+		})
+		s.AddReceive(ctx.Done(), func(c workflow.ReceiveChannel, more bool) {
+			canceled = true
+		})
+
+		s.Select(ctx)
+	}
+
+	if canceled {
+		w.cancelDelivery(ctx)
+		return nil, ctx.Err()
+	}
+	if failure != nil {
+		w.logger.Info("Delivery failed", "order_id", w.OrderID, "delivery_application_id", w.DeliveryApplicationID,
+			"reason", failure.Reason)
+		return nil, temporal.NewNonRetryableApplicationError("delivery failed: "+failure.Reason, DeliveryFailedErrorType, nil, *failure)
+	}
+
+	w.logger.Info("Order delivered", "order_id", w.OrderID, "delivery_application_id", w.DeliveryApplicationID)
+
+	return &DeliveryProcessingResult{
+		DeliveryApplicationID: w.DeliveryApplicationID,
+		CourierID:             w.CourierID,
+		Delivered:             w.Delivered,
+		DeliveredAt:           w.DeliveredAt,
+	}, nil
+}
+
+// isCurrentApplication reports whether a signal is about the delivery application of this workflow.
+// Every delivery attempt of the order runs under the same workflow ID, so a late event of a failed
+// attempt must not change the current one.
+func (w *deliveryProcessingWorkflow) isCurrentApplication(deliveryApplicationID string) bool {
+	if deliveryApplicationID == "" || deliveryApplicationID == w.DeliveryApplicationID {
+		return true
+	}
+	w.logger.Warn("Signal of another delivery application ignored", "order_id", w.OrderID,
+		"delivery_application_id", deliveryApplicationID, "current_delivery_application_id", w.DeliveryApplicationID)
+	return false
+}
+
+// reportProgress tells the order workflow that the delivery moved on. The parent learns the result
+// from the child workflow itself, so only the intermediate steps are signaled.
+func (w *deliveryProcessingWorkflow) reportProgress(ctx workflow.Context) {
+	parent := workflow.GetInfo(ctx).ParentWorkflowExecution
+	if parent == nil {
+		return
+	}
+
+	payload := signals.SignalPayloadDeliveryProgress{
+		Route:                 routes.RouteTypeDeliveryProgress,
+		DeliveryApplicationID: w.DeliveryApplicationID,
+		CourierID:             w.CourierID,
+	}
+	err := workflow.SignalExternalWorkflow(ctx, parent.ID, "", channels.SignalNameDeliveryProgressChannel, payload).Get(ctx, nil)
+	if err != nil {
+		w.logger.Warn("Error to report delivery progress", "error", err, "order_id", w.OrderID)
+	}
+}
+
+// cancelDelivery withdraws the canceled order from delivery. The workflow context is already canceled,
+// so the activity runs in a disconnected one.
+func (w *deliveryProcessingWorkflow) cancelDelivery(ctx workflow.Context) {
+	w.logger.Info("Delivery canceled", "order_id", w.OrderID, "delivery_application_id", w.DeliveryApplicationID)
+
+	ctx, _ = workflow.NewDisconnectedContext(ctx)
+	input := &activities.CancelDeliveryApplicationInput{
+		DeliveryApplicationID: w.DeliveryApplicationID,
+		Reason:                "order canceled",
+	}
+	if err := workflow.ExecuteActivity(ctx, a.CancelDeliveryApplication, input).Get(ctx, nil); err != nil {
+		w.logger.Error("Error to cancel delivery application", "error", err, "order_id", w.OrderID)
+	}
+
+	w.CurrentState = OrderStatusCanceled
+}
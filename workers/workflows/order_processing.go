@@ -4,8 +4,14 @@
 package workflows
 
 import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
 	"github.com/milovidov983/oms-temporal-demo/shared/models"
 	"github.com/milovidov983/oms-temporal-demo/workers/activities"
+	"github.com/milovidov983/oms-temporal-demo/workers/signals"
 	"github.com/milovidov983/oms-temporal-demo/workers/signals/channels"
 	"go.temporal.io/sdk/log"
 	"go.temporal.io/sdk/temporal"
@@ -14,6 +20,24 @@ import (
 
 const (
 	OrderProcessingStatusQuery = "order-processing-status"
+
+	// activityTimeout limits a single attempt of an activity, failed attempts are retried by Temporal
+	activityTimeout = time.Minute
+
+	// substitutionApprovalTimeout is how long the customer has to answer a proposed substitution,
+	// after that the substitution is rejected and the picker completes the assembly without it
+	substitutionApprovalTimeout = 15 * time.Minute
+
+	// maxAssemblyAttempts is how many times the order is passed to assembly before
+	// a cancellation by the warehouse cancels the order
+	maxAssemblyAttempts = 3
+
+	// maxDeliveryAttempts is how many failed deliveries cancel the order
+	maxDeliveryAttempts = 3
+
+	// deliveryLeadTime is how long before the start of the delivery slot the order is passed to delivery,
+	// so a courier has time to pick it up
+	deliveryLeadTime = 2 * time.Hour
 )
 
 type OrderProcessingWorkflowInput struct {
@@ -21,8 +45,90 @@ type OrderProcessingWorkflowInput struct {
 }
 
 type OrderProcessingState struct {
-	OrderID      string
-	CurrentState OrderProcessingStatus
+	OrderID               string
+	CurrentState          OrderProcessingStatus
+	OrderTypes            []models.OrderType
+	AssemblyApplicationID string
+	// AssemblyDueAt is the SLA deadline of the current assembly, AssemblyOverdue is set
+	// when the assembly was not done by then and was escalated
+	AssemblyDueAt   time.Time
+	AssemblyOverdue bool
+	// Collected and Shortages are the lines reported when the assembly was completed
+	Collected []models.OrderItem
+	Shortages []models.OrderItem
+	// Packages are the parcels of the assembled order, their labels are rendered by oms-core
+	// at GET /api/assembly/{id}/label
+	Packages []models.Package
+	// RefundAmount is the cost of the lines that were not collected and must be returned to the customer
+	RefundAmount models.Money
+	// AssemblyComments is the history of assembly comments, the last one is the current comment
+	AssemblyComments []AssemblyComment
+	// Substitutions are the substitutes proposed by the picker with the decision of the customer
+	Substitutions []SubstitutionDecision
+	// AssemblyCancellations are the assemblies canceled by warehouses, the order is routed
+	// to another warehouse until there are maxAssemblyAttempts of them
+	AssemblyCancellations []AssemblyCancellation
+	// AssemblyRejections are the assemblies that failed the quality check, each one is followed
+	// by the next attempt in the same warehouse
+	AssemblyRejections []AssemblyRejection
+	// Items and TotalAmount are the lines and the total of the order after its last amendment
+	Items       []models.OrderItem
+	TotalAmount models.Money
+	// PaymentID is the payment authorized for AuthorizedAmount when the order was created and raised
+	// when its total grew, CapturedAmount is what was charged for the collected lines
+	PaymentID        string
+	AuthorizedAmount models.Money
+	CapturedAmount   models.Money
+	// DeliverySlot is the delivery window chosen at checkout, the order is passed to delivery
+	// at DeliveryScheduledAt, deliveryLeadTime before the slot starts
+	DeliverySlot        *models.DeliverySlot
+	DeliveryScheduledAt time.Time
+	// DeliveryApplicationID and CourierID are reported by the ProcessDelivery child workflow,
+	// Delivered and DeliveredAt are its result
+	DeliveryApplicationID string
+	CourierID             string
+	Delivered             []models.OrderItem
+	DeliveredAt           time.Time
+	// DeliveryFailures are the deliveries the courier could not make, each one is followed by the next
+	// attempt until there are maxDeliveryAttempts of them
+	DeliveryFailures []DeliveryFailure
+	// CancellationReason is why the order was canceled by the customer, Compensations are the steps
+	// undone after the cancellation in the order they ran
+	CancellationReason string
+	Compensations      []CompensationResult
+}
+
+type CompensationResult struct {
+	Step string
+	// Error is set when the compensation failed, the rest of the compensations still run
+	Error         string
+	CompensatedAt time.Time
+}
+
+type AssemblyRejection struct {
+	AssemblyApplicationID     string
+	NextAssemblyApplicationID string
+	Reason                    string
+	RejectedAt                time.Time
+}
+
+type AssemblyCancellation struct {
+	AssemblyApplicationID string
+	WarehouseID           string
+	Reason                string
+	CanceledAt            time.Time
+}
+
+type SubstitutionDecision struct {
+	models.Substitution
+	// Expired is set when the customer did not answer in time and the workflow rejected the substitution
+	Expired bool
+}
+
+type AssemblyComment struct {
+	Comment   string
+	Author    string
+	ChangedAt time.Time
 }
 
 type OrderProperties struct {
@@ -35,6 +141,29 @@ type orderProcessingWorkflow struct {
 	OrderProcessingState
 	processingID string
 	logger       log.Logger
+	// stopAssemblySLA cancels the SLA timer of the current assembly
+	stopAssemblySLA workflow.CancelFunc
+	// compensations undo the steps already done for the order, the last step first
+	compensations []compensation
+	// delivery is the running ProcessDelivery child workflow, stopDelivery cancels it
+	delivery     workflow.ChildWorkflowFuture
+	stopDelivery workflow.CancelFunc
+}
+
+// Steps of the processing that have to be undone when the order is canceled.
+const (
+	// compensationStepAssembly cancels the current assembly application, which releases its stock
+	compensationStepAssembly = "cancel_assembly_application"
+	// compensationStepDelivery cancels the delivery child workflow, which cancels the delivery application
+	compensationStepDelivery = "cancel_delivery"
+	// compensationStepPayment voids the authorization of the payment or refunds the captured amount
+	compensationStepPayment = "cancel_payment"
+)
+
+// compensation undoes a step of the processing. A step done again replaces its compensation.
+type compensation struct {
+	step string
+	run  func(ctx workflow.Context, reason string) error
 }
 
 // newOrderProcessingWorkflow initializes a orderProcessingWorkflow struct
@@ -69,6 +198,10 @@ func ProcessOrder(ctx workflow.Context, input *OrderProcessingWorkflowInput) err
 
 	w.logger.Info("Processing order", "order_id", w.OrderID)
 
+	ctx = workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: activityTimeout,
+	})
+
 	w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
 
 	err := workflow.SetQueryHandler(ctx, OrderProcessingStatusQuery, func() (OrderProcessingState, error) {
@@ -80,12 +213,32 @@ func ProcessOrder(ctx workflow.Context, input *OrderProcessingWorkflowInput) err
 
 	// Channels
 	startOrderProcessingChannel := workflow.GetSignalChannel(ctx, channels.SignalNameStartOrderProcessingChannel)
-	// startAssemblyChannel := workflow.GetSignalChannel(ctx, channels.SignalNameStartAssemblyChannel)
+	startAssemblyChannel := workflow.GetSignalChannel(ctx, channels.SignalNameStartAssemblyChannel)
 	completeAssemblyChannel := workflow.GetSignalChannel(ctx, channels.SignalNameCompleteAssemblyChannel)
-	// changeAssemblyCommentChannel := workflow.GetSignalChannel(ctx, channels.SignalNameChangeAssemblyCommentChannel)
+	cancelAssemblyChannel := workflow.GetSignalChannel(ctx, channels.SignalNameCancelAssemblyChannel)
+	rejectAssemblyChannel := workflow.GetSignalChannel(ctx, channels.SignalNameRejectAssemblyChannel)
+	changeAssemblyCommentChannel := workflow.GetSignalChannel(ctx, channels.SignalNameChangeAssemblyCommentChannel)
+	proposeSubstitutionChannel := workflow.GetSignalChannel(ctx, channels.SignalNameProposeSubstitutionChannel)
+	decideSubstitutionChannel := workflow.GetSignalChannel(ctx, channels.SignalNameDecideSubstitutionChannel)
 	// completeDeliveryChannel := workflow.GetSignalChannel(ctx, channels.SignalNameCompleteDeliveryChannel)
 	// changeDeliveryCommentChannel := workflow.GetSignalChannel(ctx, channels.SignalNameChangeDeliveryCommentChannel)
-	// cancelOrderChannel := workflow.GetSignalChannel(ctx, channels.SignalNameCancelOrderChannel)
+	cancelOrderChannel := workflow.GetSignalChannel(ctx, channels.SignalNameCancelOrderChannel)
+	deliveryProgressChannel := workflow.GetSignalChannel(ctx, channels.SignalNameDeliveryProgressChannel)
+	amendOrderChannel := workflow.GetSignalChannel(ctx, channels.SignalNameAmendOrderChannel)
+
+	// Комментарии не меняют статус обработки, поэтому читаем их отдельно от основного цикла
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		for {
+			var payload signals.SignalPayloadChangeAssemblyComment
+			changeAssemblyCommentChannel.Receive(ctx, &payload)
+			w.addAssemblyComment(ctx, payload)
+		}
+	})
+
+	// Замены ждут решения покупателя параллельно со сборкой, oms-core не даст завершить сборку до решения
+	workflow.Go(ctx, func(ctx workflow.Context) {
+		w.awaitSubstitutionDecisions(ctx, proposeSubstitutionChannel, decideSubstitutionChannel)
+	})
 
 	// Идем в OMS Core и понимаем какой тип заказа перед нами, какие у него свойства и состав
 	// и прочие значимые для принятия решения характеристики
@@ -107,12 +260,49 @@ func ProcessOrder(ctx workflow.Context, input *OrderProcessingWorkflowInput) err
 		//
 		//
 
+		// Signal handler for the assembly start: the warehouse accepted the application
+		s.AddReceive(startAssemblyChannel, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadStartAssembly
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling start assembly channel", "assembly_application_id", payload.AssemblyApplicationID)
+
+			w.OrderProcessingState.AssemblyApplicationID = payload.AssemblyApplicationID
+			w.OrderProcessingState.CurrentState = OrderStatusAssemblyInProgress
+			w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+		})
+		// Signal handler for the assembly cancellation by the warehouse
+		s.AddReceive(cancelAssemblyChannel, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadCancelAssembly
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling cancel assembly channel", "assembly_application_id", payload.AssemblyApplicationID,
+				"reason", payload.Reason)
+
+			w.stopAssemblySLATimer()
+			// Склад уже отменил заявку, отменять ее при отмене заказа не нужно
+			w.dropCompensation(compensationStepAssembly)
+			w.OrderProcessingState.AssemblyCancellations = append(w.OrderProcessingState.AssemblyCancellations, AssemblyCancellation{
+				AssemblyApplicationID: payload.AssemblyApplicationID,
+				WarehouseID:           payload.WarehouseID,
+				Reason:                payload.Reason,
+				CanceledAt:            workflow.Now(ctx),
+			})
+			w.OrderProcessingState.CurrentState = OrderStatusAssemblyCanceled
+			w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+		})
 		// Signal handler for the assembly complete process
 		s.AddReceive(completeAssemblyChannel, func(c workflow.ReceiveChannel, more bool) {
-			c.Receive(ctx, nil)
+			var payload signals.SignalPayloadCompleteAssembly
+			c.Receive(ctx, &payload)
 
 			w.logger.Debug("Handling complete assembly channel")
 
+			w.stopAssemblySLATimer()
+			w.OrderProcessingState.Collected = payload.Collected
+			w.OrderProcessingState.Shortages = payload.Shortages
+			w.OrderProcessingState.Packages = payload.Packages
+
 			w.OrderProcessingState.CurrentState = OrderStatusAssembled
 			w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
 		})
@@ -124,6 +314,39 @@ func ProcessOrder(ctx workflow.Context, input *OrderProcessingWorkflowInput) err
 			w.OrderProcessingState.CurrentState = OrderStatusCreated
 			w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
 		})
+		// Signal handler for the delivery progress reported by the ProcessDelivery child workflow
+		s.AddReceive(deliveryProgressChannel, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadDeliveryProgress
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling delivery progress channel", "delivery_application_id", payload.DeliveryApplicationID,
+				"courier_id", payload.CourierID)
+
+			w.OrderProcessingState.DeliveryApplicationID = payload.DeliveryApplicationID
+			if payload.CourierID != "" {
+				w.OrderProcessingState.CourierID = payload.CourierID
+				w.OrderProcessingState.CurrentState = OrderStatusDeliveryInProgress
+				w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+			}
+		})
+		if w.delivery != nil {
+			s.AddFuture(w.delivery, func(f workflow.Future) {
+				err = w.handleDeliveryResult(ctx, f)
+			})
+		}
+		// Signal handler for the order amendment: the lines of an order not picked yet were changed
+		amended := false
+		s.AddReceive(amendOrderChannel, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadAmendOrder
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling amend order channel", "total_amount", payload.TotalAmount.String())
+
+			amended = true
+			err = w.amendOrder(ctx, payload)
+		})
+		// Signal handler for the order cancellation by the customer
+		w.addCancelOrderReceive(ctx, s, cancelOrderChannel)
 
 		s.Select(ctx)
 
@@ -131,25 +354,36 @@ func ProcessOrder(ctx workflow.Context, input *OrderProcessingWorkflowInput) err
 
 		switch w.OrderProcessingState.CurrentState {
 		case OrderStatusCreated: // Сборка
-			err = w.handleNewOrder(ctx)
+			// Изменение состава заказа не запускает его обработку заново
+			if !amended {
+				err = w.handleNewOrder(ctx)
+			}
 		case OrderStatusAssembled:
-			err = w.handleAssembledOrder(ctx)
+			err = w.handleAssembledOrder(ctx, rejectAssemblyChannel, cancelOrderChannel)
 
-			// debug code
-			w.OrderProcessingState.CurrentState = OrderStatusProcessingCompleted
+			// Заказ без доставки после сборки обработан
+			if w.OrderProcessingState.CurrentState == OrderStatusAssembled {
+				w.OrderProcessingState.CurrentState = OrderStatusProcessingCompleted
+			}
+		case OrderStatusAssemblyCanceled:
+			err = w.handleCanceledAssembly(ctx)
 		}
 
+		// Ошибка не завершает обработку успешно: workflow падает, а заказ остается в текущем статусе
 		if err != nil {
-			w.logger.Error("Error to handle order", "error", err, "order_id", w.OrderID)
-			break
+			w.logger.Error("Error to handle order", "error", err, "order_id", w.OrderID,
+				"current_state", w.OrderProcessingState.CurrentState)
+			return err
 		}
 		if w.OrderProcessingState.CurrentState.IsFinalStatus() {
 			break
 		}
 	}
 
-	w.OrderProcessingState.CurrentState = OrderStatusProcessingCompleted
-	w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+	if w.OrderProcessingState.CurrentState != OrderStatusCanceled {
+		w.OrderProcessingState.CurrentState = OrderStatusProcessingCompleted
+		w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+	}
 
 	return nil
 }
@@ -161,7 +395,7 @@ func (w *orderProcessingWorkflow) handleNewOrder(ctx workflow.Context) error {
 		OrderID: w.OrderID,
 	}
 	var output []models.OrderType
-	err := workflow.ExecuteActivity(ctx, a.GetOrderTypes, input).Get(ctx, output)
+	err := workflow.ExecuteActivity(ctx, a.GetOrderTypes, input).Get(ctx, &output)
 	if err != nil {
 		w.logger.Error("Error to get order type", "error", err, "order_id", w.OrderID)
 	}
@@ -169,31 +403,809 @@ func (w *orderProcessingWorkflow) handleNewOrder(ctx workflow.Context) error {
 	if len(output) == 0 {
 		return nil
 	}
+	w.OrderProcessingState.OrderTypes = output
 
-	isNeedToAssembly := false
-	for _, orderType := range output {
-		if orderType == models.OrderTypeAssembly {
-			isNeedToAssembly = true
-			break
+	if w.hasOrderType(models.OrderTypeDelivery) {
+		var slot *models.DeliverySlot
+		if err = workflow.ExecuteActivity(ctx, a.GetDeliverySlot, input).Get(ctx, &slot); err != nil {
+			w.logger.Error("Error to get delivery slot", "error", err, "order_id", w.OrderID)
 		}
+		w.OrderProcessingState.DeliverySlot = slot
 	}
 
-	if isNeedToAssembly {
-		err = workflow.ExecuteActivity(ctx, a.CreateAssemblyApplication, input).Get(ctx, nil)
+	if w.hasOrderType(models.OrderTypePayment) {
+		if err = w.authorizePayment(ctx); err != nil {
+			return err
+		}
+		if w.OrderProcessingState.CurrentState == OrderStatusCanceled {
+			return nil
+		}
+	}
+
+	if w.hasOrderType(models.OrderTypeAssembly) {
+		err = w.passToAssembly(ctx)
+		var appErr *temporal.ApplicationError
+		if errors.As(err, &appErr) && appErr.Type() == activities.AssemblyNotCreatedErrorType {
+			return w.cancelUnassembledOrder(ctx, appErr.Error())
+		}
 		if err != nil {
 			w.logger.Error("Error to start assembly", "error", err, "order_id", w.OrderID)
+			return err
 		}
-		w.OrderProcessingState.CurrentState = OrderStatusTransferredToAssembly
-		w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
-		return nil
 	}
 
 	return nil
 }
-func (w *orderProcessingWorkflow) handleAssembledOrder(ctx workflow.Context) error {
+
+// cancelUnassembledOrder cancels the order oms-core refused to pass to assembly. The authorization
+// of the payment is voided by the compensation.
+func (w *orderProcessingWorkflow) cancelUnassembledOrder(ctx workflow.Context, cause string) error {
+	w.logger.Warn("Order cannot be passed to assembly", "order_id", w.OrderID, "cause", cause)
+
+	reason := "order cannot be passed to assembly"
+	input := &activities.CancelOrderInput{
+		OrderID: w.OrderID,
+		Reason:  reason,
+	}
+	if err := workflow.ExecuteActivity(ctx, a.CancelOrder, input).Get(ctx, nil); err != nil {
+		w.logger.Error("Error to cancel order", "error", err, "order_id", w.OrderID)
+		return err
+	}
+
+	w.cancelOrder(ctx, reason)
+	return nil
+}
+
+func (w *orderProcessingWorkflow) hasOrderType(orderType models.OrderType) bool {
+	for _, t := range w.OrderProcessingState.OrderTypes {
+		if t == orderType {
+			return true
+		}
+	}
+	return false
+}
+
+// passToAssembly creates the assembly application in oms-core, moves the processing
+// to OrderStatusTransferredToAssembly and starts the SLA timer of the assembly.
+func (w *orderProcessingWorkflow) passToAssembly(ctx workflow.Context) error {
+	// SLA сборки зависит от того, забирает ли покупатель заказ сам или ждет доставку
+	orderType := models.OrderTypeAssembly
+	if w.hasOrderType(models.OrderTypeDelivery) {
+		orderType = models.OrderTypeDelivery
+	}
+
+	input := &activities.CreateAssemblyApplicationInput{
+		OrderID:   w.OrderID,
+		OrderType: orderType,
+	}
+	var output activities.CreateAssemblyApplicationOutput
+	if err := workflow.ExecuteActivity(ctx, a.CreateAssemblyApplication, input).Get(ctx, &output); err != nil {
+		return err
+	}
+
+	w.OrderProcessingState.AssemblyApplicationID = output.AssemblyApplicationID
+	w.OrderProcessingState.CurrentState = OrderStatusTransferredToAssembly
+	w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+
+	w.addAssemblyCompensation(output.AssemblyApplicationID)
+	w.startAssemblySLATimer(ctx, output.AssemblyApplicationID, output.DueAt)
+	return nil
+}
+
+// startAssemblySLATimer starts a durable timer until dueAt. If the assembly is neither completed
+// nor canceled by then, it is escalated as overdue.
+func (w *orderProcessingWorkflow) startAssemblySLATimer(ctx workflow.Context, assemblyApplicationID string, dueAt time.Time) {
+	w.stopAssemblySLATimer()
+	if dueAt.IsZero() {
+		w.logger.Warn("Assembly has no SLA deadline", "order_id", w.OrderID, "assembly_application_id", assemblyApplicationID)
+		return
+	}
+
+	w.OrderProcessingState.AssemblyDueAt = dueAt
+	w.OrderProcessingState.AssemblyOverdue = false
+
+	timerCtx, cancel := workflow.WithCancel(ctx)
+	w.stopAssemblySLA = cancel
+
+	workflow.Go(timerCtx, func(ctx workflow.Context) {
+		timeout := dueAt.Sub(workflow.Now(ctx))
+		if timeout < 0 {
+			timeout = 0
+		}
+		// Ошибка таймера означает его отмену: сборка завершилась или отменена вовремя
+		if err := workflow.NewTimer(ctx, timeout).Get(ctx, nil); err != nil {
+			return
+		}
+		w.escalateOverdueAssembly(ctx, assemblyApplicationID)
+	})
+}
+
+func (w *orderProcessingWorkflow) stopAssemblySLATimer() {
+	if w.stopAssemblySLA != nil {
+		w.stopAssemblySLA()
+		w.stopAssemblySLA = nil
+	}
+}
+
+// escalateOverdueAssembly marks the assembly and the order overdue in oms-core, which publishes AssemblyOverdue.
+func (w *orderProcessingWorkflow) escalateOverdueAssembly(ctx workflow.Context, assemblyApplicationID string) {
+	w.logger.Warn("Assembly is overdue", "order_id", w.OrderID, "assembly_application_id", assemblyApplicationID,
+		"due_at", w.OrderProcessingState.AssemblyDueAt)
+
+	input := &activities.AssemblyApplicationInput{
+		AssemblyApplicationID: assemblyApplicationID,
+	}
+	err := workflow.ExecuteActivity(ctx, a.MarkAssemblyOverdue, input).Get(ctx, nil)
+	if err != nil {
+		w.logger.Error("Error to mark assembly overdue", "error", err, "order_id", w.OrderID)
+		return
+	}
+
+	w.OrderProcessingState.AssemblyOverdue = true
+}
+func (w *orderProcessingWorkflow) handleAssembledOrder(
+	ctx workflow.Context,
+	rejectAssemblyChannel workflow.ReceiveChannel,
+	cancelOrderChannel workflow.ReceiveChannel,
+) error {
 	w.logger.Debug("Handle assembled order", "order_id", w.OrderID)
 	// заказ собран если надо передаем на доставку отправляем нотификации и делаем остальные
 	// действия согласно бизнес процессу
 
+	w.handleShortages()
+
+	// Пока заказ ждет окна доставки, проверка качества еще может отклонить сборку
+	deliver := w.hasOrderType(models.OrderTypeDelivery) && len(w.OrderProcessingState.Collected) > 0
+	if deliver && !w.awaitDeliverySlot(ctx, rejectAssemblyChannel, cancelOrderChannel) {
+		return nil
+	}
+
+	accepted, err := w.acceptAssembly(ctx, rejectAssemblyChannel, cancelOrderChannel)
+	if err != nil || !accepted {
+		return err
+	}
+
+	if err := w.capturePayment(ctx); err != nil {
+		return err
+	}
+	if w.OrderProcessingState.CurrentState == OrderStatusCanceled {
+		return nil
+	}
+
+	if !w.hasOrderType(models.OrderTypeDelivery) {
+		return nil
+	}
+	if !deliver {
+		w.logger.Warn("Nothing to deliver", "order_id", w.OrderID)
+		return nil
+	}
+
+	return w.passToDelivery(ctx)
+}
+
+// awaitDeliverySlot waits with a durable timer until deliveryLeadTime before the delivery slot of the order
+// and returns true when it is time to pass the order to delivery. An order without a slot, or assembled
+// too late for it, is passed at once. The order can be canceled by the customer or its assembly rejected
+// by the quality check while it waits.
+func (w *orderProcessingWorkflow) awaitDeliverySlot(
+	ctx workflow.Context,
+	rejectAssemblyChannel workflow.ReceiveChannel,
+	cancelOrderChannel workflow.ReceiveChannel,
+) bool {
+	slot := w.OrderProcessingState.DeliverySlot
+	if slot == nil {
+		return true
+	}
+
+	w.OrderProcessingState.DeliveryScheduledAt = slot.StartsAt.Add(-deliveryLeadTime)
+	wait := w.OrderProcessingState.DeliveryScheduledAt.Sub(workflow.Now(ctx))
+	if wait <= 0 {
+		return true
+	}
+
+	w.logger.Info("Waiting for delivery slot", "order_id", w.OrderID, "slot_id", slot.ID,
+		"scheduled_at", w.OrderProcessingState.DeliveryScheduledAt)
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	scheduled := false
+	s := workflow.NewSelector(ctx)
+	w.addRejectAssemblyReceive(ctx, s, rejectAssemblyChannel)
+	w.addCancelOrderReceive(ctx, s, cancelOrderChannel)
+	s.AddFuture(workflow.NewTimer(timerCtx, wait), func(f workflow.Future) {
+		scheduled = true
+	})
+	s.Select(ctx)
+
+	return scheduled
+}
+
+// passToDelivery starts the ProcessDelivery child workflow and moves the processing to
+// OrderStatusTransferredToDelivery. The main loop waits for the result of the child.
+func (w *orderProcessingWorkflow) passToDelivery(ctx workflow.Context) error {
+	childCtx, cancel := workflow.WithCancel(ctx)
+	childCtx = workflow.WithChildOptions(childCtx, workflow.ChildWorkflowOptions{
+		WorkflowID: DeliveryProcessingWorkflowID(w.OrderID),
+	})
+
+	input := &DeliveryProcessingWorkflowInput{
+		OrderID:               w.OrderID,
+		AssemblyApplicationID: w.OrderProcessingState.AssemblyApplicationID,
+		Items:                 w.OrderProcessingState.Collected,
+		Packages:              w.OrderProcessingState.Packages,
+	}
+	delivery := workflow.ExecuteChildWorkflow(childCtx, ProcessDelivery, input)
+	if err := delivery.GetChildWorkflowExecution().Get(ctx, nil); err != nil {
+		cancel()
+		w.logger.Error("Error to start delivery", "error", err, "order_id", w.OrderID)
+		return err
+	}
+
+	w.delivery = delivery
+	w.stopDelivery = cancel
+	w.OrderProcessingState.CurrentState = OrderStatusTransferredToDelivery
+	w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+
+	w.addCompensation(compensationStepDelivery, func(ctx workflow.Context, reason string) error {
+		w.stopDelivery()
+		err := w.delivery.Get(ctx, nil)
+		w.delivery = nil
+		if temporal.IsCanceledError(err) {
+			return nil
+		}
+		return err
+	})
+	return nil
+}
+
+// handleDeliveryResult finishes the processing with the result of the ProcessDelivery child workflow.
+// A failed delivery is attempted again.
+func (w *orderProcessingWorkflow) handleDeliveryResult(ctx workflow.Context, f workflow.Future) error {
+	w.delivery = nil
+	w.dropCompensation(compensationStepDelivery)
+
+	var result DeliveryProcessingResult
+	err := f.Get(ctx, &result)
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.Type() == DeliveryFailedErrorType {
+		var failure DeliveryFailure
+		if err := appErr.Details(&failure); err != nil {
+			w.logger.Warn("Delivery failure without details", "error", err, "order_id", w.OrderID)
+		}
+		return w.handleFailedDelivery(ctx, failure)
+	}
+	if err != nil {
+		w.logger.Error("Error to deliver order", "error", err, "order_id", w.OrderID)
+		return err
+	}
+
+	w.logger.Info("Order delivered", "order_id", w.OrderID, "delivery_application_id", result.DeliveryApplicationID)
+
+	w.OrderProcessingState.DeliveryApplicationID = result.DeliveryApplicationID
+	w.OrderProcessingState.CourierID = result.CourierID
+	w.OrderProcessingState.Delivered = result.Delivered
+	w.OrderProcessingState.DeliveredAt = result.DeliveredAt
+	w.OrderProcessingState.CurrentState = OrderStatusDelivered
+	w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+
+	return nil
+}
+
+// handleFailedDelivery decides what to do after the courier could not deliver the order. oms-core has
+// returned the order to ASSEMBLED, so it is passed to delivery again. After maxDeliveryAttempts failures
+// the order is canceled and the assembly is compensated, which returns the collected items to the stock.
+func (w *orderProcessingWorkflow) handleFailedDelivery(ctx workflow.Context, failure DeliveryFailure) error {
+	w.OrderProcessingState.DeliveryFailures = append(w.OrderProcessingState.DeliveryFailures, failure)
+	w.OrderProcessingState.CourierID = ""
+
+	failures := len(w.OrderProcessingState.DeliveryFailures)
+	w.logger.Warn("Delivery failed", "order_id", w.OrderID, "delivery_application_id", failure.DeliveryApplicationID,
+		"reason", failure.Reason, "failures", failures)
+
+	if failures < maxDeliveryAttempts {
+		return w.passToDelivery(ctx)
+	}
+
+	reason := "delivery failed " + strconv.Itoa(failures) + " times"
+	if failure.Reason != "" {
+		reason += ": " + failure.Reason
+	}
+	input := &activities.CancelOrderInput{
+		OrderID: w.OrderID,
+		Reason:  reason,
+	}
+	if err := workflow.ExecuteActivity(ctx, a.CancelOrder, input).Get(ctx, nil); err != nil {
+		w.logger.Error("Error to cancel order", "error", err, "order_id", w.OrderID)
+		return err
+	}
+
+	w.cancelOrder(ctx, reason)
+	return nil
+}
+
+// acceptAssembly moves the order past the assembly in oms-core, the quality check cannot reject it after that.
+// When the assembly was rejected or the order canceled first, oms-core refuses and the workflow waits for
+// the signal of that event instead; it returns false then.
+func (w *orderProcessingWorkflow) acceptAssembly(
+	ctx workflow.Context,
+	rejectAssemblyChannel workflow.ReceiveChannel,
+	cancelOrderChannel workflow.ReceiveChannel,
+) (bool, error) {
+	input := &activities.AssemblyApplicationInput{
+		AssemblyApplicationID: w.OrderProcessingState.AssemblyApplicationID,
+	}
+	err := workflow.ExecuteActivity(ctx, a.AcceptAssembly, input).Get(ctx, nil)
+	var appErr *temporal.ApplicationError
+	if errors.As(err, &appErr) && appErr.Type() == activities.AssemblyNotAcceptedErrorType {
+		w.logger.Info("Assembly was not accepted, waiting for its rejection or the order cancellation",
+			"order_id", w.OrderID, "assembly_application_id", input.AssemblyApplicationID)
+
+		s := workflow.NewSelector(ctx)
+		w.addRejectAssemblyReceive(ctx, s, rejectAssemblyChannel)
+		w.addCancelOrderReceive(ctx, s, cancelOrderChannel)
+		s.Select(ctx)
+		return false, nil
+	}
+	if err != nil {
+		w.logger.Error("Error to accept assembly", "error", err, "order_id", w.OrderID)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// addRejectAssemblyReceive adds the rejection of the assembly by the quality check to the selector.
+func (w *orderProcessingWorkflow) addRejectAssemblyReceive(ctx workflow.Context, s workflow.Selector, rejectAssemblyChannel workflow.ReceiveChannel) {
+	s.AddReceive(rejectAssemblyChannel, func(c workflow.ReceiveChannel, more bool) {
+		var payload signals.SignalPayloadRejectAssembly
+		c.Receive(ctx, &payload)
+
+		w.logger.Debug("Handling reject assembly channel", "assembly_application_id", payload.AssemblyApplicationID,
+			"reason", payload.Reason)
+
+		w.reassemble(ctx, payload)
+	})
+}
+
+// reassemble records the rejected assembly and continues with the next attempt as with a new assembly:
+// the result of the rejected one is dropped and the SLA timer starts again.
+func (w *orderProcessingWorkflow) reassemble(ctx workflow.Context, payload signals.SignalPayloadRejectAssembly) {
+	w.logger.Info("Assembly failed the quality check", "order_id", w.OrderID,
+		"assembly_application_id", payload.AssemblyApplicationID, "next_assembly_application_id", payload.NextAssemblyApplicationID)
+
+	w.OrderProcessingState.AssemblyRejections = append(w.OrderProcessingState.AssemblyRejections, AssemblyRejection{
+		AssemblyApplicationID:     payload.AssemblyApplicationID,
+		NextAssemblyApplicationID: payload.NextAssemblyApplicationID,
+		Reason:                    payload.Reason,
+		RejectedAt:                workflow.Now(ctx),
+	})
+
+	w.OrderProcessingState.Collected = nil
+	w.OrderProcessingState.Shortages = nil
+	w.OrderProcessingState.Packages = nil
+	w.OrderProcessingState.AssemblyApplicationID = payload.NextAssemblyApplicationID
+	w.OrderProcessingState.CurrentState = OrderStatusTransferredToAssembly
+	w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+
+	// Отклоненная заявка закрыта, при отмене заказа отменяется следующая попытка
+	w.addAssemblyCompensation(payload.NextAssemblyApplicationID)
+	w.startAssemblySLATimer(ctx, payload.NextAssemblyApplicationID, payload.NextDueAt)
+}
+
+// addAssemblyComment appends the comment to the history available through the status query.
+func (w *orderProcessingWorkflow) addAssemblyComment(ctx workflow.Context, payload signals.SignalPayloadChangeAssemblyComment) {
+	w.logger.Debug("Handling change assembly comment channel", "assembly_application_id", payload.AssemblyApplicationID)
+
+	w.OrderProcessingState.AssemblyComments = append(w.OrderProcessingState.AssemblyComments, AssemblyComment{
+		Comment:   payload.Comment,
+		Author:    payload.Author,
+		ChangedAt: workflow.Now(ctx),
+	})
+}
+
+// pendingSubstitution is a substitution waiting for the customer with the timer of its approval timeout.
+type pendingSubstitution struct {
+	assemblyApplicationID string
+	substitutionID        string
+	timeout               workflow.Future
+	cancelTimeout         workflow.CancelFunc
+}
+
+// awaitSubstitutionDecisions tracks proposed substitutions until the customer approves or rejects them.
+// A substitution without an answer in substitutionApprovalTimeout is rejected.
+func (w *orderProcessingWorkflow) awaitSubstitutionDecisions(ctx workflow.Context, proposed, decided workflow.ReceiveChannel) {
+	// Срез, а не map: порядок обработки таймеров должен быть детерминированным
+	var pending []pendingSubstitution
+
+	removePending := func(substitutionID string) {
+		for i, p := range pending {
+			if p.substitutionID == substitutionID {
+				p.cancelTimeout()
+				pending = append(pending[:i], pending[i+1:]...)
+				return
+			}
+		}
+	}
+
+	for {
+		s := workflow.NewSelector(ctx)
+		s.AddReceive(proposed, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadProposeSubstitution
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling propose substitution channel", "substitution_id", payload.Substitution.ID)
+
+			w.setSubstitution(payload.Substitution)
+
+			timerCtx, cancel := workflow.WithCancel(ctx)
+			pending = append(pending, pendingSubstitution{
+				assemblyApplicationID: payload.AssemblyApplicationID,
+				substitutionID:        payload.Substitution.ID,
+				timeout:               workflow.NewTimer(timerCtx, substitutionApprovalTimeout),
+				cancelTimeout:         cancel,
+			})
+		})
+		s.AddReceive(decided, func(c workflow.ReceiveChannel, more bool) {
+			var payload signals.SignalPayloadDecideSubstitution
+			c.Receive(ctx, &payload)
+
+			w.logger.Debug("Handling decide substitution channel", "substitution_id", payload.Substitution.ID,
+				"status", payload.Substitution.Status)
+
+			w.setSubstitution(payload.Substitution)
+			removePending(payload.Substitution.ID)
+
+			// Одобренная замена может стоить дороже: удержание увеличивается сразу, а не при списании
+			if payload.Substitution.Status == models.SubstitutionStatusApproved {
+				if err := w.reauthorizePayment(ctx); err != nil {
+					w.logger.Warn("Authorization not raised for substitution, it is raised again before the capture",
+						"error", err, "order_id", w.OrderID, "substitution_id", payload.Substitution.ID)
+				}
+			}
+		})
+		for _, p := range pending {
+			p := p
+			s.AddFuture(p.timeout, func(f workflow.Future) {
+				removePending(p.substitutionID)
+				w.expireSubstitution(ctx, p.assemblyApplicationID, p.substitutionID)
+			})
+		}
+
+		s.Select(ctx)
+	}
+}
+
+// setSubstitution records the current state of the substitution, the Expired mark is kept.
+func (w *orderProcessingWorkflow) setSubstitution(substitution models.Substitution) {
+	for i := range w.OrderProcessingState.Substitutions {
+		if w.OrderProcessingState.Substitutions[i].ID == substitution.ID {
+			w.OrderProcessingState.Substitutions[i].Substitution = substitution
+			return
+		}
+	}
+	w.OrderProcessingState.Substitutions = append(w.OrderProcessingState.Substitutions, SubstitutionDecision{Substitution: substitution})
+}
+
+// expireSubstitution rejects the substitution the customer did not answer in time.
+// The rejection comes back as a decide signal like a decision of the customer.
+func (w *orderProcessingWorkflow) expireSubstitution(ctx workflow.Context, assemblyApplicationID, substitutionID string) {
+	w.logger.Info("Substitution was not answered in time", "order_id", w.OrderID, "substitution_id", substitutionID)
+
+	input := &activities.SubstitutionInput{
+		AssemblyApplicationID: assemblyApplicationID,
+		SubstitutionID:        substitutionID,
+	}
+	err := workflow.ExecuteActivity(ctx, a.RejectSubstitution, input).Get(ctx, nil)
+	if err != nil {
+		w.logger.Error("Error to reject substitution", "error", err, "order_id", w.OrderID, "substitution_id", substitutionID)
+		return
+	}
+
+	for i := range w.OrderProcessingState.Substitutions {
+		if w.OrderProcessingState.Substitutions[i].ID == substitutionID {
+			w.OrderProcessingState.Substitutions[i].Expired = true
+		}
+	}
+}
+
+// handleCanceledAssembly decides what to do after the warehouse canceled the assembly. oms-core has returned
+// the order to CREATED, so it is passed to assembly again; the routing skips warehouses that canceled it.
+// When no warehouse is left or the order was canceled maxAssemblyAttempts times, the order is canceled
+// and the processing ends.
+func (w *orderProcessingWorkflow) handleCanceledAssembly(ctx workflow.Context) error {
+	cancellations := w.OrderProcessingState.AssemblyCancellations
+	w.logger.Debug("Handle canceled assembly", "order_id", w.OrderID, "cancellations", len(cancellations))
+
+	if len(cancellations) < maxAssemblyAttempts {
+		err := w.passToAssembly(ctx)
+		if err == nil {
+			w.logger.Info("Order routed to another warehouse", "order_id", w.OrderID,
+				"assembly_application_id", w.OrderProcessingState.AssemblyApplicationID)
+			return nil
+		}
+		w.logger.Warn("Order cannot be routed to another warehouse", "error", err, "order_id", w.OrderID)
+	}
+
+	reason := "assembly application canceled by warehouse"
+	if len(cancellations) > 0 && cancellations[len(cancellations)-1].Reason != "" {
+		reason += ": " + cancellations[len(cancellations)-1].Reason
+	}
+	input := &activities.CancelOrderInput{
+		OrderID: w.OrderID,
+		Reason:  reason,
+	}
+	err := workflow.ExecuteActivity(ctx, a.CancelOrder, input).Get(ctx, nil)
+	if err != nil {
+		w.logger.Error("Error to cancel order", "error", err, "order_id", w.OrderID)
+		return err
+	}
+
+	// Сборку уже отменил склад, компенсировать остается оплату
+	w.cancelOrder(ctx, reason)
 	return nil
 }
+
+// authorizePayment holds the total of the order before it is passed to assembly. A declined payment
+// cancels the order, there is nothing to compensate yet.
+func (w *orderProcessingWorkflow) authorizePayment(ctx workflow.Context) error {
+	input := &activities.Input{
+		OrderID: w.OrderID,
+	}
+	var output activities.AuthorizePaymentOutput
+	err := workflow.ExecuteActivity(ctx, a.AuthorizePayment, input).Get(ctx, &output)
+	if declineReason, declined := w.paymentDeclined(err); declined {
+		return w.cancelDeclinedOrder(ctx, declineReason)
+	}
+	if err != nil {
+		w.logger.Error("Error to authorize payment", "error", err, "order_id", w.OrderID)
+		return err
+	}
+
+	w.logger.Info("Payment authorized", "order_id", w.OrderID, "payment_id", output.PaymentID,
+		"amount", output.Amount.String())
+
+	w.OrderProcessingState.PaymentID = output.PaymentID
+	w.OrderProcessingState.AuthorizedAmount = output.Amount
+	w.addCompensation(compensationStepPayment, func(ctx workflow.Context, reason string) error {
+		return w.cancelPayment(ctx, "order canceled: "+reason)
+	})
+	return nil
+}
+
+// amendOrder records the lines and the total of the amended order. When the total grew above the authorization,
+// the authorization is raised; if the gateway declines, the order is canceled with the decline reason.
+func (w *orderProcessingWorkflow) amendOrder(ctx workflow.Context, payload signals.SignalPayloadAmendOrder) error {
+	w.OrderProcessingState.Items = payload.Items
+	w.OrderProcessingState.TotalAmount = payload.TotalAmount
+
+	if w.OrderProcessingState.PaymentID == "" || payload.TotalAmount <= w.OrderProcessingState.AuthorizedAmount {
+		return nil
+	}
+
+	err := w.reauthorizePayment(ctx)
+	if declineReason, declined := w.paymentDeclined(err); declined {
+		return w.cancelDeclinedOrder(ctx, declineReason)
+	}
+	return err
+}
+
+// reauthorizePayment raises the authorization to the current total of the order after the total grew.
+// An increase the gateway declines fails with the PaymentDeclined error and the authorization stays as it was.
+func (w *orderProcessingWorkflow) reauthorizePayment(ctx workflow.Context) error {
+	if w.OrderProcessingState.PaymentID == "" {
+		return nil
+	}
+
+	input := &activities.ReauthorizePaymentInput{
+		PaymentID: w.OrderProcessingState.PaymentID,
+	}
+	var output activities.AuthorizePaymentOutput
+	if err := workflow.ExecuteActivity(ctx, a.ReauthorizePayment, input).Get(ctx, &output); err != nil {
+		w.logger.Error("Error to reauthorize payment", "error", err, "order_id", w.OrderID, "payment_id", input.PaymentID)
+		return err
+	}
+
+	if output.Amount != w.OrderProcessingState.AuthorizedAmount {
+		w.logger.Info("Payment reauthorized", "order_id", w.OrderID, "payment_id", output.PaymentID,
+			"amount", output.Amount.String())
+	}
+	w.OrderProcessingState.AuthorizedAmount = output.Amount
+	return nil
+}
+
+// paymentDeclined tells whether err is the PaymentDeclined error of a payment activity and returns the decline reason.
+func (w *orderProcessingWorkflow) paymentDeclined(err error) (string, bool) {
+	var appErr *temporal.ApplicationError
+	if !errors.As(err, &appErr) || appErr.Type() != activities.PaymentDeclinedErrorType {
+		return "", false
+	}
+
+	var declineReason string
+	if err := appErr.Details(&declineReason); err != nil {
+		w.logger.Warn("Payment decline without details", "error", err, "order_id", w.OrderID)
+	}
+	return declineReason, true
+}
+
+// cancelDeclinedOrder cancels the order in oms-core because its payment was declined.
+func (w *orderProcessingWorkflow) cancelDeclinedOrder(ctx workflow.Context, declineReason string) error {
+	reason := "payment declined"
+	if declineReason != "" {
+		reason = declineReason
+	}
+	w.logger.Warn("Payment declined", "order_id", w.OrderID, "reason", reason)
+
+	input := &activities.CancelOrderInput{
+		OrderID: w.OrderID,
+		Reason:  reason,
+	}
+	if err := workflow.ExecuteActivity(ctx, a.CancelOrder, input).Get(ctx, nil); err != nil {
+		w.logger.Error("Error to cancel order", "error", err, "order_id", w.OrderID)
+		return err
+	}
+
+	w.cancelOrder(ctx, reason)
+	return nil
+}
+
+// capturePayment charges the cost of the collected lines once the assembly was accepted, so the missing lines
+// are never charged. When the collected lines cost more than was authorized, e.g. after a dearer substitution,
+// the authorization is raised first; if the gateway declines it, the order is canceled. When nothing was
+// collected the authorization is voided.
+func (w *orderProcessingWorkflow) capturePayment(ctx workflow.Context) error {
+	if w.OrderProcessingState.PaymentID == "" {
+		return nil
+	}
+
+	var amount models.Money
+	for _, item := range w.OrderProcessingState.Collected {
+		amount += item.Price.Mul(item.Quantity)
+	}
+
+	if amount == 0 {
+		w.dropCompensation(compensationStepPayment)
+		return w.cancelPayment(ctx, "nothing was collected")
+	}
+	if amount > w.OrderProcessingState.AuthorizedAmount {
+		err := w.reauthorizePayment(ctx)
+		if declineReason, declined := w.paymentDeclined(err); declined {
+			return w.cancelDeclinedOrder(ctx, declineReason)
+		}
+		if err != nil {
+			return err
+		}
+		if authorized := w.OrderProcessingState.AuthorizedAmount; amount > authorized {
+			return fmt.Errorf("collected lines of order %s cost %s, payment %s is authorized for %s",
+				w.OrderID, amount, w.OrderProcessingState.PaymentID, authorized)
+		}
+	}
+
+	input := &activities.CapturePaymentInput{
+		PaymentID: w.OrderProcessingState.PaymentID,
+		Amount:    amount,
+	}
+	if err := workflow.ExecuteActivity(ctx, a.CapturePayment, input).Get(ctx, nil); err != nil {
+		w.logger.Error("Error to capture payment", "error", err, "order_id", w.OrderID,
+			"payment_id", input.PaymentID, "amount", amount.String())
+		return err
+	}
+
+	w.logger.Info("Payment captured", "order_id", w.OrderID, "payment_id", input.PaymentID, "amount", amount.String())
+	w.OrderProcessingState.CapturedAmount = amount
+	return nil
+}
+
+// cancelPayment voids the authorization or refunds the captured amount in oms-core.
+func (w *orderProcessingWorkflow) cancelPayment(ctx workflow.Context, reason string) error {
+	input := &activities.CancelPaymentInput{
+		PaymentID: w.OrderProcessingState.PaymentID,
+		Reason:    reason,
+	}
+	return workflow.ExecuteActivity(ctx, a.CancelPayment, input).Get(ctx, nil)
+}
+
+// handleShortages decides what to do with lines the picker could not collect.
+// The missing lines are not delivered, so their cost is due to the customer as a partial refund.
+func (w *orderProcessingWorkflow) handleShortages() {
+	if len(w.OrderProcessingState.Shortages) == 0 {
+		return
+	}
+
+	var refund models.Money
+	for _, item := range w.OrderProcessingState.Shortages {
+		refund += item.Price.Mul(item.Quantity)
+	}
+	w.OrderProcessingState.RefundAmount = refund
+
+	if len(w.OrderProcessingState.Collected) == 0 {
+		// Ничего не собрано: возвращаем всю сумму, доставлять нечего
+		w.logger.Warn("Nothing was collected", "order_id", w.OrderID, "refund", refund.String())
+		return
+	}
+
+	w.logger.Info("Order was collected partially", "order_id", w.OrderID,
+		"missing_lines", len(w.OrderProcessingState.Shortages), "refund", refund.String())
+}
+
+// addCancelOrderReceive adds the handler of the order cancellation to the selector of a stage the workflow waits in.
+func (w *orderProcessingWorkflow) addCancelOrderReceive(ctx workflow.Context, s workflow.Selector, cancelOrderChannel workflow.ReceiveChannel) {
+	s.AddReceive(cancelOrderChannel, func(c workflow.ReceiveChannel, more bool) {
+		var payload signals.SignalPayloadCancelOrder
+		c.Receive(ctx, &payload)
+
+		w.logger.Debug("Handling cancel order channel", "reason", payload.Reason)
+
+		w.cancelOrder(ctx, payload.Reason)
+	})
+}
+
+// cancelOrder handles the cancellation of the order, which oms-core has already accepted. The steps done
+// for the order are compensated in reverse order and the processing ends as OrderStatusCanceled.
+// A cancellation after the processing reached a final status is rejected.
+func (w *orderProcessingWorkflow) cancelOrder(ctx workflow.Context, reason string) {
+	if w.OrderProcessingState.CurrentState.IsFinalStatus() {
+		w.logger.Warn("Order cannot be canceled, its processing is over", "order_id", w.OrderID,
+			"current_state", w.OrderProcessingState.CurrentState)
+		return
+	}
+
+	w.logger.Info("Order canceled", "order_id", w.OrderID, "reason", reason,
+		"current_state", w.OrderProcessingState.CurrentState)
+
+	w.stopAssemblySLATimer()
+	w.OrderProcessingState.CancellationReason = reason
+	w.compensate(ctx, reason)
+
+	w.OrderProcessingState.CurrentState = OrderStatusCanceled
+	w.pushStatus(ctx, w.OrderProcessingState.CurrentState)
+}
+
+// addCompensation registers how to undo a step. A step done again is undone by its latest compensation,
+// in the place of the latest run.
+func (w *orderProcessingWorkflow) addCompensation(step string, run func(ctx workflow.Context, reason string) error) {
+	w.dropCompensation(step)
+	w.compensations = append(w.compensations, compensation{step: step, run: run})
+}
+
+func (w *orderProcessingWorkflow) dropCompensation(step string) {
+	for i, c := range w.compensations {
+		if c.step == step {
+			w.compensations = append(w.compensations[:i], w.compensations[i+1:]...)
+			return
+		}
+	}
+}
+
+// addAssemblyCompensation registers the cancellation of the assembly application. oms-core releases
+// the stock reserved by an open application and returns the collected items of a complete one.
+func (w *orderProcessingWorkflow) addAssemblyCompensation(assemblyApplicationID string) {
+	w.addCompensation(compensationStepAssembly, func(ctx workflow.Context, reason string) error {
+		input := &activities.CancelAssemblyApplicationInput{
+			AssemblyApplicationID: assemblyApplicationID,
+			Reason:                "order canceled",
+		}
+		if reason != "" {
+			input.Reason += ": " + reason
+		}
+		return workflow.ExecuteActivity(ctx, a.CancelAssemblyApplication, input).Get(ctx, nil)
+	})
+}
+
+// compensate runs the compensations in reverse order of the steps. A failed compensation is recorded
+// and does not stop the others: the order is canceled anyway and the failure is left to an operator.
+func (w *orderProcessingWorkflow) compensate(ctx workflow.Context, reason string) {
+	for i := len(w.compensations) - 1; i >= 0; i-- {
+		c := w.compensations[i]
+
+		result := CompensationResult{Step: c.step}
+		if err := c.run(ctx, reason); err != nil {
+			w.logger.Error("Error to compensate step", "error", err, "order_id", w.OrderID, "step", c.step)
+			result.Error = err.Error()
+		}
+		result.CompensatedAt = workflow.Now(ctx)
+
+		w.OrderProcessingState.Compensations = append(w.OrderProcessingState.Compensations, result)
+	}
+	w.compensations = nil
+}
@@ -13,6 +13,7 @@ const (
 	OrderStatusDelivered
 	OrderStatusCanceled
 	OrderStatusProcessingCompleted
+	OrderStatusAssemblyCanceled
 )
 
 var statusName = map[OrderProcessingStatus]string{
@@ -26,6 +27,7 @@ var statusName = map[OrderProcessingStatus]string{
 	OrderStatusDelivered:             "delivered",
 	OrderStatusCanceled:              "canceled",
 	OrderStatusProcessingCompleted:   "order_processing_completed",
+	OrderStatusAssemblyCanceled:      "assembly_canceled",
 }
 
 func (os OrderProcessingStatus) String() string {
@@ -5,3 +5,7 @@ import "fmt"
 func OrderProcessingWorkflowID(orderID string) string {
 	return fmt.Sprintf("OrderProcessing:%s", orderID)
 }
+
+func DeliveryProcessingWorkflowID(orderID string) string {
+	return fmt.Sprintf("DeliveryProcessing:%s", orderID)
+}